@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// breedingAnnotations maps each eBird breeding code to the iNaturalist
+// annotations it is evidence for (P-083). A code says what the observer saw
+// the bird do, not what a photo shows, so the table only claims what the code
+// says of the bird observed: an adult for a code that describes an adult's
+// behavior, and a nest or eggs as evidence where the code names one. The codes
+// for a nest with eggs or young and for fledged young claim no life stage,
+// since the bird observed is as likely the adult tending them. Codes that
+// describe a bird only present, such as H, are left out; they say nothing an
+// annotation could.
+var breedingAnnotations = map[string][]inat.Annotation{
+	// Confirmed.
+	"NY": {evidence(inat.EvidenceConstruction), alive()},                                 // nest with young
+	"NE": {evidence(inat.EvidenceEgg)},                                                   // nest with eggs
+	"FS": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceOrganism), alive()},     // carrying fecal sac
+	"FY": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceOrganism), alive()},     // feeding young
+	"CF": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceOrganism), alive()},     // carrying food
+	"FL": {evidence(inat.EvidenceOrganism), alive()},                                     // recently fledged young
+	"ON": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceConstruction), alive()}, // occupied nest
+	"UN": {evidence(inat.EvidenceConstruction)},                                          // used nest
+	"DD": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceOrganism), alive()},     // distraction display
+	"NB": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceConstruction), alive()}, // nest building
+	"CN": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceOrganism), alive()},     // carrying nesting material
+	"PE": {lifeStage(inat.LifeStageAdult), evidence(inat.EvidenceOrganism), alive()},     // physiological evidence
+	// Probable.
+	"B":  {evidence(inat.EvidenceConstruction), alive()}, // woodpecker or wren nest building
+	"A":  {evidence(inat.EvidenceOrganism), alive()},     // agitated behavior
+	"N":  {evidence(inat.EvidenceOrganism), alive()},     // visiting probable nest site
+	"C":  {evidence(inat.EvidenceOrganism), alive()},     // courtship, display, or copulation
+	"T":  {evidence(inat.EvidenceOrganism), alive()},     // territorial defense
+	"P":  {evidence(inat.EvidenceOrganism), alive()},     // pair in suitable habitat
+	"M":  {evidence(inat.EvidenceOrganism), alive()},     // multiple singing birds
+	"S7": {evidence(inat.EvidenceOrganism), alive()},     // singing bird present 7+ days
+	// Possible.
+	"S": {evidence(inat.EvidenceOrganism), alive()}, // singing bird
+}
+
+func lifeStage(value int) inat.Annotation {
+	return inat.Annotation{ControlledAttributeID: inat.LifeStageTerm, ControlledValueID: value}
+}
+
+func evidence(value int) inat.Annotation {
+	return inat.Annotation{ControlledAttributeID: inat.EvidenceOfPresenceTerm, ControlledValueID: value}
+}
+
+func alive() inat.Annotation {
+	return inat.Annotation{ControlledAttributeID: inat.AliveOrDeadTerm, ControlledValueID: inat.Alive}
+}
+
+// recordAnnotations returns the annotations rec's breeding code is evidence
+// for.
+func recordAnnotations(rec ebird.Record) []inat.Annotation {
+	return breedingAnnotations[breedingCode(rec)]
+}
+
+// breedingCode returns rec's breeding code, in upper case, or "" if it has
+// none. eBird's export writes the code followed by its description, "FY
+// Feeding Young", so only the first word is read.
+func breedingCode(rec ebird.Record) string {
+	code, _, _ := strings.Cut(strings.TrimSpace(rec.BreedingCode), " ")
+	return strings.ToUpper(code)
+}
+
+// missingAnnotations returns those of want that an observation annotated with
+// have lacks. Life Stage and Alive or Dead take one value each, so an
+// observation that already has one, whoever set it, keeps it; Evidence of
+// Presence takes several.
+func missingAnnotations(want, have []inat.Annotation) []inat.Annotation {
+	var missing []inat.Annotation
+	for _, a := range want {
+		if slices.Contains(have, a) {
+			continue
+		}
+		if a.ControlledAttributeID != inat.EvidenceOfPresenceTerm && slices.ContainsFunc(have, func(h inat.Annotation) bool {
+			return h.ControlledAttributeID == a.ControlledAttributeID
+		}) {
+			continue
+		}
+		missing = append(missing, a)
+	}
+	return missing
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// An executor carries out actions, and counts what it did. All of birdsync's
+// writes to iNaturalist happen here, each behind its own --dryrun gate (T-005).
+type executor struct {
+	ebirdClient ebirdClient
+	inatClient  inatClient
+	taxa        *taxonResolver // looks up what an identification left to it
+	journal     *journal       // records each write; nil records nothing
+	checkpoint  *checkpointer  // records progress; nil records nothing
+	stats       stats
+}
+
+func (x *executor) execute(a action) {
+	s := &x.stats
+	s.totalRecords++
+	switch a.Kind {
+	case skipAction:
+		s.countSkip(a.Skip)
+	case createAction:
+		x.checkpoint.begin(a)
+		obs := *a.Observation
+		if dryRun {
+			log.Printf("DRYRUN: Syncing eBird observation %s to iNaturalist (%d media assets)\n",
+				a.Key, len(a.Media))
+			prettyPrintln(obs)
+		} else {
+			debugf("Syncing eBird observation %s to iNaturalist (%d media assets)\n",
+				a.Key, len(a.Media))
+			err := x.inatClient.CreateObservation(obs)
+			x.journal.record(journalEntry{
+				Op:     opCreate,
+				Source: a.Source,
+				Line:   a.Line,
+				Key:    &a.Key,
+				UUID:   obs.UUID.String(),
+				Error:  errorString(err),
+			})
+			if err != nil {
+				if x.budgetSpent(a, err) {
+					return
+				}
+				log.Fatalf("CreateObservation: %v", err)
+			}
+		}
+		s.createdObservations++
+		x.steps(a, x.identify, x.addMedia, x.annotate)
+	case updateAction:
+		x.checkpoint.begin(a)
+		x.steps(a, x.rekey, x.identify, x.addMedia, x.annotate, x.fillFields)
+	}
+}
+
+// steps carries out the steps of a in order and records a finished, unless
+// the budget runs out partway (budgetSpent). Then the checkpoint keeps a in
+// flight, and the rerun finishes it (P-073).
+func (x *executor) steps(a action, steps ...func(action)) {
+	for _, step := range steps {
+		step(a)
+		if x.stats.outOfBudget != nil {
+			return
+		}
+	}
+	x.checkpoint.finish(a)
+}
+
+// budgetSpent reports whether err is --daily_requests running out partway
+// through a, which overBudget's estimate can fall short of, and if so records
+// it. The run then stops after a, as it would have before a had the estimate
+// been right (P-075), and a counts as left to do.
+func (x *executor) budgetSpent(a action, err error) bool {
+	var budgetErr *inat.BudgetError
+	if !errors.As(err, &budgetErr) {
+		return false
+	}
+	log.Printf("line %d: reached the limit of %d iNaturalist requests partway through; stopping, and a run after %s finishes it",
+		a.Line, budgetErr.Limit, budgetErr.Until.Local().Format("2006-01-02 15:04"))
+	x.stats.outOfBudget = budgetErr
+	x.countRemaining(a)
+	return true
+}
+
+// budgetReserve is how many requests overBudget keeps back beyond its
+// estimate, for the retries and their checks that it can't foresee.
+const budgetReserve = 5
+
+// overBudget reports whether carrying out a would take more iNaturalist
+// requests than --daily_requests has left, and if so records when it won't.
+// Checking before an action rather than failing partway through one means the
+// run stops between records, as it does for an interrupt (P-075, P-074).
+func (x *executor) overBudget(a action) bool {
+	if dryRun {
+		return false
+	}
+	// One request per write, and a second for a write the client checks
+	// after an error before sending it again: a create, an upload, an
+	// annotation, or an identification. Anything more comes out of
+	// budgetReserve, and a write the budget can't cover stops the run
+	// after this record (budgetSpent).
+	const checked = 2
+	n := checked * len(a.Media)
+	if len(a.Media) > 0 || len(a.Attached) > 0 {
+		n++ // the description update
+	}
+	n += checked * len(a.Annotations)
+	if len(a.Fields) > 0 {
+		n++
+	}
+	if a.FormerKey != nil {
+		n++
+	}
+	if a.Identification != nil {
+		n += checked
+		if a.Identification.TaxonID == 0 {
+			n++ // the lookup
+		}
+	}
+	if a.Kind == createAction {
+		n += checked
+	}
+	if n == 0 {
+		return false
+	}
+	budget := x.inatClient.Budget()
+	until, err := budget.Available(n + budgetReserve)
+	if err != nil {
+		log.Fatalf("Checking the iNaturalist request budget: %v", err)
+	}
+	if until.IsZero() {
+		return false
+	}
+	x.stats.outOfBudget = &inat.BudgetError{Limit: budget.Limit(), Until: until}
+	log.Printf("line %d needs %d iNaturalist requests, more than --daily_requests has left until %s; stopping",
+		a.Line, n, until.Local().Format("2006-01-02 15:04"))
+	return true
+}
+
+// countRemaining counts a as work a run that stopped early left undone. A
+// record skipped because its lookup failed is work left too.
+func (x *executor) countRemaining(a action) {
+	if a.Kind != skipAction || a.Skip == skipLookup {
+		x.stats.remaining++
+	}
+}
+
+// addMedia uploads the Maculay Library assets in a.Media to iNaturalist
+// then appends the asset URLs to the description of a.Observation.
+func (x *executor) addMedia(a action) {
+	assetIDs := mlAssetSet{ids: a.Media}
+	if assetIDs.Len() == 0 && len(a.Attached) == 0 {
+		return
+	}
+	s := &x.stats
+	debugf("Adding %d media assets to %s\n",
+		assetIDs.Len(), inat.ObservationURL(a.Observation.UUID))
+
+	obs := inat.Observation{
+		UUID:        a.Observation.UUID,
+		Description: a.Observation.Description,
+	}
+	// uploaded collects the assets that actually made it, so the
+	// description can be built from those alone. The description is
+	// how birdsync remembers what it has uploaded — iNatMLAssets reads
+	// the URLs back out of it on the next run — so listing an asset
+	// that failed makes the failure permanent as well as untrue
+	// (P-040, CR-007).
+	var uploaded, permanentlyFailed mlAssetSet
+	for _, id := range a.Attached {
+		uploaded.Add(id)
+	}
+	// Upload the media
+	for _, id := range assetIDs.ids {
+		if dryRun {
+			log.Printf("DRYRUN: Download ML Asset %s and upload to iNaturalist", id)
+			s.pendingMedia++
+			// A dry run reports what a successful run would do, so the
+			// printed description shows the asset as listed.
+			uploaded.Add(id)
+		} else {
+			filename, isPhoto, err := x.ebirdClient.DownloadMLAsset(id)
+			if err != nil {
+				log.Printf("Couldn't download ML asset %s from eBird: %v", id, err)
+				s.errors++
+				continue
+			}
+			err = x.inatClient.UploadMedia(filename, isPhoto, id, obs.UUID.String())
+			x.journal.record(journalEntry{
+				Op:     opUpload,
+				Source: a.Source,
+				Line:   a.Line,
+				Key:    &a.Key,
+				UUID:   obs.UUID.String(),
+				Asset:  id,
+				Error:  errorString(err),
+			})
+			// The download is a temp file that belongs to us now, so
+			// remove it whether or not the upload worked. Syncing an
+			// account with thousands of assets used to leave one file
+			// per asset behind (T-023). Removing here rather than in a
+			// defer keeps at most one asset on disk at a time.
+			if rmErr := os.Remove(filename); rmErr != nil {
+				debugf("Couldn't remove temp file %s: %v", filename, rmErr)
+			}
+			if x.budgetSpent(a, err) {
+				// What did upload is attached, and the rerun lists it.
+				return
+			}
+			if err != nil {
+				log.Printf("Couldn't upload ML asset %s to iNaturalist: %v", id, err)
+				s.errors++
+				// A refusal of the file itself won't come good on a
+				// later run, so record it rather than re-downloading
+				// and re-uploading it forever (P-063).
+				var statusErr *inat.StatusError
+				if errors.As(err, &statusErr) && statusErr.Permanent() {
+					permanentlyFailed.Add(id)
+				}
+				continue
+			}
+			if isPhoto {
+				s.uploadedPhotos++
+			} else {
+				s.uploadedSounds++
+			}
+			uploaded.Add(id)
+		}
+	}
+	if uploaded.Len() == 0 && permanentlyFailed.Len() == 0 {
+		// Everything failed, and might yet succeed. Don't write an
+		// unchanged description back, and don't count an update that
+		// didn't happen (T-007). The next run tries again.
+		return
+	}
+	for _, id := range uploaded.ids {
+		obs.Description += assetLine(id, true)
+	}
+	for _, id := range permanentlyFailed.ids {
+		obs.Description += assetLine(id, false)
+	}
+	// Update the description
+	if dryRun {
+		log.Printf("DRYRUN: Updating observation %s with %d added media assets\n",
+			obs.URLWithSpecies(), uploaded.Len())
+		prettyPrintln(obs)
+	} else {
+		err := x.inatClient.UpdateObservation(obs)
+		x.journal.record(journalEntry{
+			Op:     opUpdate,
+			Source: a.Source,
+			Line:   a.Line,
+			Key:    &a.Key,
+			UUID:   obs.UUID.String(),
+			Error:  errorString(err),
+		})
+		if err != nil {
+			if x.budgetSpent(a, err) {
+				return
+			}
+			log.Fatalf("UpdateObservation %s: %v", obs.URLWithSpecies(), err)
+		}
+	}
+	s.updatedObservations++
+}
+
+// annotate adds a.Annotations to a.Observation (P-083). An annotation
+// iNaturalist refuses, as it does one that doesn't apply to the taxon, is
+// logged and counted, and the run goes on: the observation is whole without
+// it.
+func (x *executor) annotate(a action) {
+	s := &x.stats
+	obsUUID := a.Observation.UUID
+	for _, annotation := range a.Annotations {
+		if dryRun {
+			log.Printf("DRYRUN: Annotating %s with term %d, value %d",
+				inat.ObservationURL(obsUUID), annotation.ControlledAttributeID, annotation.ControlledValueID)
+			s.annotations++
+			continue
+		}
+		err := x.inatClient.AddAnnotation(obsUUID, annotation)
+		x.journal.record(journalEntry{
+			Op:     opAnnotate,
+			Source: a.Source,
+			Line:   a.Line,
+			Key:    &a.Key,
+			UUID:   obsUUID.String(),
+			Error:  errorString(err),
+		})
+		if x.budgetSpent(a, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't annotate %s: %v", inat.ObservationURL(obsUUID), err)
+			s.annotationErrors++
+			continue
+		}
+		s.annotations++
+	}
+}
+
+// rekey rewrites the eBird Scientific Name field of a.Observation, which
+// holds a.FormerKey's name, to a.Key's (P-089). It goes first, so that an
+// interrupted update is finished under the new key.
+func (x *executor) rekey(a action) {
+	if a.FormerKey == nil {
+		return
+	}
+	s := &x.stats
+	obs := inat.Observation{
+		UUID: a.Observation.UUID,
+		ObservationFieldValuesAttributes: []inat.ObservationFieldValue{
+			{ID: a.KeyValueID, ObservationFieldID: inat.EBirdScientificNameField, Value: a.Key.ScientificName},
+		},
+	}
+	if dryRun {
+		log.Printf("DRYRUN: Re-keying %s from %s to %s\n",
+			inat.ObservationURL(obs.UUID), a.FormerKey.ScientificName, a.Key.ScientificName)
+	} else {
+		err := x.inatClient.UpdateObservation(obs)
+		x.journal.record(journalEntry{
+			Op:        opUpdate,
+			Source:    a.Source,
+			Line:      a.Line,
+			Key:       &a.Key,
+			UUID:      obs.UUID.String(),
+			FormerKey: a.FormerKey,
+			Error:     errorString(err),
+		})
+		if err != nil {
+			if x.budgetSpent(a, err) {
+				return
+			}
+			log.Fatalf("UpdateObservation %s: %v", inat.ObservationURL(obs.UUID), err)
+		}
+	}
+	s.rekeyed++
+}
+
+// identify adds a.Identification, the owner's identification, to
+// a.Observation (P-090), looking its taxon up first if the plan left that to
+// it. One iNaturalist refuses, or whose lookup fails, is logged and counted,
+// and the run goes on: the observation keeps its species guess, and the next
+// run tries again. A name with no taxon leaves it alone.
+func (x *executor) identify(a action) {
+	if a.Identification == nil {
+		return
+	}
+	s := &x.stats
+	ident := *a.Identification
+	if ident.TaxonID == 0 {
+		if dryRun {
+			log.Printf("DRYRUN: Identifying %s as the taxon %s resolves to",
+				inat.ObservationURL(ident.ObservationID), a.Key.ScientificName)
+			s.identifications++
+			return
+		}
+		t, _, err := x.taxa.taxon(ebird.Record{ScientificName: a.Key.ScientificName})
+		if x.budgetSpent(a, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't identify %s: %v", inat.ObservationURL(ident.ObservationID), err)
+			s.identificationErrors++
+			return
+		}
+		if t.ID == 0 {
+			debugf("%s has no iNaturalist taxon; not identifying %s",
+				a.Key.ScientificName, inat.ObservationURL(ident.ObservationID))
+			// With nothing else to do, the record was already synced,
+			// as planning would have found with the name cached.
+			if a.Kind == updateAction && a.FormerKey == nil && len(a.Media) == 0 && len(a.Attached) == 0 && len(a.Annotations) == 0 && len(a.Fields) == 0 {
+				s.countSkip(skipPreviouslySynced)
+			}
+			return
+		}
+		ident.TaxonID = t.ID
+	}
+	if dryRun {
+		log.Printf("DRYRUN: Identifying %s as taxon %d",
+			inat.ObservationURL(ident.ObservationID), ident.TaxonID)
+		s.identifications++
+		return
+	}
+	err := x.inatClient.CreateIdentification(ident)
+	x.journal.record(journalEntry{
+		Op:     opIdentify,
+		Source: a.Source,
+		Line:   a.Line,
+		Key:    &a.Key,
+		UUID:   ident.ObservationID.String(),
+		Error:  errorString(err),
+	})
+	if x.budgetSpent(a, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't identify %s: %v", inat.ObservationURL(ident.ObservationID), err)
+		s.identificationErrors++
+		return
+	}
+	s.identifications++
+}
+
+// fillFields sets a.Fields on a.Observation (P-084). The update sends the
+// fields alone, so the description addMedia wrote is left as it is.
+func (x *executor) fillFields(a action) {
+	if len(a.Fields) == 0 {
+		return
+	}
+	s := &x.stats
+	obs := inat.Observation{
+		UUID:                             a.Observation.UUID,
+		ObservationFieldValuesAttributes: a.Fields,
+	}
+	if dryRun {
+		log.Printf("DRYRUN: Setting %d observation fields on %s\n",
+			len(a.Fields), inat.ObservationURL(obs.UUID))
+		prettyPrintln(obs)
+	} else {
+		err := x.inatClient.UpdateObservation(obs)
+		x.journal.record(journalEntry{
+			Op:     opUpdate,
+			Source: a.Source,
+			Line:   a.Line,
+			Key:    &a.Key,
+			UUID:   obs.UUID.String(),
+			Error:  errorString(err),
+		})
+		if err != nil {
+			if x.budgetSpent(a, err) {
+				return
+			}
+			log.Fatalf("UpdateObservation %s: %v", inat.ObservationURL(obs.UUID), err)
+		}
+	}
+	s.fieldValues += len(a.Fields)
+}
+
+// drift reports each way iNaturalist has changed since p was made that would
+// make one of its actions wrong. A plan is a decision someone reviewed, so
+// apply carries it out only if that decision would still be the one made
+// (P-070).
+func (p syncPlan) drift(ix syncIndex) []string {
+	var problems []string
+	add := func(a action, format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("line %d: %s: ", a.Line, a.Key)+fmt.Sprintf(format, args...))
+	}
+	for _, a := range p.Actions {
+		switch a.Kind {
+		case createAction:
+			if r, ok := ix.previouslySynced[a.Key]; ok {
+				add(a, "planned to create, but %s now exists", inat.ObservationURL(r.UUID))
+				continue
+			}
+			if p.Fuzzy {
+				var commonName string
+				for _, f := range a.Observation.ObservationFieldValuesAttributes {
+					if f.ObservationFieldID == inat.CommonNameField {
+						commonName, _ = f.Value.(string)
+					}
+				}
+				if fk, ok := ix.fuzzy(a.ObservedOn, commonName, a.Key.ScientificName); ok {
+					add(a, "planned to create, but a non-birdsync observation of %s on %s now exists",
+						fk.name, fk.observedDate)
+				}
+			}
+		case updateAction:
+			key := a.Key
+			if a.FormerKey != nil {
+				key = *a.FormerKey
+				if r, ok := ix.previouslySynced[a.Key]; ok {
+					add(a, "planned to re-key %s, but %s is now synced under the new name",
+						inat.ObservationURL(a.Observation.UUID), inat.ObservationURL(r.UUID))
+					continue
+				}
+			}
+			r, ok := ix.previouslySynced[key]
+			switch {
+			case !ok:
+				add(a, "planned to update %s, which no longer exists", inat.ObservationURL(a.Observation.UUID))
+			case r.UUID != a.Observation.UUID:
+				add(a, "planned to update %s, but the record is now synced as %s",
+					inat.ObservationURL(a.Observation.UUID), inat.ObservationURL(r.UUID))
+			case r.Description != a.Observation.Description:
+				add(a, "planned to update %s, but its description has changed", inat.ObservationURL(r.UUID))
+			}
+		}
+	}
+	return problems
+}
+
+// applyPlan carries out p, once it has checked that iNaturalist has not
+// changed under it. It returns an error, having written nothing, if it has.
+// Canceling ctx stops it before the next action (P-074).
+func applyPlan(ctx context.Context, p syncPlan, ebirdClient ebirdClient, inatClient inatClient) (stats, error) {
+	p.restoreFlags()
+	ix, err := downloadIndex(ctx, inatClient, p.UserID)
+	if err != nil {
+		// Stopped before anything was written, or journaled, so every
+		// action is left to do.
+		s := stoppedDownloading(err)
+		for _, a := range p.Actions {
+			if a.Kind != skipAction {
+				s.remaining++
+			}
+		}
+		return s, nil
+	}
+	if problems := p.drift(ix); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Print(problem)
+		}
+		return stats{}, fmt.Errorf("iNaturalist has changed since the plan was made (%d conflicts); run birdsync plan again",
+			len(problems))
+	}
+	x := executor{
+		ebirdClient: ebirdClient,
+		inatClient:  inatClient,
+		taxa:        newTaxonResolver(inatClient),
+		// The plan's hash, not the file's: the CSV may have changed since,
+		// and it's the plan that is being applied.
+		journal: startRun(journalEntry{
+			Command:   "apply",
+			UserID:    p.UserID,
+			CSV:       p.CSV,
+			CSVSHA256: p.CSVSHA256,
+		}),
+	}
+	for _, a := range p.Actions {
+		if ctx.Err() != nil {
+			x.stats.interrupted = true
+			break
+		}
+		if x.stats.outOfBudget != nil || x.overBudget(a) {
+			x.countRemaining(a)
+			continue
+		}
+		x.execute(a)
+	}
+	if x.stats.outOfBudget != nil && ctx.Err() == nil {
+		x.journal.end(x.stats.outOfBudget)
+	} else {
+		x.journal.end(ctx.Err())
+	}
+	return x.stats, nil
+}
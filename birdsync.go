@@ -4,19 +4,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"iter"
 	"log"
+	"maps"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"slices"
-	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Sajmani/birdsync/ebird"
 	"github.com/Sajmani/birdsync/inat"
-	"github.com/google/uuid"
 )
 
 const UserAgent = "birdsync/0.1"
@@ -29,6 +34,15 @@ var (
 	before             dateTimeFlag
 	after              dateTimeFlag
 	positionalAccuracy int
+	configDir          string
+	resume             bool
+	dailyRequests      int
+	dateOrder          ebird.DateOrder
+	excludeCategories  categoriesFlag
+	includeCategories  categoriesFlag
+	fieldsFile         string
+	taxaOverridesFile  string
+	taxonomyFile       string
 )
 
 func init() {
@@ -48,6 +62,34 @@ func init() {
 		"Sync only observations observed after the provided DateTime (2006-01-02 15:04:05). The time can be omitted (2006-01-02).")
 	flag.IntVar(&positionalAccuracy, "positional_accuracy_meters", ebird.PositionalAccuracy,
 		"Positional accuracy in meters of the iNaturalist observations created by birdsync.")
+	flag.StringVar(&configDir, "config_dir", defaultConfigDir(),
+		"Directory where birdsync keeps its journal of what each run did, and its place in an interrupted sync. Empty disables both.")
+	flag.BoolVar(&resume, "resume", true,
+		"Pick up an interrupted sync of the same CSV file after the last line it finished, rather than starting over")
+	flag.TextVar(&dateOrder, "date_order", ebird.AutoDateOrder,
+		"The order of the month and day in eBird dates written with slashes: month-first (5/3/2024 is May 3), day-first (5/3/2024 is 5 March), or auto, which reads the export's dates to tell.")
+	flag.Var(&excludeCategories, "exclude_categories",
+		"Don't sync observations in these eBird taxon categories, separated by commas: species, subspecies, spuh (Melanitta sp.), slash (Aythya marila/affinis), hybrid, domestic, or form.")
+	flag.Var(&includeCategories, "include_categories",
+		"Sync only observations in these eBird taxon categories, separated by commas, as for --exclude_categories.")
+	flag.StringVar(&fieldsFile, "fields", "",
+		"A JSON file mapping eBird columns, or values computed from them, to iNaturalist observation fields, in place of the default mapping.")
+	flag.StringVar(&taxaOverridesFile, "taxa_overrides", "",
+		"A CSV file of eBird scientific names, each with the iNaturalist taxon ID or name to use for it.")
+	flag.StringVar(&taxonomyFile, "taxonomy_changes", "",
+		"A CSV file of eBird taxonomy changes: version, old scientific name, new scientific name. Observations synced under an old name are re-keyed rather than created again.")
+	flag.IntVar(&dailyRequests, "daily_requests", inat.DefaultDailyRequests,
+		"Stop before making more than this many iNaturalist requests in 24 hours, counting earlier runs, and say when the rest can run. 0 sets no limit.")
+}
+
+// defaultConfigDir returns the birdsync directory under the user's
+// configuration directory, or "" on a system that has none.
+func defaultConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "birdsync")
 }
 
 func debugf(format string, args ...any) {
@@ -66,10 +108,14 @@ func prettyPrintln(v any) {
 
 type stats struct {
 	afterSkips, beforeSkips, verifiableSkips, previouslySkips, fuzzySkips int
+	categorySkips                                                         int
 	// invalidSkips counts records whose date, time, or coordinates could not be
 	// parsed. They are skipped rather than fatal: one bad row in a large export
 	// should not end a sync that has already created observations (P-062).
-	invalidSkips                                           int
+	invalidSkips int
+	// lookupSkips counts records whose name couldn't be looked up on
+	// iNaturalist, which a rerun tries again (P-086).
+	lookupSkips                                            int
 	totalRecords, createdObservations, updatedObservations int
 	uploadedPhotos, uploadedSounds                         int
 	// pendingMedia counts the media assets a --dryrun would have uploaded.
@@ -78,39 +124,257 @@ type stats struct {
 	// into uploadedPhotos and uploadedSounds.
 	pendingMedia int
 	errors       int
+	// annotations counts the annotations added, or under --dryrun that
+	// would have been, and annotationErrors those iNaturalist refused
+	// (P-083).
+	annotations, annotationErrors int
+	// fieldValues counts the observation field values set on observations
+	// created by an earlier run (P-084).
+	fieldValues int
+	// rekeyed counts the observations whose sync key was rewritten after
+	// eBird renamed their species (P-089).
+	rekeyed int
+	// identifications counts the owner identifications added, or under
+	// --dryrun that would have been, and identificationErrors those
+	// iNaturalist refused (P-090).
+	identifications, identificationErrors int
+	// interrupted is set when a signal stopped the run before it had
+	// processed every record, which makes every count above partial (P-074).
+	interrupted bool
+	// outOfBudget is set when the run stopped because the next action
+	// needed more iNaturalist requests than --daily_requests had left, and
+	// remaining counts the actions it didn't carry out (P-075).
+	outOfBudget *inat.BudgetError
+	remaining   int
+	// remainingUnknown is set when the run stopped before it could tell
+	// how many actions were left: a sync out of budget while downloading
+	// the user's observations.
+	remainingUnknown bool
+}
+
+// stoppedDownloading returns the stats of a run that downloadIndex stopped
+// with err: interrupted, or out of budget.
+func stoppedDownloading(err error) stats {
+	var s stats
+	if !errors.As(err, &s.outOfBudget) {
+		s.interrupted = true
+	}
+	return s
 }
 
 func main() {
-	flag.Parse()
-	if len(flag.Args()) != 1 {
-		log.Println("usage: birdsync MyEBirdData.csv")
-		flag.Usage()
-		os.Exit(1)
+	// A command, if any, comes before the flags, so that each command reads
+	// like its own program: birdsync plan --fuzzy MyEBirdData.csv plan.json.
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && slices.Contains([]string{"plan", "apply", "undo", "taxa-report"}, args[0]) {
+		command, args = args[0], args[1:]
 	}
+	flag.CommandLine.Parse(args)
+
+	switch command {
+	case "plan":
+		runPlan()
+	case "apply":
+		runApply()
+	case "undo":
+		runUndo()
+	case "taxa-report":
+		runTaxaReport()
+	default:
+		runSync()
+	}
+}
+
+func usage(line string) {
+	log.Println("usage: " + line)
+	flag.Usage()
+	os.Exit(1)
+}
+
+// checkArgs exits if the flags can't match anything, or a CSV file, the
+// --fields file, the --taxa_overrides file, or the --taxonomy_changes file
+// can't be read, before anything contacts iNaturalist or prompts for
+// credentials (P-011, P-012, P-085, P-087, P-089).
+func checkArgs(eBirdCSVFilenames []string) {
 	if !after.Time().IsZero() && !before.Time().IsZero() && after.Time().After(before.Time()) {
 		log.Fatalf("--after (%s) is after --before (%s), won't match any records",
 			after.Time(), before.Time())
 	}
+	if len(includeCategories) > 0 && len(excludeCategories) > 0 {
+		log.Fatal("--include_categories and --exclude_categories can't both be set; list the categories to sync in one of them")
+	}
+	for _, filename := range eBirdCSVFilenames {
+		if f, err := os.Open(filename); err != nil {
+			log.Fatalf("Can't open %s: %v", filename, err)
+		} else {
+			f.Close()
+		}
+	}
+	if fieldsFile != "" {
+		mappings, err := readFieldMappings(fieldsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		observationFields = mappings
+	}
+	if taxaOverridesFile != "" {
+		overrides, err := readTaxonOverrides(taxaOverridesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		taxonOverrides = overrides
+	}
+	if taxonomyFile != "" {
+		changes, err := readTaxonomyChanges(taxonomyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		taxonomyChanges = changes
+	}
+}
 
-	eBirdCSVFilename := flag.Arg(0)
-	if f, err := os.Open(eBirdCSVFilename); err != nil {
-		log.Fatalf("Can't open %s: %v", eBirdCSVFilename, err)
-	} else {
-		f.Close()
+// checkFields checks the observation fields birdsync sets against
+// iNaturalist's definitions of them and the values the exports have for
+// them. It exits if one --fields names can't hold the values it is mapped from
+// (P-085); one of the default mapping's is left out, and the log says why
+// (P-084).
+func checkFields(inatClient inatClient, ebirdClient ebirdClient, eBirdCSVFilenames []string) {
+	// The exports are only read if a field restricts its values.
+	records := func(yield func(ebird.Record, error) bool) {
+		records, err := ebirdClient.Records(eBirdCSVFilenames, dateOrder)
+		if err != nil {
+			yield(ebird.Record{}, err)
+			return
+		}
+		for rec, err := range records {
+			if !yield(rec, err) {
+				return
+			}
+		}
 	}
+	if fieldsFile != "" {
+		if err := checkFieldDatatypes(inatClient, observationFields, records); err != nil {
+			log.Fatalf("%s: %v", fieldsFile, err)
+		}
+		return
+	}
+	fields, err := usableFields(inatClient, observationFields, records)
+	if err != nil {
+		log.Fatal(err)
+	}
+	observationFields = fields
+}
 
-	inatAPIClient := inatClientImpl{
-		client: inat.NewClient(inat.BaseURL, inat.GetAPIToken(), UserAgent),
+// requestsFilename is the count of each user's recent iNaturalist requests,
+// inside --config_dir.
+const requestsFilename = "requests.json"
+
+// newINatClient returns a client for userID, held to --daily_requests. The
+// count is kept in --config_dir so that it carries over between runs; without
+// one it covers this run alone (P-075).
+func newINatClient(userID string) inatClientImpl {
+	client := inat.NewClient(inat.BaseURL, inat.GetAPIToken(), UserAgent)
+	if dailyRequests > 0 {
+		filename := ""
+		if configDir != "" {
+			filename = filepath.Join(configDir, requestsFilename)
+		}
+		client.SetBudget(inat.NewBudget(filename, userID, dailyRequests))
 	}
-	ebirdAPIClient := ebirdClientImpl{}
+	return inatClientImpl{client: client}
+}
+
+// interruptGrace is how long an interrupted run has to finish the observation
+// in progress before it stops regardless. Most take a few seconds; the
+// allowance is for a large sound file on a slow connection.
+const interruptGrace = time.Minute
 
-	stats := birdsync(eBirdCSVFilename, ebirdAPIClient, inat.GetUserID(), inatAPIClient)
+// interruptible returns a context that is canceled on SIGINT or SIGTERM,
+// which the sync loops take as the signal to stop before the next record
+// (P-074). If the record in progress isn't finished within interruptGrace, or
+// a second signal arrives, birdsync exits at once, leaving the checkpoint for
+// the next run to finish it (P-073).
+func interruptible() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop() // so that a second signal kills birdsync the usual way
+		log.Printf("Interrupted: finishing the observation in progress, then stopping (within %v; interrupt again to stop now)",
+			interruptGrace)
+		time.Sleep(interruptGrace)
+		log.Fatalf("The observation in progress didn't finish within %v; run the same command again to finish it",
+			interruptGrace)
+	}()
+	return ctx
+}
 
-	for _, line := range stats.summary() {
+func logSummary(s stats) {
+	for _, line := range s.summary() {
 		log.Print(line)
 	}
 }
 
+// runSync plans and applies in one go: birdsync MyEBirdData.csv, or with
+// several exports to merge, birdsync MyEBirdData.csv old/MyEBirdData.csv.
+func runSync() {
+	if len(flag.Args()) < 1 {
+		usage("birdsync MyEBirdData.csv [more.csv ...]")
+	}
+	eBirdCSVFilenames := flag.Args()
+	checkArgs(eBirdCSVFilenames)
+	userID := inat.GetUserID()
+	inatClient := newINatClient(userID)
+	checkFields(inatClient, ebirdClientImpl{}, eBirdCSVFilenames)
+	stats := birdsync(interruptible(), eBirdCSVFilenames, ebirdClientImpl{}, userID, inatClient)
+	logSummary(stats)
+}
+
+// runPlan writes what a sync would do to a file, and does none of it:
+// birdsync plan MyEBirdData.csv [more.csv ...] plan.json.
+func runPlan() {
+	if len(flag.Args()) < 2 {
+		usage("birdsync plan MyEBirdData.csv [more.csv ...] plan.json")
+	}
+	args := flag.Args()
+	eBirdCSVFilenames, planFilename := args[:len(args)-1], args[len(args)-1]
+	checkArgs(eBirdCSVFilenames)
+	userID := inat.GetUserID()
+	inatClient := newINatClient(userID)
+	checkFields(inatClient, ebirdClientImpl{}, eBirdCSVFilenames)
+	p := makePlan(eBirdCSVFilenames, ebirdClientImpl{}, userID, inatClient)
+	if err := writePlan(planFilename, p); err != nil {
+		log.Fatal(err)
+	}
+	// A plan is a dry run that remembers its decisions, so its summary says
+	// "Would" too (P-060).
+	dryRun = true
+	logSummary(p.tally())
+	log.Printf("Wrote plan of %d actions to %s; review it, then run: birdsync apply %s",
+		len(p.Actions), planFilename, planFilename)
+}
+
+// runApply carries out a plan written by runPlan: birdsync apply plan.json.
+func runApply() {
+	if len(flag.Args()) != 1 {
+		usage("birdsync apply plan.json")
+	}
+	p, err := readPlan(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The plan's decisions were made for one account. Carrying them out in
+	// another would create observations its owner never reviewed.
+	if userID := inat.GetUserID(); userID != p.UserID {
+		log.Fatalf("%s is a plan for iNaturalist user %s, not %s", flag.Arg(0), p.UserID, userID)
+	}
+	stats, err := applyPlan(interruptible(), p, ebirdClientImpl{}, newINatClient(p.UserID))
+	if err != nil {
+		log.Fatal(err)
+	}
+	logSummary(stats)
+}
+
 // summary returns the end-of-run report, one line per entry.
 //
 // It lives outside main so it can be tested. A summary is the only account of
@@ -123,6 +387,18 @@ func (s stats) summary() []string {
 		lines = append(lines, fmt.Sprintf(format, args...))
 	}
 
+	if s.interrupted {
+		// Said first, because everything after it is short of what a
+		// finished run would report.
+		add("PARTIAL: interrupted before the end of the eBird export; run the same command again to finish")
+	}
+	if s.outOfBudget != nil && s.remainingUnknown {
+		add("STOPPED: reached the limit of %d iNaturalist requests a day while downloading your iNaturalist observations; run again after %s to sync",
+			s.outOfBudget.Limit, s.outOfBudget.Until.Local().Format("2006-01-02 15:04"))
+	} else if s.outOfBudget != nil {
+		add("STOPPED: reached the limit of %d iNaturalist requests a day with %d eBird observations left to sync; run again after %s to finish",
+			s.outOfBudget.Limit, s.remaining, s.outOfBudget.Until.Local().Format("2006-01-02 15:04"))
+	}
 	add("Finished processing %d eBird observations", s.totalRecords)
 	add("Skipped %d previously uploaded by birdsync", s.previouslySkips)
 	// A skip counter is only meaningful when the rule producing it was in
@@ -136,12 +412,21 @@ func (s stats) summary() []string {
 	if !before.Time().IsZero() {
 		add("Skipped %d eBird observations after --before", s.beforeSkips)
 	}
+	if len(excludeCategories) > 0 {
+		add("Skipped %d eBird observations in --exclude_categories", s.categorySkips)
+	}
+	if len(includeCategories) > 0 {
+		add("Skipped %d eBird observations outside --include_categories", s.categorySkips)
+	}
 	if verifiable {
 		add("Skipped %d unverifiable eBird observations", s.verifiableSkips)
 	}
 	if s.invalidSkips > 0 {
 		add("Skipped %d eBird observations with unparseable fields", s.invalidSkips)
 	}
+	if s.lookupSkips > 0 {
+		add("Skipped %d eBird observations whose taxon couldn't be looked up; run again to sync them", s.lookupSkips)
+	}
 
 	if dryRun {
 		// The counters are incremented outside the --dryrun gates, so they
@@ -153,309 +438,190 @@ func (s stats) summary() []string {
 		add("Would update %d iNaturalist observations", s.updatedObservations)
 		// A dry run doesn't download the assets, so it can't tell photos from sounds.
 		add("Would upload %d media assets to iNaturalist", s.pendingMedia)
+		if s.annotations > 0 {
+			add("Would add %d annotations to iNaturalist", s.annotations)
+		}
+		if s.fieldValues > 0 {
+			add("Would fill in %d observation fields on iNaturalist", s.fieldValues)
+		}
+		if s.rekeyed > 0 {
+			add("Would re-key %d iNaturalist observations to eBird's new names", s.rekeyed)
+		}
+		if s.identifications > 0 {
+			add("Would add %d owner identifications to iNaturalist", s.identifications)
+		}
 	} else {
 		add("Created %d new iNaturalist observations", s.createdObservations)
 		add("Updated %d iNaturalist observations", s.updatedObservations)
 		add("Uploaded %d photos to iNaturalist", s.uploadedPhotos)
 		add("Uploaded %d sounds to iNaturalist", s.uploadedSounds)
+		if s.annotations > 0 {
+			add("Added %d annotations to iNaturalist", s.annotations)
+		}
+		if s.fieldValues > 0 {
+			add("Filled in %d observation fields on iNaturalist", s.fieldValues)
+		}
+		if s.rekeyed > 0 {
+			add("Re-keyed %d iNaturalist observations to eBird's new names", s.rekeyed)
+		}
+		if s.identifications > 0 {
+			add("Added %d owner identifications to iNaturalist", s.identifications)
+		}
 	}
 	if s.errors > 0 {
 		add("Failed to upload %d media assets", s.errors)
 	}
+	if s.annotationErrors > 0 {
+		add("Failed to add %d annotations", s.annotationErrors)
+	}
+	if s.identificationErrors > 0 {
+		add("Failed to add %d owner identifications", s.identificationErrors)
+	}
 	return lines
 }
 
-func birdsync(eBirdCSVFilename string, ebirdClient ebirdClient, inatUserID string, inatClient inatClient) stats {
-	results, err := inatClient.DownloadObservations(inatUserID, after.Time(), before.Time(),
-		"description", "observed_on", "photos.all", "sounds.all", "taxon.all", "ofvs.all")
-	if err != nil {
-		// Nothing useful can happen without the existing observations: syncing
-		// blind would duplicate everything the user already has.
-		log.Fatalf("Downloading iNaturalist observations: %v", err)
-	}
-
-	previouslySynced := map[ebird.ObservationID]inat.Result{}
-	type fuzzyKey struct {
-		observedDate string // 2006-01-02
-		name         string
-	}
-	fuzzyMatch := map[fuzzyKey][]string{}
-	for _, r := range results {
-		key := ebird.ObservationID{
-			SubmissionID:   r.ObservationFieldValue(inat.EBirdField),
-			ScientificName: r.ObservationFieldValue(inat.EBirdScientificNameField),
-		}
-		if key.Valid() {
-			previouslySynced[key] = r
-		} else {
-			// This iNaturalist observation was not created by birdsync.
-			// Record its date and common name for fuzzy matching.
-			addFuzzy := func(name string) {
-				if name == "" {
-					return // an empty name would match every unnamed eBird record
-				}
-				key := fuzzyKey{
-					observedDate: r.ObservedOn, // iNaturalist always uses format 2006-01-02
-					name:         name,
-				}
-				fuzzyMatch[key] = append(fuzzyMatch[key], r.UUID.String())
-				slices.Sort(fuzzyMatch[key])
-				debugf("fuzzy match: add %s to %+v", r.UUID, key)
-			}
-			addFuzzy(r.Taxon.PreferredCommonName)
-			addFuzzy(r.Taxon.Name)
-		}
+// countSkip counts a record skipped for reason.
+func (s *stats) countSkip(reason skipReason) {
+	switch reason {
+	case skipInvalid:
+		s.invalidSkips++
+	case skipAfter:
+		s.afterSkips++
+	case skipBefore:
+		s.beforeSkips++
+	case skipCategory:
+		s.categorySkips++
+	case skipPreviouslySynced:
+		s.previouslySkips++
+	case skipFuzzy:
+		s.fuzzySkips++
+	case skipUnverifiable:
+		s.verifiableSkips++
+	case skipLookup:
+		s.lookupSkips++
 	}
-	debugf("Previously synced %d observations\n", len(previouslySynced))
+}
 
-	log.Printf("Reading eBird observations from %s", eBirdCSVFilename)
-	records, err := ebirdClient.Records(eBirdCSVFilename)
+// birdsync syncs the records in the eBird exports to iNaturalist, deciding
+// what to do with each record and then doing it before moving on to the next.
+// Several exports are merged into one stream of records (P-080). It picks up
+// an interrupted sync of the same exports where it stopped (P-073). Canceling
+// ctx stops it before the next record (P-074).
+func birdsync(ctx context.Context, eBirdCSVFilenames []string, ebirdClient ebirdClient, inatUserID string, inatClient inatClient) stats {
+	csvSHA256, err := exportsSHA256(eBirdCSVFilenames)
 	if err != nil {
-		log.Fatal(err)
+		debugf("Couldn't hash %s: %v", strings.Join(eBirdCSVFilenames, ", "), err)
 	}
-	var s stats
-	for rec := range records {
-		s.totalRecords++
-		observed, err := rec.Observed()
-		if err != nil {
-			// A malformed row costs that row, not the run. eBird's export
-			// varies between users, so one unparseable date in a twelve
-			// thousand row file is realistic, and aborting partway would
-			// leave the sync half done (P-062).
-			log.Printf("line %d: SKIPPING record with bad date/time: %v", rec.Line, err)
-			s.invalidSkips++
-			continue
-		}
-		// Skip records that were not observed between --after and --before.
-		if !after.Time().IsZero() && observed.Before(after.Time()) {
-			debugf("line %d: SKIPPING record observed on %s (before --after=%s)",
-				rec.Line, observed, after.Time())
-			s.afterSkips++
-			continue
-		}
-		if !before.Time().IsZero() && observed.After(before.Time()) {
-			debugf("line %d: SKIPPING record observed on %s (after --before=%s)",
-				rec.Line, observed, before.Time())
-			s.beforeSkips++
-			continue
+	cp, ix, resuming := loadCheckpoint(inatUserID, csvSHA256)
+	start := journalEntry{
+		Command:   "sync",
+		UserID:    inatUserID,
+		CSV:       strings.Join(eBirdCSVFilenames, ", "),
+		CSVSHA256: csvSHA256,
+	}
+	if resuming {
+		log.Printf("Resuming run %s after %s, without downloading iNaturalist observations again",
+			cp.Run, cp.resumeFrom())
+		start.Resume = cp.Run
+	} else {
+		if ix, err = downloadIndex(ctx, inatClient, inatUserID); err != nil {
+			// Stopped before anything was written, or journaled, and
+			// without the index to tell what is left to sync.
+			s := stoppedDownloading(err)
+			s.remainingUnknown = true
+			return s
 		}
+		cp = checkpoint{
+			UserID:     inatUserID,
+			CSVSHA256:  csvSHA256,
+			Verifiable: verifiable,
+			Fuzzy:      fuzzy,
+			After:      after.Time(),
+			Before:     before.Time(),
+			DateOrder:  dateOrder,
+			Exclude:    excludeCategories,
+			Include:    includeCategories,
 
-		// addMedia uploads the Maculay Library assets in assetIDs to iNaturalist
-		// then appends the asset URLs to the description of observation u.
-		addMedia := func(u uuid.UUID, desc string, assetIDs mlAssetSet) {
-			if assetIDs.Len() == 0 {
-				return
-			}
-			debugf("Adding %d media assets to %s\n",
-				assetIDs.Len(), inat.ObservationURL(u))
-
-			obs := inat.Observation{
-				UUID:        u,
-				Description: desc,
-			}
-			// uploaded collects the assets that actually made it, so the
-			// description can be built from those alone. The description is
-			// how birdsync remembers what it has uploaded — iNatMLAssets reads
-			// the URLs back out of it on the next run — so listing an asset
-			// that failed makes the failure permanent as well as untrue
-			// (P-040, CR-007).
-			var uploaded, permanentlyFailed mlAssetSet
-			// Upload the media
-			for _, id := range assetIDs.ids {
-				if dryRun {
-					log.Printf("DRYRUN: Download ML Asset %s and upload to iNaturalist", id)
-					s.pendingMedia++
-					// A dry run reports what a successful run would do, so the
-					// printed description shows the asset as listed.
-					uploaded.Add(id)
-				} else {
-					filename, isPhoto, err := ebirdClient.DownloadMLAsset(id)
-					if err != nil {
-						log.Printf("Couldn't download ML asset %s from eBird: %v", id, err)
-						s.errors++
-						continue
-					}
-					err = inatClient.UploadMedia(filename, isPhoto, id, obs.UUID.String())
-					// The download is a temp file that belongs to us now, so
-					// remove it whether or not the upload worked. Syncing an
-					// account with thousands of assets used to leave one file
-					// per asset behind (T-023). Removing here rather than in a
-					// defer keeps at most one asset on disk at a time.
-					if rmErr := os.Remove(filename); rmErr != nil {
-						debugf("Couldn't remove temp file %s: %v", filename, rmErr)
-					}
-					if err != nil {
-						log.Printf("Couldn't upload ML asset %s to iNaturalist: %v", id, err)
-						s.errors++
-						// A refusal of the file itself won't come good on a
-						// later run, so record it rather than re-downloading
-						// and re-uploading it forever (P-063).
-						var statusErr *inat.StatusError
-						if errors.As(err, &statusErr) && statusErr.Permanent() {
-							permanentlyFailed.Add(id)
-						}
-						continue
-					}
-					if isPhoto {
-						s.uploadedPhotos++
-					} else {
-						s.uploadedSounds++
-					}
-					uploaded.Add(id)
-				}
-			}
-			if uploaded.Len() == 0 && permanentlyFailed.Len() == 0 {
-				// Everything failed, and might yet succeed. Don't write an
-				// unchanged description back, and don't count an update that
-				// didn't happen (T-007). The next run tries again.
-				return
-			}
-			for _, id := range uploaded.ids {
-				obs.Description += assetLine(id, true)
-			}
-			for _, id := range permanentlyFailed.ids {
-				obs.Description += assetLine(id, false)
-			}
-			// Update the description
-			if dryRun {
-				log.Printf("DRYRUN: Updating observation %s with %d added media assets\n",
-					obs.URLWithSpecies(), uploaded.Len())
-				prettyPrintln(obs)
-			} else {
-				err = inatClient.UpdateObservation(obs)
-				if err != nil {
-					log.Fatalf("UpdateObservation %s: %v", obs.URLWithSpecies(), err)
-				}
-			}
-			s.updatedObservations++
+			SettingsSHA256: settingsSHA256(),
 		}
-
-		// Skip records that have previously been uploaded by birdsync.
-		key := rec.ObservationID()
-		if r, ok := previouslySynced[key]; ok {
-			debugf("line %d: Already synced %s to iNaturalist as %s\n",
-				rec.Line, key, r.URLWithSpecies())
-			addedMediaIDs, summary := mediaChange(rec, r)
-			if summary != "" {
-				log.Printf("Media assets differ between eBird %s and iNaturalist %s: %s",
-					rec.URLWithSpecies(), r.URLWithSpecies(), summary)
-			}
-			if addedMediaIDs.Len() == 0 {
-				s.previouslySkips++
-				continue
+	}
+	ix.taxa = newTaxonResolver(inatClient)
+	x := executor{
+		ebirdClient: ebirdClient,
+		inatClient:  inatClient,
+		taxa:        ix.taxa,
+		journal:     startRun(start),
+	}
+	x.checkpoint = startCheckpoint(x.journal, cp)
+	records := readRecords(ebirdClient, eBirdCSVFilenames)
+	if !resuming {
+		x.checkpoint.snapshot(ix)
+	} else {
+		if cp.InFlight != nil {
+			x.finishInFlight(*cp.InFlight)
+			if x.stats.outOfBudget == nil {
+				x.checkpoint.finish(*cp.InFlight)
 			}
-			addMedia(r.UUID, r.Description, addedMediaIDs)
-			continue
 		}
-
-		if fuzzy {
-			// Skip records for the same bird and date as an existing non-birdsync observation.
-			// eBird writes dates in several formats, so compare against the parsed
-			// observation date rather than the raw CSV field, which may be "1/2/2006".
-			checkFuzzy := func(name string) bool {
-				if name == "" {
-					return false // an empty name would match every unnamed taxon
-				}
-				key := fuzzyKey{
-					name:         name,
-					observedDate: observed.Format(time.DateOnly),
-				}
-				debugf("line %d: fuzzy match: check %+v", rec.Line, key)
-				if _, ok := fuzzyMatch[key]; ok {
-					log.Printf("line %d: SKIPPING fuzzy match: observation for same bird and date: %+v", rec.Line, key)
-					s.fuzzySkips++
-					return true
-				}
-				return false
-			}
-			if checkFuzzy(rec.CommonName) || checkFuzzy(rec.ScientificName) {
-				continue
-			}
+		records = recordsAfter(records, cp.resumeFrom())
+	}
+	for a := range ix.plan(records) {
+		if ctx.Err() != nil {
+			// Stop between records, never inside one: an observation
+			// created without its media is what stopping anywhere else
+			// leaves behind. The checkpoint stays, for the rerun.
+			x.stats.interrupted = true
+			x.journal.end(ctx.Err())
+			return x.stats
 		}
-
-		assetIDs := eBirdMLAssets(rec.MLCatalogNumbers)
-		// Skip records without media assets if --verifiable is set. This is
-		// checked before the record is parsed any further, so that a record is
-		// counted against the rule that actually skipped it (P-026).
-		if verifiable && assetIDs.Len() == 0 {
-			debugf("line %d: SKIPPING record that has no photos or sounds (--verifiable=true)", rec.Line)
-			s.verifiableSkips++
+		if x.stats.outOfBudget == nil {
+			// A lookup that found --daily_requests spent stops the
+			// run before the record it skipped, as overBudget would.
+			x.stats.outOfBudget = ix.taxa.outOfBudget()
+		}
+		if x.stats.outOfBudget != nil || x.overBudget(a) {
+			// Planning writes nothing, so the rest of the export is
+			// still read, to say how much is left.
+			x.countRemaining(a)
 			continue
 		}
+		x.execute(a)
+	}
+	if dryRun {
+		ix.taxa.reportUnresolved()
+	}
+	if x.stats.outOfBudget != nil {
+		// Like an interrupt, this keeps the checkpoint for the rerun.
+		x.journal.end(x.stats.outOfBudget)
+		return x.stats
+	}
+	x.checkpoint.remove()
+	x.journal.end(nil)
+	return x.stats
+}
 
-		// Create the iNaturalist observation from the eBird record.
-		coordinate := func(s string) (float64, error) {
-			if s == "" {
-				return 0, nil // eBird omits coordinates for some locations
+func readRecords(ebirdClient ebirdClient, eBirdCSVFilenames []string) iter.Seq[ebird.Record] {
+	log.Printf("Reading eBird observations from %s", strings.Join(eBirdCSVFilenames, ", "))
+	records, err := ebirdClient.Records(eBirdCSVFilenames, dateOrder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return func(yield func(ebird.Record) bool) {
+		logged := map[string]bool{} // exports whose extra columns were logged
+		for rec, err := range records {
+			if err != nil {
+				// The records before this one have been dealt with. Once
+				// the file is fixed, a rerun skips them as already synced.
+				log.Fatal(err)
 			}
-			return strconv.ParseFloat(s, 64)
-		}
-		latitude, err := coordinate(rec.Latitude)
-		if err != nil {
-			log.Printf("line %d: SKIPPING record with bad latitude %q: %v", rec.Line, rec.Latitude, err)
-			s.invalidSkips++
-			continue
-		}
-		longitude, err := coordinate(rec.Longitude)
-		if err != nil {
-			log.Printf("line %d: SKIPPING record with bad longitude %q: %v", rec.Line, rec.Longitude, err)
-			s.invalidSkips++
-			continue
-		}
-		keyField := func(id int, s string) inat.ObservationFieldValue {
-			return inat.ObservationFieldValue{
-				ObservationFieldID: id,
-				Value:              s,
+			if len(rec.Extra) > 0 && !logged[rec.Source] {
+				logged[rec.Source] = true
+				debugf("%s has columns birdsync doesn't read: %s",
+					rec.Source, strings.Join(slices.Sorted(maps.Keys(rec.Extra)), ", "))
 			}
-		}
-		obs := inat.Observation{
-			UUID:               uuid.New(),
-			CaptiveFlag:        false, // eBird checklists should only include wild birds
-			Latitude:           latitude,
-			Longitude:          longitude,
-			LocationIsExact:    false,
-			PositionalAccuracy: float64(positionalAccuracy),
-			SpeciesGuess:       rec.ScientificName,
-			ObservedOnString:   rec.Date + " " + rec.Time,
-			ObservationFieldValuesAttributes: []inat.ObservationFieldValue{
-				keyField(inat.CountField, rec.Count),
-				keyField(inat.CommonNameField, rec.CommonName),
-				keyField(inat.LocationField, rec.Location),
-				keyField(inat.CountyField, rec.County),
-				keyField(inat.StateOrProvinceField, rec.StateProvince),
-				keyField(inat.NumObserversField, rec.NumberOfObservers),
-				// EBirdField and EBirdScientificNameField are used to match iNaturalist observations
-				// to the corresponding eBird checklist and species entry. We cannot rely on the taxon
-				// in the iNaturalist observation because it may be changed after upload.
-				keyField(inat.EBirdField, rec.SubmissionID),
-				keyField(inat.EBirdScientificNameField, rec.ScientificName),
-			},
-		}
-		obs.Description = "Observation created using github.com/Sajmani/birdsync \n"
-		if len(rec.ObservationDetails) > 0 {
-			obs.Description += "eBird observation details:\n" +
-				rec.ObservationDetails + "\n"
-		}
-		obs.Description += "Checklist: " + rec.URL() + "\n"
-		obs.Description += "Protocol: " + rec.Protocol + "\n"
-		if len(rec.ChecklistComments) > 0 {
-			obs.Description += "eBird checklist comments:\n" +
-				rec.ChecklistComments + "\n"
-		}
-		if dryRun {
-			log.Printf("DRYRUN: Syncing eBird observation %s to iNaturalist (%d media assets)\n",
-				key, assetIDs.Len())
-			prettyPrintln(obs)
-		} else {
-			debugf("Syncing eBird observation %s to iNaturalist (%d media assets)\n",
-				key, assetIDs.Len())
-			err = inatClient.CreateObservation(obs)
-			if err != nil {
-				log.Fatalf("CreateObservation: %v", err)
+			if !yield(rec) {
+				return
 			}
 		}
-		s.createdObservations++
-		addMedia(obs.UUID, obs.Description, assetIDs)
 	}
-	return s
 }
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"iter"
+	"log"
+	"maps"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -22,10 +31,10 @@ type mockEBirdClient struct {
 	downloaded []string
 }
 
-func (m *mockEBirdClient) Records(path string) (iter.Seq[ebird.Record], error) {
-	return func(yield func(ebird.Record) bool) {
+func (m *mockEBirdClient) Records(paths []string, order ebird.DateOrder) (iter.Seq2[ebird.Record, error], error) {
+	return func(yield func(ebird.Record, error) bool) {
 		for _, r := range m.records {
-			if !yield(r) {
+			if !yield(r, nil) {
 				return
 			}
 		}
@@ -74,13 +83,64 @@ type mockINatClient struct {
 	// a transient one. Until this existed no test set any of the error fields.
 	failUploads map[string]error
 
+	// crashOn kills the run, as Ctrl-C would, at one call: "create:S123"
+	// before the create of checklist S123 reaches iNaturalist, or
+	// "upload:11111" after the upload of asset 11111 reached it but before
+	// the reply did, or "update:<uuid>" before an update of that
+	// observation reaches iNaturalist. It ends the calling goroutine, so a test runs the
+	// doomed sync on a goroutine of its own (see interruptedSync).
+	crashOn string
+
+	// interruptOn calls interrupt at the upload of one asset, as a signal
+	// arriving partway through a record would.
+	interruptOn string
+	interrupt   func()
+
+	// downloads counts the downloads of the account, whole or of what
+	// changed, and queries every call to QueryObservations and
+	// CountObservations, which is every request the real client would make
+	// for 200 observations or fewer.
+	downloads int
+	queries   int
+
+	// budget is what Budget returns. The mock's calls don't spend it, so a
+	// test sets how much is left by writing its file.
+	budget *inat.Budget
+
+	// fieldDefinitions are the observation fields GetObservationFields knows.
+	fieldDefinitions []inat.ObservationField
+	// taxa are the taxa SearchTaxa searches, searches counts its calls, and
+	// searchErr is what it returns if set.
+	taxa      []inat.Taxon
+	searches  int
+	searchErr error
+	// identifyErr is what CreateIdentification returns if set.
+	identifyErr error
+
 	// Every mutating call is recorded, so a test can assert both what birdsync
 	// sent and — for --dryrun — that it sent nothing at all. Without this the
 	// dry-run guarantee (T-005, P-051) can only be checked indirectly through
 	// the counters, which is exactly the mistake CR-001 records.
-	created  []inat.Observation
-	updated  []inat.Observation
-	uploaded []uploadedMedia
+	created    []inat.Observation
+	updated    []inat.Observation
+	deleted    []uuid.UUID
+	uploaded   []uploadedMedia
+	annotated  []annotatedObservation
+	identified []inat.Identification
+
+	// persisted is how much of the above persist has already applied.
+	persisted struct {
+		created, updated []inat.Observation
+		uploaded         []uploadedMedia
+		annotated        []annotatedObservation
+		identified       []inat.Identification
+	}
+}
+
+// annotatedObservation records one call to mockINatClient.AddAnnotation.
+type annotatedObservation struct {
+	obsUUID    uuid.UUID
+	annotation inat.Annotation
 }
 
 func (m *mockINatClient) GetUserID() string {
@@ -91,28 +151,272 @@ func (m *mockINatClient) GetAPIToken() string {
 	return m.apitoken
 }
 
-func (m *mockINatClient) DownloadObservations(userID string, after, before time.Time, fields ...string) ([]inat.Result, error) {
-	return m.observations, m.downloadErr
+func (m *mockINatClient) Budget() *inat.Budget {
+	return m.budget
+}
+
+// QueryObservations yields the observations q selects that haven't been
+// deleted, and then downloadErr. It stops with ctx's error once ctx is
+// canceled, as the client does between pages.
+func (m *mockINatClient) QueryObservations(ctx context.Context, q inat.ObservationQuery, fields ...string) iter.Seq2[inat.Result, error] {
+	if q.IDAbove == 0 && q.IDBelow == 0 {
+		m.downloads++
+	}
+	m.queries++
+	return func(yield func(inat.Result, error) bool) {
+		for _, r := range m.selected(q) {
+			if err := ctx.Err(); err != nil {
+				yield(inat.Result{}, err)
+				return
+			}
+			if !yield(r, nil) {
+				return
+			}
+		}
+		if m.downloadErr != nil {
+			yield(inat.Result{}, m.downloadErr)
+		}
+	}
+}
+
+func (m *mockINatClient) CountObservations(ctx context.Context, q inat.ObservationQuery) (int, error) {
+	m.queries++
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return len(m.selected(q)), m.downloadErr
+}
+
+// selected returns the observations q selects, as iNaturalist would, in id
+// order. An observation a test made without an id is given the next one, and
+// so is each of its field values.
+func (m *mockINatClient) selected(q inat.ObservationQuery) []inat.Result {
+	const dateFormat = "2006-01-02"
+	var results []inat.Result
+	for i := range m.observations {
+		r := &m.observations[i]
+		if r.ID == 0 {
+			r.ID = m.nextID()
+		}
+		for j := range r.Ofvs {
+			if r.Ofvs[j].ID == 0 {
+				r.Ofvs[j].ID = m.nextOfvID()
+			}
+		}
+		switch {
+		case slices.Contains(m.deleted, r.UUID),
+			q.IDAbove > 0 && r.ID <= q.IDAbove,
+			q.IDBelow > 0 && r.ID >= q.IDBelow,
+			!q.D1.IsZero() && r.ObservedOn < q.D1.Format(dateFormat),
+			!q.D2.IsZero() && r.ObservedOn > q.D2.Format(dateFormat):
+			continue
+		}
+		if !q.UpdatedSince.IsZero() {
+			updated, _ := time.Parse(time.RFC3339, r.UpdatedAt)
+			if updated.Before(q.UpdatedSince) {
+				continue
+			}
+		}
+		results = append(results, *r)
+	}
+	slices.SortFunc(results, func(a, b inat.Result) int { return a.ID - b.ID })
+	return results
+}
+
+func (m *mockINatClient) nextID() int {
+	id := 0
+	for _, r := range m.observations {
+		id = max(id, r.ID)
+	}
+	return id + 1
+}
+
+func (m *mockINatClient) nextOfvID() int {
+	id := 0
+	for _, r := range m.observations {
+		for _, ofv := range r.Ofvs {
+			id = max(id, ofv.ID)
+		}
+	}
+	return id + 1
+}
+
+// GetObservations returns those of observations with the given UUIDs that
+// haven't been deleted.
+func (m *mockINatClient) GetObservations(uuids []uuid.UUID, fields ...string) ([]inat.Result, error) {
+	var results []inat.Result
+	for _, r := range m.observations {
+		if slices.Contains(uuids, r.UUID) && !slices.Contains(m.deleted, r.UUID) {
+			results = append(results, r)
+		}
+	}
+	return results, m.downloadErr
 }
 
 func (m *mockINatClient) CreateObservation(obs inat.Observation) error {
+	for _, f := range obs.ObservationFieldValuesAttributes {
+		if f.ObservationFieldID == inat.EBirdField && m.crashOn == fmt.Sprint("create:", f.Value) {
+			runtime.Goexit()
+		}
+	}
 	m.created = append(m.created, obs)
 	return m.createObsErr
 }
 
+// UpdateObservation refuses a value for a field the observation has that
+// doesn't name the value it replaces, which iNaturalist would add as a second.
 func (m *mockINatClient) UpdateObservation(obs inat.Observation) error {
+	for _, r := range m.observations {
+		if r.UUID != obs.UUID {
+			continue
+		}
+		for _, f := range obs.ObservationFieldValuesAttributes {
+			i := slices.IndexFunc(r.Ofvs, func(o inat.Ofv) bool { return o.FieldID == f.ObservationFieldID })
+			if i >= 0 && r.Ofvs[i].ID != f.ID {
+				return fmt.Errorf("UpdateObservation: %s already has value %d of field %d, and the update names %d",
+					obs.UUID, r.Ofvs[i].ID, f.ObservationFieldID, f.ID)
+			}
+		}
+	}
+	if m.crashOn == "update:"+obs.UUID.String() {
+		runtime.Goexit()
+	}
 	m.updated = append(m.updated, obs)
 	return m.updateObsErr
 }
 
+func (m *mockINatClient) DeleteObservation(u uuid.UUID) error {
+	m.deleted = append(m.deleted, u)
+	return nil
+}
+
 func (m *mockINatClient) UploadMedia(filename string, isPhoto bool, assetID, obsUUID string) error {
 	if err, ok := m.failUploads[assetID]; ok {
 		return err
 	}
 	m.uploaded = append(m.uploaded, uploadedMedia{filename, isPhoto, assetID, obsUUID})
+	if m.crashOn == "upload:"+assetID {
+		runtime.Goexit()
+	}
+	if m.interruptOn == "upload:"+assetID {
+		m.interrupt()
+	}
 	return m.uploadMediaErr
 }
 
+func (m *mockINatClient) GetObservationFields(ids []int) ([]inat.ObservationField, error) {
+	var fields []inat.ObservationField
+	for _, f := range m.fieldDefinitions {
+		if slices.Contains(ids, f.ID) {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+func (m *mockINatClient) SearchTaxa(q string) ([]inat.Taxon, error) {
+	m.searches++
+	if m.searchErr != nil {
+		return nil, m.searchErr
+	}
+	var taxa []inat.Taxon
+	for _, t := range m.taxa {
+		if strings.EqualFold(t.Name, q) || strings.EqualFold(t.MatchedTerm, q) {
+			taxa = append(taxa, t)
+		}
+	}
+	return taxa, nil
+}
+
+func (m *mockINatClient) CreateIdentification(ident inat.Identification) error {
+	if m.identifyErr != nil {
+		return m.identifyErr
+	}
+	m.identified = append(m.identified, ident)
+	return nil
+}
+
+func (m *mockINatClient) AddAnnotation(obsUUID uuid.UUID, a inat.Annotation) error {
+	m.annotated = append(m.annotated, annotatedObservation{obsUUID, a})
+	return nil
+}
+
+// persist makes the observations the mock has been asked to create, and the
+// media and descriptions it has been asked to add, part of its account, as
+// iNaturalist would. The mock doesn't do this as it goes, so that a test can
+// tell what a run wrote from what was there before.
+func (m *mockINatClient) persist() {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, obs := range m.created[len(m.persisted.created):] {
+		r := inat.Result{ID: m.nextID(), UUID: obs.UUID, ObservedOn: obs.ObservedOnString, Description: obs.Description, UpdatedAt: now}
+		for _, f := range obs.ObservationFieldValuesAttributes {
+			v, _ := f.Value.(string)
+			r.Ofvs = append(r.Ofvs, inat.Ofv{FieldID: f.ObservationFieldID, Value: v})
+		}
+		// A taxon_id makes an identification of the owner's, with no body.
+		if obs.TaxonID != 0 {
+			r.Taxon = inat.Taxon{ID: obs.TaxonID}
+			r.Identifications = []inat.IdentificationResult{{Current: true, OwnObservation: true, Taxon: r.Taxon}}
+		}
+		m.observations = append(m.observations, r)
+	}
+	find := func(u string) *inat.Result {
+		for i := range m.observations {
+			if m.observations[i].UUID.String() == u {
+				return &m.observations[i]
+			}
+		}
+		return nil
+	}
+	for _, u := range m.uploaded[len(m.persisted.uploaded):] {
+		if r := find(u.obsUUID); r != nil {
+			r.Sounds = append(r.Sounds, inat.Sound{OriginalFilename: "ML" + u.assetID + ".mp3"})
+			r.UpdatedAt = now
+		}
+	}
+	for _, obs := range m.updated[len(m.persisted.updated):] {
+		if r := find(obs.UUID.String()); r != nil {
+			if obs.Description != "" {
+				r.Description = obs.Description
+			}
+			// A value with an id replaces that one; UpdateObservation
+			// refused any other for a field the observation has.
+			for _, f := range obs.ObservationFieldValuesAttributes {
+				v, _ := f.Value.(string)
+				if i := slices.IndexFunc(r.Ofvs, func(o inat.Ofv) bool { return f.ID != 0 && o.ID == f.ID }); i >= 0 {
+					r.Ofvs[i].Value = v
+					continue
+				}
+				r.Ofvs = append(r.Ofvs, inat.Ofv{ID: m.nextOfvID(), FieldID: f.ObservationFieldID, Value: v})
+			}
+			r.UpdatedAt = now
+		}
+	}
+	for _, a := range m.annotated[len(m.persisted.annotated):] {
+		if r := find(a.obsUUID.String()); r != nil {
+			r.Annotations = append(r.Annotations, a.annotation)
+			r.UpdatedAt = now
+		}
+	}
+	m.persisted.created, m.persisted.uploaded, m.persisted.updated = m.created, m.uploaded, m.updated
+	for _, ident := range m.identified[len(m.persisted.identified):] {
+		if r := find(ident.ObservationID.String()); r != nil {
+			// A new identification of the owner's replaces their last.
+			for i := range r.Identifications {
+				if r.Identifications[i].OwnObservation {
+					r.Identifications[i].Current = false
+				}
+			}
+			r.Identifications = append(r.Identifications, inat.IdentificationResult{
+				Current: true, OwnObservation: true, Body: ident.Body, Taxon: inat.Taxon{ID: ident.TaxonID},
+			})
+			r.UpdatedAt = now
+		}
+	}
+	m.persisted.annotated = m.annotated
+	m.persisted.identified = m.identified
+}
+
 // resetFlags restores the package-level flag variables to their defaults, so a
 // test doesn't inherit state from whichever test ran before it. The date flags
 // must be zeroed directly: dateTimeFlag.Set rejects the empty string, so
@@ -124,6 +428,18 @@ func resetFlags() {
 	after = dateTimeFlag{}
 	before = dateTimeFlag{}
 	positionalAccuracy = ebird.PositionalAccuracy
+	configDir = "" // no journal: a test that wants one points this at t.TempDir()
+	resume = true
+	dailyRequests = inat.DefaultDailyRequests
+	dateOrder = ebird.AutoDateOrder
+	excludeCategories = nil
+	includeCategories = nil
+	fieldsFile = ""
+	taxaOverridesFile = ""
+	taxonOverrides = nil
+	taxonomyFile = ""
+	taxonomyChanges = nil
+	observationFields = defaultObservationFields
 }
 
 // TestBirdsync exercises the full skip order against one set of records:
@@ -245,7 +561,7 @@ func TestBirdsync(t *testing.T) {
 	verifiable = true
 	fuzzy = true
 
-	stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if stats.totalRecords != 8 {
 		t.Errorf("Expected 8 total records, got %d", stats.totalRecords)
@@ -321,7 +637,7 @@ func TestUpdateMedia(t *testing.T) {
 	resetFlags()
 	verifiable = false
 
-	stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if stats.totalRecords != 1 {
 		t.Errorf("Expected 1 total records, got %d", stats.totalRecords)
@@ -382,7 +698,7 @@ func TestFuzzyMatchDateFormats(t *testing.T) {
 			resetFlags()
 			fuzzy = true
 
-			stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+			stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 			if stats.fuzzySkips != 1 {
 				t.Errorf("Expected 1 fuzzy skip for date %q, got %d", tc.date, stats.fuzzySkips)
@@ -420,7 +736,7 @@ func TestFuzzyMatchIgnoresEmptyNames(t *testing.T) {
 	resetFlags()
 	fuzzy = true
 
-	stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if stats.fuzzySkips != 0 {
 		t.Errorf("Expected 0 fuzzy skips, got %d", stats.fuzzySkips)
@@ -452,7 +768,7 @@ func TestDryRunMediaCount(t *testing.T) {
 	dryRun = true
 	defer func() { dryRun = false }()
 
-	stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if stats.pendingMedia != 2 {
 		t.Errorf("Expected 2 pending media assets, got %d", stats.pendingMedia)
@@ -488,6 +804,7 @@ func TestDryRunIssuesNoWrites(t *testing.T) {
 			Date:             "2023-01-03",
 			Time:             "03:00 PM",
 			MLCatalogNumbers: "33333 44444",
+			BreedingCode:     "FY Feeding Young",
 		},
 		{
 			// A previously synced record with an asset added since: would be
@@ -510,13 +827,17 @@ func TestDryRunIssuesNoWrites(t *testing.T) {
 			{FieldID: inat.EBirdField, Value: "S301"},
 			{FieldID: inat.EBirdScientificNameField, Value: "Turdus migratorius"},
 		},
-	}}}
+	}}, taxa: []inat.Taxon{
+		// Both names resolve, so both records would be identified.
+		{ID: 8021, Name: "Corvus brachyrhynchos", IsActive: true, AncestorIDs: []int{3, 7823, 8021}},
+		{ID: 12727, Name: "Turdus migratorius", IsActive: true, AncestorIDs: []int{3, 12716, 12727}},
+	}}
 
 	resetFlags()
 	dryRun = true
 	defer func() { dryRun = false }()
 
-	birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if len(mockInat.created) != 0 {
 		t.Errorf("--dryrun created %d observations, want 0: %+v", len(mockInat.created), mockInat.created)
@@ -527,6 +848,12 @@ func TestDryRunIssuesNoWrites(t *testing.T) {
 	if len(mockInat.uploaded) != 0 {
 		t.Errorf("--dryrun uploaded %d media assets, want 0: %+v", len(mockInat.uploaded), mockInat.uploaded)
 	}
+	if len(mockInat.annotated) != 0 {
+		t.Errorf("--dryrun added %d annotations, want 0: %+v", len(mockInat.annotated), mockInat.annotated)
+	}
+	if len(mockInat.identified) != 0 {
+		t.Errorf("--dryrun added %d identifications, want 0: %+v", len(mockInat.identified), mockInat.identified)
+	}
 }
 
 // TestInvalidRecordsAreSkipped checks that a record birdsync can't parse costs
@@ -586,7 +913,7 @@ func TestInvalidRecordsAreSkipped(t *testing.T) {
 
 	resetFlags()
 
-	stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if stats.totalRecords != 4 {
 		t.Errorf("totalRecords = %d, want 4", stats.totalRecords)
@@ -645,7 +972,7 @@ func TestUntaxonedObservationIsRecognized(t *testing.T) {
 
 	resetFlags()
 
-	stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if stats.previouslySkips != 1 {
 		t.Errorf("Expected the untaxoned observation to be recognized as already synced, got %d skips", stats.previouslySkips)
@@ -655,6 +982,329 @@ func TestUntaxonedObservationIsRecognized(t *testing.T) {
 	}
 }
 
+// TestTaxonCategories checks that --exclude_categories skips the categories
+// it names, and --include_categories the ones it doesn't, and counts them, and
+// that a domestic type is created as captive.
+//
+// Verifies: P-082.
+func TestTaxonCategories(t *testing.T) {
+	resetFlags()
+	if err := excludeCategories.Set("slash, spuh"); err != nil {
+		t.Fatal(err)
+	}
+	var records []ebird.Record
+	for i, name := range []string{"Melanitta sp.", "Aythya marila/affinis", "Cairina moschata (Domestic type)", "Corvus brachyrhynchos"} {
+		rec := crowRecord(fmt.Sprintf("S9%02d", i))
+		rec.ScientificName = name
+		records = append(records, rec)
+	}
+	mockInat := &mockINatClient{}
+
+	s := birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if s.categorySkips != 2 || len(mockInat.created) != 2 {
+		t.Fatalf("skipped %d and created %d, want the spuh and slash skipped and the rest created", s.categorySkips, len(mockInat.created))
+	}
+	if domestic, wild := mockInat.created[0], mockInat.created[1]; !domestic.CaptiveFlag || wild.CaptiveFlag {
+		t.Errorf("CaptiveFlag = %v for the domestic type and %v for the crow, want true and false",
+			domestic.CaptiveFlag, wild.CaptiveFlag)
+	}
+	if got := strings.Join(s.summary(), "\n"); !strings.Contains(got, "Skipped 2 eBird observations in --exclude_categories") {
+		t.Errorf("summary doesn't count the categories skipped:\n%s", got)
+	}
+	if err := excludeCategories.Set("spuh,falcon"); err == nil {
+		t.Error(`--exclude_categories accepted "falcon"`)
+	}
+
+	resetFlags()
+	if err := includeCategories.Set("species,domestic"); err != nil {
+		t.Fatal(err)
+	}
+	mockInat = &mockINatClient{}
+	s = birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	if s.categorySkips != 2 || len(mockInat.created) != 2 {
+		t.Errorf("with --include_categories, skipped %d and created %d, want the spuh and slash skipped and the rest created",
+			s.categorySkips, len(mockInat.created))
+	}
+	if got := strings.Join(s.summary(), "\n"); !strings.Contains(got, "Skipped 2 eBird observations outside --include_categories") {
+		t.Errorf("summary doesn't count the categories skipped:\n%s", got)
+	}
+}
+
+// TestBreedingCodeAnnotations checks that a created observation is annotated
+// from its record's breeding code, that one synced before is annotated with
+// what it lacks, leaving a life stage someone else chose, and that a run
+// after that has nothing left to add.
+//
+// Verifies: P-083.
+func TestBreedingCodeAnnotations(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	fledgling := crowRecord("S950")
+	fledgling.BreedingCode = "FL Recently Fledged young"
+	carrying := crowRecord("S951")
+	carrying.BreedingCode = "CF"
+	juvenile := inat.Annotation{ControlledAttributeID: inat.LifeStageTerm, ControlledValueID: inat.LifeStageJuvenile}
+	synced := inat.Result{
+		UUID:        uuid.New(),
+		ObservedOn:  "2023-01-03",
+		Description: mlAssetURL("11111"),
+		Sounds:      []inat.Sound{{OriginalFilename: "ML11111.mp3"}},
+		Ofvs: []inat.Ofv{
+			{FieldID: inat.EBirdField, Value: "S951"},
+			{FieldID: inat.EBirdScientificNameField, Value: "Corvus brachyrhynchos"},
+		},
+		Annotations: []inat.Annotation{juvenile},
+	}
+	mockInat := &mockINatClient{observations: []inat.Result{synced}}
+
+	run := syncRun(t, mockInat, fledgling, carrying)
+
+	// A fledgling code says nothing of the bird observed's age; carrying
+	// food says it is an adult, but someone has said otherwise.
+	created := mockInat.created[0].UUID
+	want := []annotatedObservation{
+		{created, inat.Annotation{ControlledAttributeID: inat.EvidenceOfPresenceTerm, ControlledValueID: inat.EvidenceOrganism}},
+		{created, inat.Annotation{ControlledAttributeID: inat.AliveOrDeadTerm, ControlledValueID: inat.Alive}},
+		{synced.UUID, inat.Annotation{ControlledAttributeID: inat.EvidenceOfPresenceTerm, ControlledValueID: inat.EvidenceOrganism}},
+		{synced.UUID, inat.Annotation{ControlledAttributeID: inat.AliveOrDeadTerm, ControlledValueID: inat.Alive}},
+	}
+	if !slices.Equal(mockInat.annotated, want) {
+		t.Errorf("annotated %+v, want %+v", mockInat.annotated, want)
+	}
+	n := 0
+	for _, e := range readTestJournal(t) {
+		if e.Run == run.Run && e.Op == opAnnotate {
+			n++
+		}
+	}
+	if n != len(want) {
+		t.Errorf("journaled %d annotations, want %d", n, len(want))
+	}
+
+	syncRun(t, mockInat, fledgling, carrying)
+	if len(mockInat.annotated) != len(want) {
+		t.Errorf("second run added %d more annotations, want none", len(mockInat.annotated)-len(want))
+	}
+}
+
+// TestChecklistFieldsBackfilled checks that an observation synced before
+// birdsync set the checklist's distance has it set by an update that sends
+// nothing else, and not the duration or protocol, which have no default
+// field; that one whose checklist recorded no distance isn't updated; and
+// that a run after that has nothing left to set.
+//
+// Verifies: P-084.
+func TestChecklistFieldsBackfilled(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	traveling := crowRecord("S960")
+	traveling.Protocol = "eBird - Traveling Count"
+	traveling.DistanceTraveledKm = "2.5"
+	traveling.DurationMin = "45"
+	traveling.AllObsReported = "1"
+	unrecorded := crowRecord("S961")
+	synced := func(submissionID string) inat.Result {
+		return inat.Result{
+			UUID:        uuid.New(),
+			ObservedOn:  "2023-01-03",
+			Description: mlAssetURL("11111"),
+			Sounds:      []inat.Sound{{OriginalFilename: "ML11111.mp3"}},
+			Ofvs: []inat.Ofv{
+				{FieldID: inat.EBirdField, Value: submissionID},
+				{FieldID: inat.EBirdScientificNameField, Value: "Corvus brachyrhynchos"},
+			},
+		}
+	}
+	mockInat := &mockINatClient{observations: []inat.Result{synced("S960"), synced("S961")}}
+
+	syncRun(t, mockInat, traveling, unrecorded)
+
+	if len(mockInat.updated) != 1 {
+		t.Fatalf("made %d updates, want 1: %+v", len(mockInat.updated), mockInat.updated)
+	}
+	got := mockInat.updated[0]
+	want := []inat.ObservationFieldValue{
+		{ObservationFieldID: inat.DistanceField, Value: "2.5"},
+	}
+	if got.UUID != mockInat.observations[0].UUID || !reflect.DeepEqual(got.ObservationFieldValuesAttributes, want) {
+		t.Errorf("updated %s with fields %+v, want %s with %+v",
+			got.UUID, got.ObservationFieldValuesAttributes, mockInat.observations[0].UUID, want)
+	}
+	if got.Description != "" {
+		t.Errorf("field update sent description %q, want none", got.Description)
+	}
+
+	syncRun(t, mockInat, traveling, unrecorded)
+	if len(mockInat.updated) != 1 {
+		t.Errorf("second run made %d more updates, want none", len(mockInat.updated)-1)
+	}
+}
+
+// identifiedTaxa returns the taxon of each identification m was asked to add,
+// by observation.
+func identifiedTaxa(m *mockINatClient) map[uuid.UUID]int {
+	taxa := map[uuid.UUID]int{}
+	for _, ident := range m.identified {
+		taxa[ident.ObservationID] = ident.TaxonID
+	}
+	return taxa
+}
+
+// TestOwnerIdentifications checks that a created observation, sent without a
+// taxon ID, and one synced before birdsync identified what it created, are
+// given the owner's identification of their resolved taxon, noting the
+// checklist, and only that one; that one whose owner withdrew an identification isn't; and that
+// a refused identification doesn't end the run.
+//
+// Verifies: P-090.
+func TestOwnerIdentifications(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	synced := func(submissionID string, identifications ...inat.IdentificationResult) inat.Result {
+		return inat.Result{
+			UUID:            uuid.New(),
+			ObservedOn:      "2023-01-03",
+			Description:     mlAssetURL("11111"),
+			Sounds:          []inat.Sound{{OriginalFilename: "ML11111.mp3"}},
+			Identifications: identifications,
+			Ofvs: []inat.Ofv{
+				{FieldID: inat.EBirdField, Value: submissionID},
+				{FieldID: inat.EBirdScientificNameField, Value: "Corvus brachyrhynchos"},
+			},
+		}
+	}
+	mockInat := &mockINatClient{
+		observations: []inat.Result{
+			synced("S1011"),
+			synced("S1012", inat.IdentificationResult{OwnObservation: true, Taxon: inat.Taxon{ID: 7823}}),
+		},
+		taxa: []inat.Taxon{{ID: 8021, Name: "Corvus brachyrhynchos", IsActive: true, AncestorIDs: []int{3, 7823, 8021}}},
+	}
+
+	syncRun(t, mockInat, crowRecord("S1010"), crowRecord("S1011"), crowRecord("S1012"))
+
+	if len(mockInat.created) != 1 {
+		t.Fatalf("created %d observations, want 1", len(mockInat.created))
+	}
+	if mockInat.created[0].TaxonID != 0 {
+		t.Errorf("created with taxon_id %d, want the taxon in the identification alone", mockInat.created[0].TaxonID)
+	}
+	want := map[uuid.UUID]int{
+		mockInat.created[0].UUID:      8021,
+		mockInat.observations[0].UUID: 8021,
+	}
+	if got := identifiedTaxa(mockInat); !maps.Equal(got, want) {
+		t.Errorf("identified %v, want %v", got, want)
+	}
+	for _, ident := range mockInat.identified {
+		if !strings.Contains(ident.Body, "eBird checklist https://ebird.org/checklist/S101") {
+			t.Errorf("identification body %q doesn't name the eBird checklist", ident.Body)
+		}
+	}
+
+	for _, r := range mockInat.observations {
+		if r.UUID != mockInat.created[0].UUID {
+			continue
+		}
+		if n := len(slices.DeleteFunc(slices.Clone(r.Identifications), func(ident inat.IdentificationResult) bool { return !ident.OwnObservation })); n != 1 {
+			t.Errorf("created observation has %d identifications of the owner's, want 1", n)
+		}
+	}
+
+	syncRun(t, mockInat, crowRecord("S1010"), crowRecord("S1011"), crowRecord("S1012"))
+	if len(mockInat.identified) != 2 {
+		t.Errorf("second run added %d more identifications, want none", len(mockInat.identified)-2)
+	}
+
+	refusing := &mockINatClient{
+		taxa:        mockInat.taxa,
+		identifyErr: errors.New("422 Unprocessable Entity"),
+	}
+	configDir = t.TempDir()
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: []ebird.Record{crowRecord("S1013")}}, "myUserID", refusing)
+	if len(refusing.created) != 1 || stats.identificationErrors != 1 || stats.identifications != 0 {
+		t.Errorf("with identifications refused, created %d and counted %d errors and %d identifications, want 1, 1, 0",
+			len(refusing.created), stats.identificationErrors, stats.identifications)
+	}
+}
+
+// TestIdentifySyncedWhenCarriedOut checks that the taxon of an observation
+// synced before birdsync identified what it created is looked up only when
+// the identification is carried out: not by a plan or a dry run, which leave
+// its name out of the unresolved ones they log, and within the budget's
+// estimate. A name with no taxon leaves the observation already synced.
+//
+// Verifies: P-090, P-086, P-075.
+func TestIdentifySyncedWhenCarriedOut(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	jay := crowRecord("S1021")
+	jay.ScientificName = "Cyanocitta stelleri"
+	records := []ebird.Record{crowRecord("S1020"), jay}
+	synced := func(rec ebird.Record) inat.Result {
+		return inat.Result{
+			UUID:        uuid.New(),
+			ObservedOn:  "2023-01-03",
+			Description: mlAssetURL("11111"),
+			Sounds:      []inat.Sound{{OriginalFilename: "ML11111.mp3"}},
+			Ofvs: []inat.Ofv{
+				{FieldID: inat.EBirdField, Value: rec.SubmissionID},
+				{FieldID: inat.EBirdScientificNameField, Value: rec.ScientificName},
+			},
+		}
+	}
+	mockInat := &mockINatClient{
+		observations: []inat.Result{synced(records[0]), synced(jay)},
+		taxa:         []inat.Taxon{{ID: 8021, Name: "Corvus brachyrhynchos", IsActive: true, AncestorIDs: []int{3, 7823, 8021}}},
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	dryRun = true
+	s := birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	dryRun = false
+	p := makePlan([]string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	log.SetOutput(os.Stderr)
+	if mockInat.searches != 0 {
+		t.Errorf("a dry run and a plan made %d searches, want none", mockInat.searches)
+	}
+	if s.identifications != 2 {
+		t.Errorf("dry run counted %d identifications, want 2", s.identifications)
+	}
+	if strings.Contains(logs.String(), "No iNaturalist taxon for") {
+		t.Errorf("logged the names of synced observations as unresolved:\n%s", logs.String())
+	}
+	for _, a := range p.Actions {
+		if a.Identification == nil || a.Identification.TaxonID != 0 {
+			t.Errorf("plan identifies line %d as %+v, want the taxon left to apply", a.Line, a.Identification)
+		}
+	}
+
+	budgetFile := filepath.Join(configDir, requestsFilename)
+	x := executor{inatClient: &mockINatClient{budget: inat.NewBudget(budgetFile, "myUserID", budgetReserve+2)}}
+	if !x.overBudget(p.Actions[0]) {
+		t.Errorf("overBudget left out the lookup of an identification the plan left to apply")
+	}
+
+	if _, err := applyPlan(context.Background(), p, &mockEBirdClient{records: records}, mockInat); err != nil {
+		t.Fatal(err)
+	}
+	mockInat.persist()
+	if want := map[uuid.UUID]int{mockInat.observations[0].UUID: 8021}; !maps.Equal(identifiedTaxa(mockInat), want) {
+		t.Errorf("identified %v, want %v", identifiedTaxa(mockInat), want)
+	}
+	if mockInat.searches != 2 {
+		t.Errorf("apply made %d searches, want one for each name", mockInat.searches)
+	}
+
+	s = birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	if s.previouslySkips != 2 || mockInat.searches != 2 {
+		t.Errorf("rerun skipped %d as already synced, with %d searches in all; want 2 and 2", s.previouslySkips, mockInat.searches)
+	}
+}
+
 // TestCreatedObservationContent pins down what birdsync actually sends to
 // iNaturalist. Until the mock recorded its arguments, every requirement in
 // "What birdsync writes" was unverified: the tests could only see counters.
@@ -679,6 +1329,7 @@ func TestCreatedObservationContent(t *testing.T) {
 		Time:               "03:00 PM",
 		Protocol:           "Stationary",
 		NumberOfObservers:  "2",
+		DistanceTraveledKm: "1.2",
 		ObservationDetails: "perched on a snag",
 		ChecklistComments:  "windy morning",
 		MLCatalogNumbers:   "33333",
@@ -688,7 +1339,7 @@ func TestCreatedObservationContent(t *testing.T) {
 
 	resetFlags()
 
-	birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if len(mockInat.created) != 1 {
 		t.Fatalf("Expected 1 created observation, got %d", len(mockInat.created))
@@ -724,6 +1375,7 @@ func TestCreatedObservationContent(t *testing.T) {
 		inat.CountyField:              "Santa Clara",
 		inat.StateOrProvinceField:     "US-CA",
 		inat.NumObserversField:        "2",
+		inat.DistanceField:            "1.2",
 		inat.EBirdField:               "S400",
 		inat.EBirdScientificNameField: "Corvus brachyrhynchos",
 	}
@@ -775,6 +1427,26 @@ func TestCreatedObservationContent(t *testing.T) {
 	}
 }
 
+// TestDayFirstDatesSentUnambiguously checks that a day-first eBird date
+// reaches iNaturalist in a form it can't read the other way round, and that
+// a 24-hour time is understood.
+//
+// Verifies: P-038, P-078.
+func TestDayFirstDatesSentUnambiguously(t *testing.T) {
+	resetFlags()
+	rec := crowRecord("S401")
+	rec.Date, rec.Time, rec.DateOrder = "03/05/2024", "07:30", ebird.DayFirst
+	mockInat := &mockINatClient{}
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: []ebird.Record{rec}}, "myUserID", mockInat)
+
+	if len(mockInat.created) != 1 {
+		t.Fatalf("created %d observations, want 1", len(mockInat.created))
+	}
+	if got, want := mockInat.created[0].ObservedOnString, "2024-05-03 07:30 AM"; got != want {
+		t.Errorf("ObservedOnString = %q, want %q", got, want)
+	}
+}
+
 // TestTempFilesAreCleanedUp checks that downloaded media doesn't accumulate in
 // the system temp directory. Each asset is downloaded to a temp file and
 // uploaded; nothing deleted them afterwards, so a full sync of an account with
@@ -802,7 +1474,7 @@ func TestTempFilesAreCleanedUp(t *testing.T) {
 
 	resetFlags()
 
-	birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if len(mockEbird.downloaded) != 2 {
 		t.Fatalf("Downloaded %d assets, want 2", len(mockEbird.downloaded))
@@ -945,7 +1617,7 @@ func TestFailedUploadIsRetriedNextRun(t *testing.T) {
 	firstInat := &mockINatClient{failUploads: map[string]error{bad: errors.New("upload failed")}}
 
 	resetFlags()
-	first := birdsync("MyEBirdData.csv", firstEbird, "myUserID", firstInat)
+	first := birdsync(context.Background(), []string{"MyEBirdData.csv"}, firstEbird, "myUserID", firstInat)
 
 	if first.errors != 1 {
 		t.Errorf("First run: errors = %d, want 1", first.errors)
@@ -978,7 +1650,7 @@ func TestFailedUploadIsRetriedNextRun(t *testing.T) {
 	}}}
 
 	resetFlags()
-	second := birdsync("MyEBirdData.csv", secondEbird, "myUserID", secondInat)
+	second := birdsync(context.Background(), []string{"MyEBirdData.csv"}, secondEbird, "myUserID", secondInat)
 
 	if second.errors != 0 {
 		t.Errorf("Second run: errors = %d, want 0", second.errors)
@@ -1015,7 +1687,7 @@ func TestAllUploadsFailingLeavesNoUpdate(t *testing.T) {
 	mockInat := &mockINatClient{failUploads: map[string]error{"80003": errors.New("upload failed"), "80004": errors.New("upload failed")}}
 
 	resetFlags()
-	stats := birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+	stats := birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 	if stats.errors != 2 {
 		t.Errorf("errors = %d, want 2", stats.errors)
@@ -1064,7 +1736,7 @@ func TestPermanentUploadFailureIsNotRetried(t *testing.T) {
 	}}
 
 	resetFlags()
-	birdsync("MyEBirdData.csv", firstEbird, "myUserID", firstInat)
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, firstEbird, "myUserID", firstInat)
 
 	if len(firstInat.updated) != 1 {
 		t.Fatalf("Expected the observation to be updated to record the failure, got %d updates", len(firstInat.updated))
@@ -1090,7 +1762,7 @@ func TestPermanentUploadFailureIsNotRetried(t *testing.T) {
 	}}}
 
 	resetFlags()
-	second := birdsync("MyEBirdData.csv", secondEbird, "myUserID", secondInat)
+	second := birdsync(context.Background(), []string{"MyEBirdData.csv"}, secondEbird, "myUserID", secondInat)
 
 	if len(secondInat.uploaded) != 0 {
 		t.Errorf("Retried an asset the service permanently refused: %+v (P-063)", secondInat.uploaded)
@@ -1134,7 +1806,7 @@ func TestTransientUploadFailureIsRetried(t *testing.T) {
 			mockInat := &mockINatClient{failUploads: map[string]error{"90002": tc.err}}
 
 			resetFlags()
-			birdsync("MyEBirdData.csv", mockEbird, "myUserID", mockInat)
+			birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
 
 			for _, u := range mockInat.updated {
 				if strings.Contains(u.Description, failedMarker) {
@@ -1145,3 +1817,197 @@ func TestTransientUploadFailureIsRetried(t *testing.T) {
 		})
 	}
 }
+
+// TestInterruptFinishesObservationInProgress checks that a run interrupted
+// partway through a record finishes that record — every upload and the
+// description update — then stops without starting the next, and says its
+// summary is partial.
+//
+// Verifies: P-074.
+func TestInterruptFinishesObservationInProgress(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	rec := crowRecord("S800")
+	rec.MLCatalogNumbers = "81111 82222"
+	next := crowRecord("S801")
+	next.Line = 3
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockInat := &mockINatClient{interruptOn: "upload:81111", interrupt: cancel}
+
+	s := birdsync(ctx, []string{"MyEBirdData.csv"}, &mockEBirdClient{records: []ebird.Record{rec, next}}, "myUserID", mockInat)
+
+	if len(mockInat.created) != 1 || len(mockInat.uploaded) != 2 || len(mockInat.updated) != 1 {
+		t.Errorf("created %d, uploaded %d, updated %d; want S800 finished: 1, 2, 1",
+			len(mockInat.created), len(mockInat.uploaded), len(mockInat.updated))
+	}
+	if !s.interrupted || s.totalRecords != 1 {
+		t.Errorf("stats = %+v, want interrupted after 1 record", s)
+	}
+	if got := s.summary()[0]; !strings.HasPrefix(got, "PARTIAL") {
+		t.Errorf("summary starts %q, want it to say it is partial", got)
+	}
+	entries := readTestJournal(t)
+	if last := entries[len(entries)-1]; last.Op != opEnd || last.Error == "" {
+		t.Errorf("journal ends with %+v, want an end entry saying the run was stopped", last)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, checkpointFilename)); err != nil {
+		t.Errorf("interrupted run left no checkpoint to resume from: %v", err)
+	}
+}
+
+// TestInterruptDuringDownload checks that a run interrupted while it is
+// downloading the user's observations stops there, having written nothing,
+// and says its summary is partial.
+//
+// Verifies: P-074.
+func TestInterruptDuringDownload(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mockInat := &mockINatClient{observations: []inat.Result{{Taxon: inat.Taxon{Name: "Corvus corax"}}}}
+
+	s := birdsync(ctx, []string{"MyEBirdData.csv"}, &mockEBirdClient{records: []ebird.Record{crowRecord("S802")}}, "myUserID", mockInat)
+
+	if len(mockInat.created) != 0 || s.totalRecords != 0 {
+		t.Errorf("created %d after reading %d records, want nothing read or written", len(mockInat.created), s.totalRecords)
+	}
+	if got := s.summary()[0]; !strings.HasPrefix(got, "PARTIAL") {
+		t.Errorf("summary starts %q, want it to say it is partial", got)
+	}
+}
+
+// TestStopsAtDailyRequestBudget checks that a run stops before the first
+// record the rest of --daily_requests can't cover, says how much is left and
+// when it can go on, and that the rerun picks up from there.
+//
+// Verifies: P-075.
+func TestStopsAtDailyRequestBudget(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	verifiable = false
+	records, filename := resumeFixture(t)
+	records[0].MLCatalogNumbers = "" // two requests: the create and its check
+	// Eight of twenty left, spent by earlier runs an hour ago. S700 needs
+	// two and S701 five, the create and upload with their checks and the
+	// description, and each the reserve of five more.
+	spentAt := time.Now().Add(-time.Hour).Truncate(10 * time.Minute).UTC()
+	budgetFile := filepath.Join(configDir, requestsFilename)
+	spent := fmt.Sprintf(`{"myUserID":[{"start":%q,"count":12}]}`, spentAt.Format(time.RFC3339))
+	if err := os.WriteFile(budgetFile, []byte(spent), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mockInat := &mockINatClient{budget: inat.NewBudget(budgetFile, "myUserID", 20)}
+
+	s := birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if got, want := createdKeys(mockInat), []string{"S700"}; !slices.Equal(got, want) {
+		t.Errorf("created %v, want %v", got, want)
+	}
+	if s.outOfBudget == nil || s.remaining != 2 {
+		t.Fatalf("stats = %+v, want stopped with 2 left", s)
+	}
+	if want := spentAt.Add(10*time.Minute + 24*time.Hour); !s.outOfBudget.Until.Equal(want) {
+		t.Errorf("can go on at %v, want %v, when the earlier requests leave the window", s.outOfBudget.Until, want)
+	}
+	if got := s.summary()[0]; !strings.HasPrefix(got, "STOPPED") || !strings.Contains(got, "2 eBird observations left") {
+		t.Errorf("summary starts %q, want it to say the run stopped with 2 left", got)
+	}
+	entries := readTestJournal(t)
+	if last := entries[len(entries)-1]; last.Op != opEnd || last.Error == "" {
+		t.Errorf("journal ends with %+v, want an end entry saying the run was stopped", last)
+	}
+
+	// A day later the budget is back.
+	mockInat.persist()
+	if err := os.Remove(budgetFile); err != nil {
+		t.Fatal(err)
+	}
+	s = birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if got, want := createdKeys(mockInat), []string{"S700", "S701", "S702"}; !slices.Equal(got, want) {
+		t.Errorf("created %v, want %v, each once", got, want)
+	}
+	if mockInat.downloads != 1 || s.outOfBudget != nil {
+		t.Errorf("rerun downloaded the account %d times and stopped with %v; want it to resume and finish",
+			mockInat.downloads, s.outOfBudget)
+	}
+}
+
+// TestBudgetSpentPartway checks that a write the budget refuses, partway
+// through a record, stops the run cleanly after it, and that the rerun
+// finishes that record and goes on.
+//
+// Verifies: P-075, P-073.
+func TestBudgetSpentPartway(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	records, filename := resumeFixture(t)
+	until := time.Now().Add(time.Hour)
+	spent := fmt.Errorf("UploadMedia: %w", &inat.BudgetError{Limit: 10, Until: until})
+	mockInat := &mockINatClient{failUploads: map[string]error{"72222": spent}}
+
+	s := birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if got, want := createdKeys(mockInat), []string{"S700", "S701"}; !slices.Equal(got, want) {
+		t.Errorf("created %v, want %v", got, want)
+	}
+	if s.outOfBudget == nil || s.remaining != 2 || s.errors != 0 {
+		t.Fatalf("stats = %+v, want stopped with S701 and S702 left and no errors", s)
+	}
+	if got := s.summary()[0]; !strings.HasPrefix(got, "STOPPED") || !strings.Contains(got, "2 eBird observations left") {
+		t.Errorf("summary starts %q, want it to say the run stopped with 2 left", got)
+	}
+
+	mockInat.persist()
+	delete(mockInat.failUploads, "72222")
+	s = birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if got, want := createdKeys(mockInat), []string{"S700", "S701", "S702"}; !slices.Equal(got, want) {
+		t.Errorf("created %v, want %v, each once", got, want)
+	}
+	uploads := map[string]int{}
+	for _, u := range mockInat.uploaded {
+		uploads[u.assetID]++
+	}
+	if uploads["72222"] != 1 || s.outOfBudget != nil || mockInat.downloads != 1 {
+		t.Errorf("rerun uploaded S701's asset %d times, stopped with %v, and downloaded %d times; want it resumed and finished",
+			uploads["72222"], s.outOfBudget, mockInat.downloads)
+	}
+}
+
+// TestBudgetSpentDuringDownload checks that a sync or an apply that spends
+// the budget while downloading the user's observations stops there, having
+// written nothing, and says when it can go on.
+//
+// Verifies: P-075.
+func TestBudgetSpentDuringDownload(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	until := time.Now().Add(time.Hour)
+	spent := fmt.Errorf("QueryObservations: %w", &inat.BudgetError{Limit: 10, Until: until})
+
+	mockEbird, mockInat := planFixture()
+	p := makePlan([]string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+	mockInat.downloadErr = spent
+	s, err := applyPlan(context.Background(), p, mockEbird, mockInat)
+	if err != nil {
+		t.Fatalf("applyPlan() error = %v", err)
+	}
+	if s.outOfBudget == nil || s.remaining != 2 || len(mockInat.created)+len(mockInat.updated) != 0 {
+		t.Errorf("apply stats = %+v after %d creates and %d updates, want stopped with the plan's 2 actions left and nothing written",
+			s, len(mockInat.created), len(mockInat.updated))
+	}
+	if got := s.summary()[0]; !strings.HasPrefix(got, "STOPPED") || !strings.Contains(got, "2 eBird observations left") {
+		t.Errorf("apply summary starts %q, want it to say the run stopped with 2 left", got)
+	}
+
+	s = birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+	if s.outOfBudget == nil || !s.outOfBudget.Until.Equal(until) || len(mockInat.created) != 0 {
+		t.Errorf("sync stats = %+v after %d creates, want stopped until %v and nothing written", s, len(mockInat.created), until)
+	}
+	if got := s.summary()[0]; !strings.HasPrefix(got, "STOPPED") || !strings.Contains(got, "while downloading") {
+		t.Errorf("sync summary starts %q, want it to say the run stopped while downloading", got)
+	}
+}
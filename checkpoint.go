@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// The checkpoint and the index it was taken against, inside --config_dir.
+const (
+	checkpointFilename      = "checkpoint.json"
+	checkpointIndexFilename = "checkpoint-index.json"
+)
+
+// checkpointMaxAge is how long after its last progress an interrupted sync can
+// be resumed. The index snapshot doesn't see changes made to the account since
+// it was taken; a rerun the same day is a retry, and one a week later should
+// look at the account afresh.
+const checkpointMaxAge = 24 * time.Hour
+
+// A checkpoint is how far a sync run has got, so that a rerun over the same
+// export can pick up after the last line that finished rather than starting
+// over (P-073).
+//
+// Resuming is sound because records are processed in CSV order, the exports
+// of a merge in the same order each time, and the run wrote nothing for a
+// line it hadn't reached. Lines up to Done are finished,
+// and the index snapshot is still right for the lines after them, since
+// nothing birdsync did to the account touched those, but for the re-keys,
+// which are recorded in Rekeyed. The one exception is InFlight, the action
+// that was under way when the run died, which is re-checked against
+// iNaturalist.
+type checkpoint struct {
+	Run       string    `json:"run"`
+	Updated   time.Time `json:"updated"`
+	UserID    string    `json:"user_id"`
+	CSVSHA256 string    `json:"csv_sha256"`
+
+	// The flags that shaped the decisions. A rerun with different ones
+	// would decide differently, so it starts over.
+	Verifiable bool      `json:"verifiable"`
+	Fuzzy      bool      `json:"fuzzy"`
+	After      time.Time `json:"after"`
+	Before     time.Time `json:"before"`
+	// DateOrder is --date_order, which a checkpoint from before it existed
+	// lacks, and so reads as the default.
+	DateOrder ebird.DateOrder `json:"date_order"`
+	Exclude   categoriesFlag  `json:"exclude_categories,omitempty"`
+	Include   categoriesFlag  `json:"include_categories,omitempty"`
+	// SettingsSHA256 is settingsSHA256's hash of the files that change
+	// the fields, taxa, and re-keys decided.
+	SettingsSHA256 string `json:"settings_sha256,omitempty"`
+
+	// Done is the last CSV line whose action finished, and DoneSource the
+	// export it is in. A checkpoint from before merges lacks DoneSource.
+	Done       int    `json:"done"`
+	DoneSource string `json:"done_source,omitempty"`
+	// InFlight is the action under way, from just before its first write
+	// until its last.
+	InFlight *action `json:"in_flight,omitempty"`
+	// Rekeyed are the re-keys finished (P-089), which the snapshot, taken
+	// before them, doesn't know. A resumed run applies them to it, as the
+	// run that made them did to its index, so that the other half of a
+	// split can't re-key an observation again.
+	Rekeyed []keyChange `json:"rekeyed,omitempty"`
+}
+
+// A keyChange is a re-key, from one sync key to another.
+type keyChange struct {
+	From ebird.ObservationID `json:"from"`
+	To   ebird.ObservationID `json:"to"`
+}
+
+// A checkpointer keeps the checkpoint file up to date as a run progresses.
+// A nil *checkpointer keeps nothing, which is what a run without a journal
+// gets: a checkpoint belongs to a journaled run.
+type checkpointer struct {
+	filename string
+	cp       checkpoint
+}
+
+// startCheckpoint starts keeping a checkpoint for the run j records, from cp.
+func startCheckpoint(j *journal, cp checkpoint) *checkpointer {
+	if j == nil {
+		return nil
+	}
+	cp.Run = j.runID
+	return &checkpointer{filename: filepath.Join(configDir, checkpointFilename), cp: cp}
+}
+
+// snapshot saves ix, the index of the observations the run decides against,
+// for a rerun to resume with. A resumed run keeps the snapshot it resumed from.
+//
+// The old checkpoint goes first and the new one is written last, so that a
+// run killed in between leaves nothing to resume rather than a checkpoint
+// paired with another run's snapshot.
+func (c *checkpointer) snapshot(ix syncIndex) {
+	if c == nil {
+		return
+	}
+	if err := os.Remove(c.filename); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Writing checkpoint: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(configDir, checkpointIndexFilename), ix); err != nil {
+		log.Fatalf("Writing checkpoint: %v", err)
+	}
+	c.write()
+}
+
+// begin records that a is about to write to iNaturalist.
+func (c *checkpointer) begin(a action) {
+	if c == nil {
+		return
+	}
+	c.cp.InFlight = &a
+	c.write()
+}
+
+// finish records that a is done.
+func (c *checkpointer) finish(a action) {
+	if c == nil {
+		return
+	}
+	c.cp.InFlight = nil
+	c.cp.Done, c.cp.DoneSource = a.Line, a.Source
+	if a.FormerKey != nil {
+		c.cp.Rekeyed = append(c.cp.Rekeyed, keyChange{*a.FormerKey, a.Key})
+	}
+	c.write()
+}
+
+// remove deletes the checkpoint of a run that finished, which has nothing to
+// resume.
+func (c *checkpointer) remove() {
+	if c == nil {
+		return
+	}
+	for _, name := range []string{checkpointFilename, checkpointIndexFilename} {
+		if err := os.Remove(filepath.Join(configDir, name)); err != nil {
+			log.Printf("Removing checkpoint: %v", err)
+		}
+	}
+}
+
+// write saves the checkpoint. One that can't be saved is fatal, like a
+// journal that can't be written: a rerun trusting a checkpoint that is
+// behind would decide lines already synced against an index that doesn't
+// know about them, and create them again.
+func (c *checkpointer) write() {
+	c.cp.Updated = time.Now().UTC()
+	if err := writeFileAtomic(c.filename, c.cp); err != nil {
+		log.Fatalf("Writing checkpoint: %v", err)
+	}
+}
+
+// writeFileAtomic writes v to filename as JSON, replacing it whole or not at
+// all, so a run killed partway through the write leaves the old file intact.
+func writeFileAtomic(filename string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("writeFileAtomic(%s): %w", filename, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("writeFileAtomic(%s): %w", filename, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("writeFileAtomic(%s): %w", filename, err)
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		return fmt.Errorf("writeFileAtomic(%s): %w", filename, err)
+	}
+	return nil
+}
+
+// loadCheckpoint returns the checkpoint of an interrupted sync of the export
+// whose hash is csvSHA256, and the index snapshot it was taken against, if
+// this run can resume it. It logs why not when there is one it can't.
+func loadCheckpoint(inatUserID, csvSHA256 string) (checkpoint, syncIndex, bool) {
+	if !resume || configDir == "" {
+		return checkpoint{}, syncIndex{}, false
+	}
+	b, err := os.ReadFile(filepath.Join(configDir, checkpointFilename))
+	if os.IsNotExist(err) {
+		return checkpoint{}, syncIndex{}, false
+	}
+	var cp checkpoint
+	if err == nil {
+		err = json.Unmarshal(b, &cp)
+	}
+	if err != nil {
+		log.Printf("Not resuming: reading checkpoint: %v", err)
+		return checkpoint{}, syncIndex{}, false
+	}
+	why := ""
+	switch {
+	case csvSHA256 == "" || cp.CSVSHA256 != csvSHA256:
+		why = "it was a sync of a different eBird export"
+	case cp.UserID != inatUserID:
+		why = fmt.Sprintf("it was a sync to iNaturalist user %s", cp.UserID)
+	case cp.Verifiable != verifiable || cp.Fuzzy != fuzzy ||
+		!cp.After.Equal(after.Time()) || !cp.Before.Equal(before.Time()) || cp.DateOrder != dateOrder ||
+		!slices.Equal(cp.Exclude, excludeCategories) || !slices.Equal(cp.Include, includeCategories):
+		why = "it was run with different --verifiable, --fuzzy, --after, --before, --date_order, --exclude_categories, or --include_categories flags"
+	case cp.SettingsSHA256 != settingsSHA256():
+		why = "it was run with different --fields, --taxa_overrides, or --taxonomy_changes files"
+	case time.Since(cp.Updated) > checkpointMaxAge:
+		why = fmt.Sprintf("it stopped more than %v ago", checkpointMaxAge)
+	}
+	if why != "" {
+		log.Printf("Not resuming interrupted run %s: %s; starting over", cp.Run, why)
+		return checkpoint{}, syncIndex{}, false
+	}
+	b, err = os.ReadFile(filepath.Join(configDir, checkpointIndexFilename))
+	var ix syncIndex
+	if err == nil {
+		err = json.Unmarshal(b, &ix)
+	}
+	if err != nil {
+		log.Printf("Not resuming interrupted run %s: reading its index: %v", cp.Run, err)
+		return checkpoint{}, syncIndex{}, false
+	}
+	for _, k := range cp.Rekeyed {
+		ix.rekey(k.From, k.To)
+	}
+	// The action in flight is finished before any record after it is
+	// decided, so those see its re-key done.
+	if a := cp.InFlight; a != nil && a.FormerKey != nil {
+		ix.rekey(*a.FormerKey, a.Key)
+	}
+	return cp, ix, true
+}
+
+// settingsSHA256 identifies the --fields, --taxa_overrides, and
+// --taxonomy_changes files by their names and contents, since editing one
+// changes what a run decides as much as naming another does. It is "" when
+// none is given, as in a checkpoint from before it was recorded.
+func settingsSHA256() string {
+	files := []struct{ flag, filename string }{
+		{"fields", fieldsFile},
+		{"taxa_overrides", taxaOverridesFile},
+		{"taxonomy_changes", taxonomyFile},
+	}
+	h := sha256.New()
+	given := false
+	for _, f := range files {
+		sum := ""
+		if f.filename != "" {
+			given = true
+			var err error
+			if sum, err = fileSHA256(f.filename); err != nil {
+				// Unreadable now, though checkArgs read it: nothing to
+				// match a checkpoint against.
+				sum = "unreadable " + uuid.NewString()
+			}
+		}
+		fmt.Fprintf(h, "%s=%s %s\n", f.flag, f.filename, sum)
+	}
+	if !given {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// A position is a record's place in the exports a run reads: its line in the
+// export it came from.
+type position struct {
+	Source string
+	Line   int
+}
+
+func (p position) String() string {
+	if p.Source == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("line %d of %s", p.Line, p.Source)
+}
+
+// resumeFrom returns the point after which a run resuming cp picks up: the
+// action in flight if there is one, since the run finishes it first, and
+// otherwise the last one done.
+func (cp checkpoint) resumeFrom() position {
+	if cp.InFlight != nil {
+		return position{cp.InFlight.Source, cp.InFlight.Line}
+	}
+	return position{cp.DoneSource, cp.Done}
+}
+
+// recordsAfter returns the records after the one at p, or all of them if p is
+// the zero position. Line numbers alone don't order a merge of several
+// exports, so it skips records until it reaches p's. A p with no Source
+// matches on the line alone.
+func recordsAfter(records iter.Seq[ebird.Record], p position) iter.Seq[ebird.Record] {
+	return func(yield func(ebird.Record) bool) {
+		reached := p.Line == 0
+		for rec := range records {
+			if !reached {
+				reached = rec.Line == p.Line && (p.Source == "" || rec.Source == p.Source)
+				continue
+			}
+			if !yield(rec) {
+				return
+			}
+		}
+	}
+}
+
+// finishInFlight carries out what is left of a, the action a run died in the
+// middle of. Only iNaturalist knows how far it got: the observation may or may
+// not have been created, and each asset may or may not have been uploaded
+// whatever the journal says, since a write can succeed after the run that
+// made it has stopped listening.
+func (x *executor) finishInFlight(a action) {
+	obsURL := inat.ObservationURL(a.Observation.UUID)
+	results, err := x.inatClient.GetObservations([]uuid.UUID{a.Observation.UUID},
+		"description", "photos.all", "sounds.all", "ofvs.all", "annotations.all", "identifications.all")
+	if err != nil {
+		log.Fatalf("Checking %s, which the interrupted run was changing: %v", obsURL, err)
+	}
+	if len(results) == 0 {
+		if a.Kind == createAction {
+			log.Printf("line %d: %s was never created; creating it", a.Line, obsURL)
+			x.execute(a)
+		} else {
+			log.Printf("line %d: %s no longer exists; skipping it", a.Line, obsURL)
+		}
+		return
+	}
+	r := results[0]
+	listed, failed := iNatMLAssets(r)
+	attached := attachedMLAssets(r)
+	rest := action{
+		Kind:   updateAction,
+		Source: a.Source,
+		Line:   a.Line,
+		Key:    a.Key,
+		Reason: "finishing the interrupted run",
+		Observation: &inat.Observation{
+			UUID:        r.UUID,
+			Description: r.Description,
+		},
+		Annotations: missingAnnotations(a.Annotations, r.Annotations),
+	}
+	if a.FormerKey != nil && r.ObservationFieldValue(inat.EBirdScientificNameField) != a.Key.ScientificName {
+		rest.FormerKey, rest.KeyValueID = a.FormerKey, a.KeyValueID
+	}
+	// One whose taxon was left to the lookup is done if the owner has
+	// identified the observation at all.
+	if ident := a.Identification; ident != nil && !r.HasIdentification(*ident) && (ident.TaxonID != 0 || !r.OwnerIdentified()) {
+		rest.Identification = ident
+	}
+	for _, f := range a.Fields {
+		if r.ObservationFieldValue(f.ObservationFieldID) == "" {
+			rest.Fields = append(rest.Fields, f)
+		}
+	}
+	for _, id := range a.Media {
+		switch {
+		case listed.Has(id) || failed.Has(id):
+			// Finished.
+		case attached.Has(id):
+			rest.Attached = append(rest.Attached, id)
+		default:
+			rest.Media = append(rest.Media, id)
+		}
+	}
+	if rest.FormerKey == nil && rest.Identification == nil && len(rest.Media) == 0 && len(rest.Attached) == 0 && len(rest.Annotations) == 0 && len(rest.Fields) == 0 {
+		log.Printf("line %d: %s was finished before the run stopped", a.Line, obsURL)
+		return
+	}
+	log.Printf("line %d: finishing %s: %d media assets to upload, %d already attached to list, %d annotations to add, %d fields to set",
+		a.Line, obsURL, len(rest.Media), len(rest.Attached), len(rest.Annotations), len(rest.Fields))
+	x.execute(rest)
+}
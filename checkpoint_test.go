@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// resumeFixture returns three records with media, and a CSV file for them to
+// have come from: resuming needs a file to hash, though the mock reads the
+// records from memory.
+func resumeFixture(t *testing.T) ([]ebird.Record, string) {
+	t.Helper()
+	var records []ebird.Record
+	for i, id := range []string{"S700", "S701", "S702"} {
+		rec := crowRecord(id)
+		rec.Line = i + 2
+		rec.MLCatalogNumbers = fmt.Sprint(71111 + i*1111)
+		records = append(records, rec)
+	}
+	filename := filepath.Join(t.TempDir(), "MyEBirdData.csv")
+	if err := os.WriteFile(filename, []byte("Submission ID\nS700\nS701\nS702\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return records, filename
+}
+
+// interruptedSync runs a sync that mockInat kills partway, as Ctrl-C or a
+// log.Fatalf would, and leaves the account as the killed run left it.
+func interruptedSync(filenames []string, records []ebird.Record, mockInat *mockINatClient, crashOn string) {
+	mockInat.crashOn = crashOn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		birdsync(context.Background(), filenames, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	}()
+	<-done
+	mockInat.crashOn = ""
+	mockInat.persist()
+}
+
+func createdKeys(m *mockINatClient) []string {
+	var ids []string
+	for _, obs := range m.created {
+		for _, f := range obs.ObservationFieldValuesAttributes {
+			if f.ObservationFieldID == inat.EBirdField {
+				ids = append(ids, f.Value.(string))
+			}
+		}
+	}
+	return ids
+}
+
+// TestResumeFinishesInterruptedUpload checks that a rerun after a run killed
+// mid-upload skips the lines that finished, lists the upload that reached
+// iNaturalist without uploading it again, and doesn't download the account
+// again.
+//
+// Verifies: P-073.
+func TestResumeFinishesInterruptedUpload(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	records, filename := resumeFixture(t)
+	mockInat := &mockINatClient{}
+	interruptedSync([]string{filename}, records, mockInat, "upload:72222")
+
+	birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if mockInat.downloads != 1 {
+		t.Errorf("downloaded the account %d times, want once", mockInat.downloads)
+	}
+	if got, want := createdKeys(mockInat), []string{"S700", "S701", "S702"}; !slices.Equal(got, want) {
+		t.Errorf("created %v, want %v, each once", got, want)
+	}
+	var uploaded []string
+	for _, u := range mockInat.uploaded {
+		uploaded = append(uploaded, u.assetID)
+	}
+	if want := []string{"71111", "72222", "73333"}; !slices.Equal(uploaded, want) {
+		t.Errorf("uploaded %v, want %v, each once", uploaded, want)
+	}
+	mockInat.persist()
+	if listed, _ := iNatMLAssets(mockInat.observations[1]); !listed.Has("72222") {
+		t.Errorf("S701's description doesn't list the interrupted upload:\n%s", mockInat.observations[1].Description)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, checkpointFilename)); !os.IsNotExist(err) {
+		t.Errorf("a finished run left its checkpoint (stat error %v)", err)
+	}
+}
+
+// TestResumeCreatesWhatNeverArrived checks that a create the run died before
+// sending is made on the rerun, with the UUID it was planned with.
+//
+// Verifies: P-073.
+func TestResumeCreatesWhatNeverArrived(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	records, filename := resumeFixture(t)
+	mockInat := &mockINatClient{}
+	interruptedSync([]string{filename}, records, mockInat, "create:S701")
+
+	b, err := os.ReadFile(filepath.Join(configDir, checkpointFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		t.Fatal(err)
+	}
+	if cp.Done != 2 || cp.InFlight == nil || cp.InFlight.Key.SubmissionID != "S701" {
+		t.Fatalf("checkpoint = done %d, in flight %+v; want done 2, S701 in flight", cp.Done, cp.InFlight)
+	}
+
+	birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if got, want := createdKeys(mockInat), []string{"S700", "S701", "S702"}; !slices.Equal(got, want) {
+		t.Errorf("created %v, want %v, each once", got, want)
+	}
+	if got := mockInat.created[1].UUID; got != cp.InFlight.Observation.UUID {
+		t.Errorf("created S701 as %s, want the planned %s", got, cp.InFlight.Observation.UUID)
+	}
+}
+
+// TestResumeAfterRekey checks that a rerun resuming after a re-key decides the
+// records after it against the observation's new key, so the other half of a
+// split is created rather than taken for the observation the re-key moved;
+// and that one resuming a re-key the run died sending replaces the key's
+// value rather than adding another.
+//
+// Verifies: P-073, P-089.
+func TestResumeAfterRekey(t *testing.T) {
+	defer resetFlags()
+	for _, crashAtRekey := range []bool{false, true} {
+		resetFlags()
+		configDir = t.TempDir()
+		taxonomyChanges = []taxonomyChange{{"2024", "Aphelocoma californica", "Aphelocoma woodhouseii"}}
+		synced := inat.Result{
+			UUID:        uuid.New(),
+			ObservedOn:  "2023-01-03",
+			Description: mlAssetURL("11111"),
+			Sounds:      []inat.Sound{{OriginalFilename: "ML11111.mp3"}},
+			Ofvs: []inat.Ofv{
+				{FieldID: inat.EBirdField, Value: "S700"},
+				{FieldID: inat.EBirdScientificNameField, Value: "Aphelocoma californica"},
+			},
+		}
+		mockInat := &mockINatClient{observations: []inat.Result{synced}}
+		woodhouses := crowRecord("S700")
+		woodhouses.ScientificName = "Aphelocoma woodhouseii"
+		crow := crowRecord("S701")
+		crow.Line, crow.MLCatalogNumbers = 3, "72222"
+		californias := crowRecord("S700")
+		californias.Line, californias.ScientificName = 4, "Aphelocoma californica"
+		records := []ebird.Record{woodhouses, crow, californias}
+		filename := filepath.Join(t.TempDir(), "MyEBirdData.csv")
+		if err := os.WriteFile(filename, []byte("Submission ID\nS700\nS701\nS700\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		crashOn := "upload:72222"
+		if crashAtRekey {
+			crashOn = "update:" + synced.UUID.String()
+		}
+		interruptedSync([]string{filename}, records, mockInat, crashOn)
+
+		birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+		mockInat.persist()
+
+		if mockInat.downloads != 1 {
+			t.Errorf("crashed at %s: downloaded the account %d times, want once", crashOn, mockInat.downloads)
+		}
+		var created []string
+		for _, obs := range mockInat.created {
+			created = append(created, obs.SpeciesGuess)
+		}
+		if want := []string{"Corvus brachyrhynchos", "Aphelocoma californica"}; !slices.Equal(created, want) {
+			t.Errorf("crashed at %s: created %v, want %v", crashOn, created, want)
+		}
+		var keys []string
+		for _, ofv := range mockInat.observations[0].Ofvs {
+			if ofv.FieldID == inat.EBirdScientificNameField {
+				keys = append(keys, ofv.Value)
+			}
+		}
+		if want := []string{"Aphelocoma woodhouseii"}; !slices.Equal(keys, want) {
+			t.Errorf("crashed at %s: re-keyed observation has scientific names %q, want %q", crashOn, keys, want)
+		}
+	}
+}
+
+// TestResumeStartsOverWhenChanged checks that a checkpoint is only resumed by
+// a rerun that would decide the same way: the same export, the same flags and
+// the files they name, and --resume.
+//
+// Verifies: P-073.
+func TestResumeStartsOverWhenChanged(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(filename string)
+	}{
+		{"export changed", func(filename string) {
+			os.WriteFile(filename, []byte("Submission ID\nS700\n"), 0o644)
+		}},
+		{"flags changed", func(string) { fuzzy = true }},
+		{"--taxa_overrides given", func(filename string) {
+			taxaOverridesFile = filepath.Join(filepath.Dir(filename), "overrides.csv")
+			os.WriteFile(taxaOverridesFile, []byte("eBird,iNaturalist\nCorvus brachyrhynchos,8021\n"), 0o644)
+		}},
+		{"--resume=false", func(string) { resume = false }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetFlags()
+			configDir = t.TempDir()
+			records, filename := resumeFixture(t)
+			mockInat := &mockINatClient{}
+			interruptedSync([]string{filename}, records, mockInat, "create:S701")
+
+			tc.change(filename)
+			birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+			if mockInat.downloads != 2 {
+				t.Errorf("downloaded the account %d times, want twice", mockInat.downloads)
+			}
+			// Starting over decides against the account as it is, which
+			// has S700 in it, so S700 isn't created again.
+			if got, want := createdKeys(mockInat), []string{"S700", "S701", "S702"}; !slices.Equal(got, want) {
+				t.Errorf("created %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestDryRunLeavesCheckpoint checks that a dry run neither writes a
+// checkpoint nor consumes one.
+//
+// Verifies: P-073.
+func TestDryRunLeavesCheckpoint(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	records, filename := resumeFixture(t)
+	mockInat := &mockINatClient{}
+	interruptedSync([]string{filename}, records, mockInat, "create:S701")
+	saved, err := os.ReadFile(filepath.Join(configDir, checkpointFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dryRun = true
+	defer func() { dryRun = false }()
+	birdsync(context.Background(), []string{filename}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if len(mockInat.created) != 1 {
+		t.Errorf("dry run created %d observations", len(mockInat.created)-1)
+	}
+	got, err := os.ReadFile(filepath.Join(configDir, checkpointFilename))
+	if err != nil || string(got) != string(saved) {
+		t.Errorf("dry run changed the checkpoint (error %v)", err)
+	}
+}
+
+// TestResumeMergedExports checks that a rerun of an interrupted sync of
+// several exports picks up at the record it stopped at, though an export read
+// before it has the same line numbers.
+//
+// Verifies: P-073, P-080.
+func TestResumeMergedExports(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	var records []ebird.Record
+	var filenames []string
+	for i, id := range []string{"S710", "S711", "S712", "S713"} {
+		rec := crowRecord(id)
+		rec.Source = filepath.Join(configDir, fmt.Sprintf("export%d.csv", i/2))
+		rec.Line = i%2 + 2
+		rec.MLCatalogNumbers = fmt.Sprint(71000 + i)
+		records = append(records, rec)
+		if i%2 == 0 {
+			filenames = append(filenames, rec.Source)
+			if err := os.WriteFile(rec.Source, []byte("Submission ID\n"+id+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	mockInat := &mockINatClient{}
+	interruptedSync(filenames, records, mockInat, "create:S712")
+
+	birdsync(context.Background(), filenames, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	if mockInat.downloads != 1 {
+		t.Errorf("downloaded the account %d times, want once", mockInat.downloads)
+	}
+	if got, want := createdKeys(mockInat), []string{"S710", "S711", "S712", "S713"}; !slices.Equal(got, want) {
+		t.Errorf("created %v, want %v, each once", got, want)
+	}
+}
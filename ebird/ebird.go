@@ -2,15 +2,22 @@
 package ebird
 
 import (
+	"archive/zip"
+	"cmp"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"iter"
 	"log"
+	"maps"
 	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -46,6 +53,81 @@ type Record struct {
 	ObservationDetails string
 	ChecklistComments  string
 	MLCatalogNumbers   string
+
+	// DateOrder is the order of the month and day in Date, if it has
+	// slashes. Records sets it the same for every record in a file.
+	DateOrder DateOrder
+	// Source is the export the record was read from, which Merge can tell
+	// apart; Line is its line there.
+	Source string
+	// Extra holds the row's columns that Record has no field for, by header
+	// name, so that columns eBird adds to the export can be read before
+	// Record has a field for them. It is nil if there are none.
+	Extra map[string]string
+}
+
+// columns are the export's columns that Record has a field for.
+var columns = []string{
+	"Submission ID", "Common Name", "Scientific Name", "Taxonomic Order", "Count",
+	"State/Province", "County", "Location ID", "Location", "Latitude", "Longitude",
+	"Date", "Time", "Protocol", "Duration (Min)", "All Obs Reported",
+	"Distance Traveled (km)", "Area Covered (ha)", "Number of Observers",
+	"Breeding Code", "Observation Details", "Checklist Comments", "ML Catalog Numbers",
+}
+
+// Column returns the record's value for the export column with the given
+// header name: a field's for a column Record has one for, and otherwise the
+// value kept in Extra, which is "" for a column the export didn't have.
+func (r Record) Column(name string) string {
+	switch name {
+	case "Submission ID":
+		return r.SubmissionID
+	case "Common Name":
+		return r.CommonName
+	case "Scientific Name":
+		return r.ScientificName
+	case "Taxonomic Order":
+		return r.TaxonomicOrder
+	case "Count":
+		return r.Count
+	case "State/Province":
+		return r.StateProvince
+	case "County":
+		return r.County
+	case "Location ID":
+		return r.LocationID
+	case "Location":
+		return r.Location
+	case "Latitude":
+		return r.Latitude
+	case "Longitude":
+		return r.Longitude
+	case "Date":
+		return r.Date
+	case "Time":
+		return r.Time
+	case "Protocol":
+		return r.Protocol
+	case "Duration (Min)":
+		return r.DurationMin
+	case "All Obs Reported":
+		return r.AllObsReported
+	case "Distance Traveled (km)":
+		return r.DistanceTraveledKm
+	case "Area Covered (ha)":
+		return r.AreaCoveredHa
+	case "Number of Observers":
+		return r.NumberOfObservers
+	case "Breeding Code":
+		return r.BreedingCode
+	case "Observation Details":
+		return r.ObservationDetails
+	case "Checklist Comments":
+		return r.ChecklistComments
+	case "ML Catalog Numbers":
+		return r.MLCatalogNumbers
+	}
+	return r.Extra[name]
 }
 
 func (r Record) URL() string {
@@ -58,32 +140,177 @@ func (r Record) URLWithSpecies() string {
 
 // Observed returns the observation time for this record.
 // The record always includes the date but might not include the time.
-// The date and time formats vary between users for reasons I don't understand.
+// eBird writes the date as 2006-01-02, or with slashes in the order of the
+// user's locale, which Records detects for the whole file (DateOrder) unless
+// the year comes first, and the time as 03:04 PM or 15:04.
 func (r Record) Observed() (time.Time, error) {
+	layout := "2006-01-02"
+	if strings.Contains(r.Date, "/") {
+		switch {
+		case yearFirst(r.Date):
+			layout = "2006/1/2"
+		case r.DateOrder == DayFirst:
+			layout = "2/1/2006"
+		default:
+			layout = "1/2/2006"
+		}
+	}
+	d, err := time.Parse(layout, r.Date)
+	if err != nil {
+		return time.Time{}, err
+	}
 	if r.Time == "" {
-		if strings.Contains(r.Date, "/") {
-			return time.Parse("1/2/2006", r.Date)
-		} else {
-			return time.Parse("2006-01-02", r.Date)
+		return d, nil
+	}
+	for _, layout := range []string{"3:04 PM", "15:04"} {
+		if t, err := time.Parse(layout, r.Time); err == nil {
+			return d.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute), nil
 		}
 	}
-	if strings.Contains(r.Date, "/") {
-		return time.Parse("1/2/2006 3:04 PM", r.Date+" "+r.Time)
-	} else {
-		return time.Parse("2006-01-02 03:04 PM", r.Date+" "+r.Time)
+	return time.Time{}, fmt.Errorf("parsing time %q: want 3:04 PM or 15:04", r.Time)
+}
+
+// A DateOrder is the order of the month and day in a date with slashes, which
+// follows the locale of the eBird account that exported it: 5/3/2024 is May 3
+// in the US and 5 March in much of the rest of the world.
+type DateOrder int
+
+const (
+	// AutoDateOrder detects the order from the file: a Records option, and
+	// the zero value of Record.DateOrder, where it means MonthFirst.
+	AutoDateOrder DateOrder = iota
+	MonthFirst              // 1/2/2006
+	DayFirst                // 2/1/2006
+)
+
+func (o DateOrder) String() string {
+	switch o {
+	case MonthFirst:
+		return "month-first"
+	case DayFirst:
+		return "day-first"
+	}
+	return "auto"
+}
+
+func (o DateOrder) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+func (o *DateOrder) UnmarshalText(b []byte) error {
+	for _, v := range []DateOrder{AutoDateOrder, MonthFirst, DayFirst} {
+		if string(b) == v.String() {
+			*o = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown date order %q: want auto, month-first, or day-first", b)
+}
+
+// yearFirst reports whether date starts with a four-digit year, as
+// 2023/01/05 does. A locale that puts the year first puts the month next, so
+// such a date reads only one way.
+func yearFirst(date string) bool {
+	year, _, ok := strings.Cut(date, "/")
+	return ok && len(year) == 4
+}
+
+// slashedDate returns the first two numbers of a date with slashes, the month
+// and day in one order or the other, or false if date isn't one or starts
+// with the year.
+func slashedDate(date string) (a, b int, ok bool) {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 || yearFirst(date) {
+		return 0, 0, false
+	}
+	a, errA := strconv.Atoi(parts[0])
+	b, errB := strconv.Atoi(parts[1])
+	return a, b, errA == nil && errB == nil
+}
+
+// detectDateOrder reads the dates in the column col of r and returns the
+// order they are written in. A number over 12 can only be the day, so one
+// date settles it. If none does, every date reads both ways, and the order is
+// taken to be MonthFirst, eBird's own, as it always was before detection.
+func detectDateOrder(name string, r *csv.Reader, col int) (DateOrder, error) {
+	var monthFirst, dayFirst string // the first date that settles each way
+	var slashed int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Reported again, with the records before it, when the rows
+			// are read for real.
+			break
+		}
+		if col >= len(rec) {
+			continue
+		}
+		a, b, ok := slashedDate(rec[col])
+		if !ok {
+			continue
+		}
+		slashed++
+		if a > 12 && dayFirst == "" {
+			dayFirst = rec[col]
+		}
+		if b > 12 && monthFirst == "" {
+			monthFirst = rec[col]
+		}
 	}
+	switch {
+	case monthFirst != "" && dayFirst != "":
+		return AutoDateOrder, fmt.Errorf("Records(%s): dates are written both month first (%s) and day first (%s); pass the order to use",
+			name, monthFirst, dayFirst)
+	case dayFirst != "":
+		log.Printf("Reading dates day first, as in %s", dayFirst)
+		return DayFirst, nil
+	case monthFirst == "" && slashed > 0:
+		log.Printf("None of %d dates says whether the month or the day comes first; reading them month first", slashed)
+	}
+	return MonthFirst, nil
 }
 
 func (r Record) ObservationID() ObservationID {
 	return ObservationID{r.SubmissionID, r.ScientificName}
 }
 
+// Records reads the eBird export filename, as StreamRecords does with
+// AutoDateOrder, and returns its records. It reads the whole export before it
+// returns, so a row that can't be read is its error. This was Records before
+// StreamRecords, and is kept for programs that import the package.
 func Records(filename string) (iter.Seq[Record], error) {
+	records, err := StreamRecords(filename, AutoDateOrder)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for rec, err := range records {
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return slices.Values(recs), nil
+}
+
+// StreamRecords reads the eBird export filename: MyEBirdData.csv, or the zip
+// archive eBird's download arrives as. The header is checked before
+// StreamRecords returns; the rows are read one at a time as the iterator is
+// ranged over, with the file open only while it is. A row that can't be read
+// ends the iteration with an error, after the rows before it.
+//
+// order is the order of the month and day in dates written with slashes.
+// AutoDateOrder detects it by reading every date in the file before
+// StreamRecords returns.
+func StreamRecords(filename string, order DateOrder) (iter.Seq2[Record, error], error) {
 	// Check the path before opening it. eBird's download arrives as a zip that
-	// extracts to a folder, and users pass the folder or the zip by mistake
-	// (issue #1). Letting that reach the CSV reader produces the operating
-	// system's message — "is a directory" on Unix, "Incorrect function." on
-	// Windows — which tells nobody what to do (P-066).
+	// extracts to a folder, and users pass the folder by mistake (issue #1).
+	// Letting that reach the CSV reader produces the operating system's
+	// message — "is a directory" on Unix, "Incorrect function." on Windows —
+	// which tells nobody what to do (P-066).
 	info, err := os.Stat(filename)
 	if err != nil {
 		return nil, fmt.Errorf("Records(%s): %w", filename, err)
@@ -91,51 +318,102 @@ func Records(filename string) (iter.Seq[Record], error) {
 	if info.IsDir() {
 		return nil, fmt.Errorf("Records(%s): that is a folder, not a file; pass the MyEBirdData.csv inside it", filename)
 	}
+
+	name := filename
+	openRaw := func() (io.ReadCloser, error) { return os.Open(filename) }
 	if strings.EqualFold(filepath.Ext(filename), ".zip") {
-		return nil, fmt.Errorf("Records(%s): that is a zip archive. Extract it and pass the MyEBirdData.csv inside", filename)
+		entry, err := zipExport(filename)
+		if err != nil {
+			return nil, err
+		}
+		name = filename + ":" + entry
+		openRaw = func() (io.ReadCloser, error) { return openZipEntry(filename, entry) }
 	}
 
-	f, err := os.Open(filename)
+	f, err := openRaw()
 	if err != nil {
-		return nil, fmt.Errorf("Records(%s): %w", filename, err)
+		return nil, fmt.Errorf("Records(%s): %w", name, err)
 	}
-	defer f.Close()
-
-	r := csv.NewReader(f)
-	// eBird's CSV export returns a variable number of fields per record,
-	// so disable this check. This means we need to explicitly check len(rec)
-	// before accessing fields that might not be there.
-	r.FieldsPerRecord = -1
-	recs, err := r.ReadAll()
+	enc, err := detectEncoding(f)
+	f.Close()
 	if err != nil {
-		return nil, fmt.Errorf("Records(%s): reading CSV: %w", filename, err)
+		return nil, fmt.Errorf("Records(%s): %w", name, err)
 	}
-	if len(recs) < 1 {
-		return nil, fmt.Errorf("Records(%s): file is empty", filename)
+	if s := enc.String(); s != "" {
+		log.Printf("Reading %s: %s", name, s)
 	}
-	field := make(map[string]int)
-	for i, f := range recs[0] {
-		field[f] = i
+	open := func() (io.ReadCloser, error) {
+		f, err := openRaw()
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{enc.reader(f), f}, nil
 	}
-	// Without this the file parses happily and produces nonsense: a lookup of
-	// an absent column returns index 0, so every record would take its
-	// submission ID from whatever the first column holds (P-066).
-	if _, ok := field["Submission ID"]; !ok {
-		return nil, fmt.Errorf("Records(%s): no %q column; this does not look like an eBird MyEBirdData.csv export",
-			filename, "Submission ID")
+
+	// Read the header now, so that a file that isn't an eBird export is
+	// refused before anything is done with it.
+	f, err = open()
+	if err != nil {
+		return nil, fmt.Errorf("Records(%s): %w", name, err)
 	}
-	recs = recs[1:]
-	log.Printf("Read %d eBird observations", len(recs))
-	return func(yield func(Record) bool) {
-		for i, rec := range recs {
+	r, field, err := readHeader(name, f)
+	if err == nil && order == AutoDateOrder {
+		order = MonthFirst
+		if col, ok := field["Date"]; ok {
+			order, err = detectDateOrder(name, r, col)
+		}
+	}
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(Record, error) bool) {
+		f, err := open()
+		if err != nil {
+			yield(Record{}, fmt.Errorf("Records(%s): %w", name, err))
+			return
+		}
+		defer f.Close()
+		r, field, err := readHeader(name, f)
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+		extra := maps.Clone(field)
+		for _, c := range columns {
+			delete(extra, c)
+		}
+		n := 0
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				yield(Record{}, fmt.Errorf("Records(%s): reading CSV after %d records: %w", name, n, err))
+				return
+			}
+			n++
 			stringField := func(key string) string {
-				if f := field[key]; f < len(rec) {
+				// An absent column is empty, not column 0.
+				if f, ok := field[key]; ok && f < len(rec) {
 					return rec[f]
 				}
 				return ""
 			}
+			var extras map[string]string
+			if len(extra) > 0 {
+				extras = make(map[string]string, len(extra))
+				for key := range extra {
+					extras[key] = stringField(key)
+				}
+			}
 			if !yield(Record{
-				Line:               i + 2, // header was line 1
+				Line:               n + 1, // header was line 1
 				SubmissionID:       stringField("Submission ID"),
 				CommonName:         stringField("Common Name"),
 				ScientificName:     stringField("Scientific Name"),
@@ -159,13 +437,209 @@ func Records(filename string) (iter.Seq[Record], error) {
 				ObservationDetails: stringField("Observation Details"),
 				ChecklistComments:  stringField("Checklist Comments"),
 				MLCatalogNumbers:   stringField("ML Catalog Numbers"),
-			}) {
+				DateOrder:          order,
+				Source:             filename,
+				Extra:              extras,
+			}, nil) {
 				return
 			}
 		}
+		log.Printf("Read %d eBird observations", n)
 	}, nil
 }
 
+// Merge reads several eBird exports as one: several accounts' exports, or
+// snapshots of one account that still hold checklists since deleted from
+// eBird. A record in more than one export is read once, from the newest: the
+// one with the latest observation in it, which says when it was exported
+// however the file has been copied since. Of two as new, the most recently
+// modified file is the newer, and of two modified at the same time, the one
+// later in filenames. The newest export's records come first, then the
+// records of each older one that no newer one has. Every export is checked,
+// as StreamRecords checks one, and read through once for its latest observation,
+// before Merge returns.
+//
+// Merge keeps the ObservationID of every record it has read, to know one when
+// it comes again. With one export it is StreamRecords.
+func Merge(filenames []string, order DateOrder) (iter.Seq2[Record, error], error) {
+	if len(filenames) == 1 {
+		return StreamRecords(filenames[0], order)
+	}
+	type export struct {
+		filename string
+		latest   time.Time // observation
+		modified time.Time
+		records  iter.Seq2[Record, error]
+	}
+	var exports []export
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Merge: %w", err)
+		}
+		records, err := StreamRecords(filename, order)
+		if err != nil {
+			return nil, err
+		}
+		latest, err := latestObserved(records)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export{filename, latest, info.ModTime(), records})
+	}
+	slices.Reverse(exports) // so that, stably sorted, the later of a tie comes first
+	slices.SortStableFunc(exports, func(a, b export) int {
+		return cmp.Or(b.latest.Compare(a.latest), b.modified.Compare(a.modified))
+	})
+	var names []string
+	for _, e := range exports {
+		names = append(names, e.filename)
+	}
+	log.Printf("Merging %d eBird exports, newest first: %s", len(exports), strings.Join(names, ", "))
+
+	return func(yield func(Record, error) bool) {
+		seen := map[ObservationID]bool{}
+		duplicates := 0
+		for _, e := range exports {
+			for rec, err := range e.records {
+				if err != nil {
+					yield(Record{}, err)
+					return
+				}
+				if seen[rec.ObservationID()] {
+					duplicates++
+					continue
+				}
+				seen[rec.ObservationID()] = true
+				if !yield(rec, nil) {
+					return
+				}
+			}
+		}
+		log.Printf("Merged %d eBird exports into %d records, leaving out %d already read from a newer export",
+			len(exports), len(seen), duplicates)
+	}, nil
+}
+
+// latestObserved returns the time of the latest observation in records, or
+// the zero time if none has one that can be read.
+func latestObserved(records iter.Seq2[Record, error]) (time.Time, error) {
+	var latest time.Time
+	for rec, err := range records {
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t, err := rec.Observed(); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, nil
+}
+
+// exportName is the name of the CSV file in eBird's download.
+const exportName = "MyEBirdData.csv"
+
+// zipExport returns the name of the export in the eBird download zip
+// filename, as downloadMyData delivers it: the file named MyEBirdData.csv, or
+// failing that the only CSV in the archive.
+func zipExport(filename string) (string, error) {
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		return "", fmt.Errorf("Records(%s): reading zip archive: %w", filename, err)
+	}
+	defer z.Close()
+
+	var csvs []string
+	for _, f := range z.File {
+		// Skip the resource forks the macOS Finder adds when it re-zips a
+		// folder: __MACOSX/._MyEBirdData.csv is not a CSV.
+		if f.FileInfo().IsDir() || strings.HasPrefix(path.Base(f.Name), "._") {
+			continue
+		}
+		if path.Base(f.Name) == exportName {
+			return f.Name, nil
+		}
+		if strings.EqualFold(path.Ext(f.Name), ".csv") {
+			csvs = append(csvs, f.Name)
+		}
+	}
+	switch len(csvs) {
+	case 0:
+		return "", fmt.Errorf("Records(%s): the zip archive holds no CSV file; it should hold %s", filename, exportName)
+	case 1:
+		return csvs[0], nil
+	default:
+		return "", fmt.Errorf("Records(%s): the zip archive holds %d CSV files and none is named %s: %s; extract the one to sync and pass it instead",
+			filename, len(csvs), exportName, strings.Join(csvs, ", "))
+	}
+}
+
+// openZipEntry opens the file named entry in the zip archive filename.
+// Closing it closes the archive too.
+func openZipEntry(filename, entry string) (io.ReadCloser, error) {
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range z.File {
+		if f.Name == entry {
+			rc, err := f.Open()
+			if err != nil {
+				z.Close()
+				return nil, err
+			}
+			return zipEntry{rc, z}, nil
+		}
+	}
+	z.Close()
+	return nil, fmt.Errorf("%s is no longer in the archive", entry)
+}
+
+type zipEntry struct {
+	io.ReadCloser
+	z *zip.ReadCloser
+}
+
+func (e zipEntry) Close() error {
+	err := e.ReadCloser.Close()
+	if zerr := e.z.Close(); err == nil {
+		err = zerr
+	}
+	return err
+}
+
+// readHeader reads the header of the eBird export in f, which is named name
+// in errors, and returns the reader positioned at the first record and the
+// column of each field.
+func readHeader(name string, f io.Reader) (*csv.Reader, map[string]int, error) {
+	r := csv.NewReader(f)
+	// eBird's CSV export returns a variable number of fields per record,
+	// so disable this check. This means we need to explicitly check len(rec)
+	// before accessing fields that might not be there.
+	r.FieldsPerRecord = -1
+	// Each record's fields are copied into a Record before the next is read.
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("Records(%s): file is empty", name)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Records(%s): reading CSV: %w", name, err)
+	}
+	field := make(map[string]int)
+	for i, f := range header {
+		field[f] = i
+	}
+	// Without this the file parses happily and produces nonsense: a lookup of
+	// an absent column returns index 0, so every record would take its
+	// submission ID from whatever the first column holds (P-066).
+	if _, ok := field["Submission ID"]; !ok {
+		return nil, nil, fmt.Errorf("Records(%s): no %q column; this does not look like an eBird MyEBirdData.csv export",
+			name, "Submission ID")
+	}
+	return r, field, nil
+}
+
 // ObservationID identifies a unique eBird observation
 // as a submission ID and eBird's scientific name. EBird's
 // scientific names may differ from iNaturalist's taxa
@@ -173,7 +647,7 @@ func Records(filename string) (iter.Seq[Record], error) {
 type ObservationID struct {
 	// Submission ID is the eBird checklist ID, including leading "S".
 	// Example: "S193523301"
-	SubmissionID string
+	SubmissionID string `json:"submission_id"`
 
 	// ScientificName examples:
 	// - "Struthio camelus"
@@ -181,7 +655,7 @@ type ObservationID struct {
 	// - "Anas platyrhynchos x rubripes"
 	// - "Aythya marila/affinis"
 	// - "Melanitta sp."
-	ScientificName string
+	ScientificName string `json:"scientific_name"`
 }
 
 // Valid returns whether this observation ID has all fields set.
@@ -204,15 +678,28 @@ const macaulayBaseURL = "https://cdn.download.ams.birds.cornell.edu/api/v2"
 // we try downloading the photo file first, and if it's not there,
 // we try downloading the sound file.
 func DownloadMLAsset(mlAssetID string) (string, bool, error) {
-	return downloadMLAsset(macaulayBaseURL, mlAssetID)
+	return DownloadMLAssetContext(context.Background(), mlAssetID)
+}
+
+// DownloadMLAssetContext is DownloadMLAsset with a context, which bounds both
+// attempts and the download itself. A sound can run to tens of megabytes.
+func DownloadMLAssetContext(ctx context.Context, mlAssetID string) (string, bool, error) {
+	return downloadMLAsset(ctx, macaulayBaseURL, mlAssetID)
 }
 
 // downloadMLAsset is the implementation of DownloadMLAsset, accepting a base
 // URL so it can be tested against a local HTTP server.
-func downloadMLAsset(baseURL, mlAssetID string) (string, bool, error) {
+func downloadMLAsset(ctx context.Context, baseURL, mlAssetID string) (string, bool, error) {
+	get := func(url string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		return http.DefaultClient.Do(req)
+	}
 	// Try fetching this ML asset as a photo
 	url := fmt.Sprintf("%s/asset/%s/2400", baseURL, mlAssetID)
-	resp, err := http.Get(url)
+	resp, err := get(url)
 	if err != nil {
 		return "", false, fmt.Errorf("DownloadMLAsset(%s): %s: %w", mlAssetID, url, err)
 	}
@@ -221,7 +708,7 @@ func downloadMLAsset(baseURL, mlAssetID string) (string, bool, error) {
 	if resp.StatusCode == http.StatusNotFound {
 		// Photo not found; try fetching it as a sound
 		url = fmt.Sprintf("%s/asset/%s/mp3", baseURL, mlAssetID)
-		resp, err = http.Get(url)
+		resp, err = get(url)
 		if err != nil {
 			return "", isPhoto, fmt.Errorf("DownloadMLAsset(%s): %s: %w", mlAssetID, url, err)
 		}
@@ -1,13 +1,23 @@
 package ebird
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -36,7 +46,7 @@ func TestDownloadMLAsset_Sound(t *testing.T) {
 	}))
 	defer server.Close()
 
-	filename, isPhoto, err := downloadMLAsset(server.URL, assetID)
+	filename, isPhoto, err := downloadMLAsset(context.Background(), server.URL, assetID)
 	if err != nil {
 		t.Fatalf("downloadMLAsset() error = %v", err)
 	}
@@ -69,7 +79,7 @@ func TestDownloadMLAsset_Photo(t *testing.T) {
 	}))
 	defer server.Close()
 
-	filename, isPhoto, err := downloadMLAsset(server.URL, assetID)
+	filename, isPhoto, err := downloadMLAsset(context.Background(), server.URL, assetID)
 	if err != nil {
 		t.Fatalf("downloadMLAsset() error = %v", err)
 	}
@@ -83,7 +93,7 @@ func TestDownloadMLAsset_Photo(t *testing.T) {
 	}
 }
 
-// Verifies: T-019.
+// Verifies: T-019, P-078.
 func TestRecord_Observed(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -125,6 +135,55 @@ func TestRecord_Observed(t *testing.T) {
 			expected: time.Date(2023, 1, 2, 15, 4, 0, 0, time.UTC),
 			hasError: false,
 		},
+		{
+			name: "Day first",
+			record: Record{
+				Date:      "13/05/2024",
+				DateOrder: DayFirst,
+			},
+			expected: time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Day first, read both ways",
+			record: Record{
+				Date:      "03/05/2024",
+				DateOrder: DayFirst,
+			},
+			expected: time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Day first read month first",
+			record: Record{
+				Date:      "13/05/2024",
+				DateOrder: MonthFirst,
+			},
+			hasError: true,
+		},
+		{
+			name: "24-hour time",
+			record: Record{
+				Date: "2023-01-02",
+				Time: "15:04",
+			},
+			expected: time.Date(2023, 1, 2, 15, 4, 0, 0, time.UTC),
+		},
+		{
+			name: "24-hour time, one digit",
+			record: Record{
+				Date:      "2/1/2023",
+				Time:      "7:05",
+				DateOrder: DayFirst,
+			},
+			expected: time.Date(2023, 1, 2, 7, 5, 0, 0, time.UTC),
+		},
+		{
+			name: "Invalid time",
+			record: Record{
+				Date: "2023-01-02",
+				Time: "teatime",
+			},
+			hasError: true,
+		},
 		{
 			name: "Invalid date",
 			record: Record{
@@ -175,11 +234,7 @@ S123,American Robin,Turdus migratorius,1,1,CA,Santa Clara,L123,Some Park,37.123,
 	if err != nil {
 		t.Fatalf("Records() error: %v", err)
 	}
-
-	var recs []Record
-	for rec := range records {
-		recs = append(recs, rec)
-	}
+	recs := slices.Collect(records)
 
 	if len(recs) != 1 {
 		t.Fatalf("Expected 1 record, but got %d", len(recs))
@@ -221,6 +276,238 @@ S123,American Robin,Turdus migratorius,1,1,CA,Santa Clara,L123,Some Park,37.123,
 	}
 }
 
+// TestRecordsDetectsDateOrder checks that the order of the month and day is
+// settled once for the whole file, by any date that can only be read one way,
+// and that a forced order wins.
+//
+// Verifies: P-078.
+func TestRecordsDetectsDateOrder(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		dates []string
+		order DateOrder
+		want  time.Month // of the first date; 0 for an error
+	}{
+		{"day first", []string{"03/05/2024", "13/05/2024"}, AutoDateOrder, time.May},
+		{"month first", []string{"03/05/2024", "05/13/2024"}, AutoDateOrder, time.March},
+		{"ambiguous", []string{"03/05/2024", "04/05/2024"}, AutoDateOrder, time.March},
+		{"dashes", []string{"2024-03-05"}, AutoDateOrder, time.March},
+		{"year first", []string{"2023/01/05", "13/05/2024"}, AutoDateOrder, time.January},
+		{"year first settles nothing", []string{"03/05/2024", "2023/01/13"}, AutoDateOrder, time.March},
+		{"both ways", []string{"13/05/2024", "05/13/2024"}, AutoDateOrder, 0},
+		{"forced day first", []string{"03/05/2024", "04/05/2024"}, DayFirst, time.May},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "MyEBirdData.csv")
+			data := "Submission ID,Date\n"
+			for i, d := range tt.dates {
+				data += fmt.Sprintf("S%d,%s\n", i, d)
+			}
+			if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			records, err := StreamRecords(filename, tt.order)
+			if tt.want == 0 {
+				if err == nil || !strings.Contains(err.Error(), "both month first") {
+					t.Errorf("StreamRecords() error = %v, want one saying the dates disagree", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StreamRecords() error = %v", err)
+			}
+			for rec, err := range records {
+				if err != nil {
+					t.Fatal(err)
+				}
+				observed, err := rec.Observed()
+				if err != nil {
+					t.Fatalf("%s: %v", rec.Date, err)
+				}
+				if observed.Month() != tt.want {
+					t.Errorf("%s read as %s, want %s", rec.Date, observed.Format(time.DateOnly), tt.want)
+				}
+				break
+			}
+		})
+	}
+}
+
+// TestRecordsNormalizesEncoding checks that an export Excel has saved again
+// reads as the one eBird wrote: with a byte order mark, in Windows-1252, or
+// with carriage returns for line breaks, and together.
+//
+// Verifies: P-079.
+func TestRecordsNormalizesEncoding(t *testing.T) {
+	const utf8Export = "Submission ID,Common Name,Location\r\nS1,Pipit à gorge rousse,Réserve “Étang” – Nord\r\n"
+	latin := func(s string) string {
+		var b []byte
+		for _, c := range s {
+			switch c {
+			case '“':
+				b = append(b, 0x93)
+			case '”':
+				b = append(b, 0x94)
+			case '–':
+				b = append(b, 0x96)
+			default:
+				b = append(b, byte(c))
+			}
+		}
+		return string(b)
+	}
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		{"UTF-8", utf8Export},
+		{"byte order mark", "\xEF\xBB\xBF" + utf8Export},
+		{"Windows-1252", latin(utf8Export)},
+		{"carriage returns", strings.ReplaceAll(utf8Export, "\r\n", "\r")},
+		{"all three", "\xEF\xBB\xBF" + strings.ReplaceAll(latin(utf8Export), "\r\n", "\r")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "MyEBirdData.csv")
+			if err := os.WriteFile(filename, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			records, err := StreamRecords(filename, AutoDateOrder)
+			if err != nil {
+				t.Fatalf("StreamRecords() error = %v", err)
+			}
+			var recs []Record
+			for rec, err := range records {
+				if err != nil {
+					t.Fatal(err)
+				}
+				recs = append(recs, rec)
+			}
+			want := Record{Line: 2, SubmissionID: "S1", CommonName: "Pipit à gorge rousse", Location: "Réserve “Étang” – Nord", DateOrder: MonthFirst, Source: filename}
+			if len(recs) != 1 || !reflect.DeepEqual(recs[0], want) {
+				t.Errorf("StreamRecords() = %+v, want [%+v]", recs, want)
+			}
+		})
+	}
+
+	filename := filepath.Join(t.TempDir(), "MyEBirdData.csv")
+	if err := os.WriteFile(filename, []byte("\xFF\xFES\x00u\x00b\x00"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StreamRecords(filename, AutoDateOrder); err == nil || !strings.Contains(err.Error(), "UTF-16") {
+		t.Errorf("StreamRecords() of UTF-16 text error = %v, want one naming it", err)
+	}
+}
+
+// TestRecordsStreams checks that the rows of an export are read as they are
+// wanted: partway through a large file, what is held is a row, not the file.
+//
+// Verifies: T-022.
+func TestRecordsStreams(t *testing.T) {
+	const n = 100000
+	filename := filepath.Join(t.TempDir(), "MyEBirdData.csv")
+	var b strings.Builder
+	b.WriteString("Submission ID,Common Name,Scientific Name,Count,Location,Latitude,Longitude,Date,Time,Checklist Comments,ML Catalog Numbers\n")
+	for i := range n {
+		fmt.Fprintf(&b, "S%d,American Crow,Corvus brachyrhynchos,2,Some Park,37.123,-122.123,2024-05-01,07:00 AM,A fine morning by the lake,%d\n", i, i)
+	}
+	if err := os.WriteFile(filename, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b = strings.Builder{}
+
+	records, err := StreamRecords(filename, AutoDateOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before, during runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	seen := 0
+	for _, err := range records {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen++; seen == n/2 {
+			runtime.GC()
+			runtime.ReadMemStats(&during)
+		}
+	}
+	if seen != n {
+		t.Fatalf("read %d records, want %d", seen, n)
+	}
+	if held, limit := int64(during.HeapAlloc)-int64(before.HeapAlloc), int64(4<<20); held > limit {
+		t.Errorf("halfway through %d records, %d MB is held, want under %d MB", n, held>>20, limit>>20)
+	}
+}
+
+// TestRecordsYieldsReadErrors checks that a row that can't be parsed ends the
+// records with an error, after the rows before it, rather than failing the
+// whole file up front or ending quietly.
+//
+// Verifies: T-022, P-066.
+func TestRecordsYieldsReadErrors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "MyEBirdData.csv")
+	data := "Submission ID,Common Name\nS1,American Crow\nS2,\"Bad \"quote\"\nS3,Blue Jay\n"
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Records(filename); err == nil {
+		t.Error("Records() error = nil, want the bad row")
+	}
+	records, err := StreamRecords(filename, AutoDateOrder)
+	if err != nil {
+		t.Fatalf("StreamRecords() error = %v, want the bad row reported by the iterator", err)
+	}
+	var ids []string
+	var readErr error
+	for rec, err := range records {
+		if err != nil {
+			readErr = err
+			continue
+		}
+		ids = append(ids, rec.SubmissionID)
+	}
+	if len(ids) != 1 || ids[0] != "S1" {
+		t.Errorf("read %v before the error, want [S1]", ids)
+	}
+	var parseErr *csv.ParseError
+	if !errors.As(readErr, &parseErr) || parseErr.Line != 3 {
+		t.Errorf("iterator error = %v, want a parse error on line 3", readErr)
+	}
+}
+
+// TestParseTaxon checks each category of eBird name against the examples in
+// ObservationID's comment and eBird's taxonomy.
+//
+// Verifies: P-082.
+func TestParseTaxon(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want Taxon
+	}{
+		{"Struthio camelus", Taxon{Species, "Struthio", "camelus", ""}},
+		{"Junco hyemalis oreganus", Taxon{Subspecies, "Junco", "hyemalis", "oreganus"}},
+		{"Junco hyemalis [oreganus Group]", Taxon{Subspecies, "Junco", "hyemalis", "oreganus Group"}},
+		{"Melanitta sp.", Taxon{Spuh, "Melanitta", "", ""}},
+		{"Larus/Chroicocephalus sp.", Taxon{Spuh, "Larus/Chroicocephalus", "", ""}},
+		{"Aythya marila/affinis", Taxon{Slash, "Aythya", "marila/affinis", ""}},
+		{"Anas platyrhynchos x rubripes", Taxon{Hybrid, "Anas", "platyrhynchos", ""}},
+		{"Colaptes auratus auratus x cafer", Taxon{Hybrid, "Colaptes", "auratus", "auratus"}},
+		{"Cairina moschata (Domestic type)", Taxon{Domestic, "Cairina", "moschata", ""}},
+		{"Oceanodroma castro (Grant's)", Taxon{Form, "Oceanodroma", "castro", ""}},
+	} {
+		if got := ParseTaxon(tt.name); got != tt.want {
+			t.Errorf("ParseTaxon(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	for _, c := range Categories {
+		var got Category
+		if b, _ := c.MarshalText(); got.UnmarshalText(b) != nil || got != c {
+			t.Errorf("Category %v didn't survive MarshalText and UnmarshalText: got %v", c, got)
+		}
+	}
+}
+
 // Verifies: P-019, P-022.
 func TestObservationID_Valid(t *testing.T) {
 	testCases := []struct {
@@ -317,7 +604,7 @@ func TestFileExtension(t *testing.T) {
 	}
 }
 
-// TestRecordsRejectsBadInput covers the three ways the input path goes wrong,
+// TestRecordsRejectsBadInput covers the ways the input path goes wrong,
 // each of which used to produce either an operating-system message that
 // explains nothing or, worse, no error at all.
 //
@@ -352,13 +639,13 @@ func TestRecordsRejectsBadInput(t *testing.T) {
 		// and fails on Windows, whose error says "Incorrect function." — which
 		// is the bug being fixed.
 		{"directory", dir, "MyEBirdData.csv"},
-		{"zip archive", zipPath, "Extract"},
+		{"corrupt zip archive", zipPath, "zip archive"},
 		{"not an eBird export", foreign, "Submission ID"},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := Records(tt.path)
+			_, err := StreamRecords(tt.path, AutoDateOrder)
 			if err == nil {
-				t.Fatalf("Records(%s) returned no error", tt.path)
+				t.Fatalf("StreamRecords(%s) returned no error", tt.path)
 			}
 			if !strings.Contains(err.Error(), tt.want) {
 				t.Errorf("Error %q doesn't mention %q, so it doesn't tell the user what to fix (P-066)",
@@ -368,6 +655,226 @@ func TestRecordsRejectsBadInput(t *testing.T) {
 	}
 }
 
+// writeZip writes a zip archive of files, name to contents, and returns its
+// path.
+func writeZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "ebird_1757602033437.zip")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// TestRecordsReadsZip checks that the zip eBird's download arrives as is read
+// in place: MyEBirdData.csv if it's there, else the one CSV, and otherwise an
+// error that says what the archive holds.
+//
+// Verifies: P-066, P-077.
+func TestRecordsReadsZip(t *testing.T) {
+	const export = "Submission ID,Common Name\nS123,American Robin\n"
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		want  string // the submission ID read, or the error expected
+	}{
+		{"the export", map[string]string{"MyEBirdData.csv": export}, "S123"},
+		{"the export among others", map[string]string{
+			"README.txt":                     "Thank you for using eBird",
+			"checklists.csv":                 "Submission ID\nS999\n",
+			"MyEBirdData.csv":                export,
+			"__MACOSX/._MyEBirdData.csv":     "not a CSV",
+			"__MACOSX/._checklists.csv":      "not a CSV",
+			"ebird_1757602033437/notes.html": "",
+		}, "S123"},
+		{"the only CSV", map[string]string{"ebird/export.CSV": export, "README.txt": ""}, "S123"},
+		{"no CSV", map[string]string{"README.txt": ""}, "holds no CSV file"},
+		{"several CSVs", map[string]string{"a.csv": export, "b.csv": export}, "a.csv, b.csv"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := StreamRecords(writeZip(t, tt.files), AutoDateOrder)
+			if !strings.HasPrefix(tt.want, "S") {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("StreamRecords() error = %v, want one mentioning %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StreamRecords() error = %v", err)
+			}
+			var ids []string
+			for rec, err := range records {
+				if err != nil {
+					t.Fatalf("reading records: %v", err)
+				}
+				ids = append(ids, rec.SubmissionID)
+			}
+			if len(ids) != 1 || ids[0] != tt.want {
+				t.Errorf("StreamRecords() read %v, want [%s]", ids, tt.want)
+			}
+		})
+	}
+}
+
+// TestRecordsKeepsExtraColumns checks that columns Record has no field for
+// are kept by name, and that a row with none keeps nothing.
+//
+// Verifies: P-081.
+func TestRecordsKeepsExtraColumns(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name, data string
+		want       map[string]string
+	}{
+		{"known columns only", strings.Join(columns, ",") + "\nS1\n", nil},
+		{"new columns", "Submission ID,Age/Sex,Count,Effort Hours\nS1,Adult Male (1),1,2.5\n",
+			map[string]string{"Age/Sex": "Adult Male (1)", "Effort Hours": "2.5"}},
+		{"short row", "Submission ID,Count,Age/Sex\nS1,1\n", map[string]string{"Age/Sex": ""}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.name+".csv")
+			if err := os.WriteFile(filename, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			records, err := StreamRecords(filename, AutoDateOrder)
+			if err != nil {
+				t.Fatalf("StreamRecords() error = %v", err)
+			}
+			for rec, err := range records {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(rec.Extra, tt.want) {
+					t.Errorf("Extra = %q, want %q", rec.Extra, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestRecordColumn checks that Column reads each column of the export by its
+// header name, whether Record has a field for it or keeps it in Extra.
+//
+// Verifies: P-085.
+func TestRecordColumn(t *testing.T) {
+	header := append(slices.Clone(columns), "Effort Hours")
+	var row []string
+	for _, name := range header {
+		// Quoted, since some header names have commas in them.
+		row = append(row, strconv.Quote(name))
+	}
+	filename := filepath.Join(t.TempDir(), "export.csv")
+	data := strings.Join(row, ",") + "\n" + strings.Join(row, ",") + "\n"
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	records, err := StreamRecords(filename, AutoDateOrder)
+	if err != nil {
+		t.Fatalf("StreamRecords() error = %v", err)
+	}
+	for rec, err := range records {
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range header {
+			if got := rec.Column(name); got != name {
+				t.Errorf("Column(%q) = %q, want %q", name, got, name)
+			}
+		}
+		if got := rec.Column("Age/Sex"); got != "" {
+			t.Errorf("Column(%q) = %q, want \"\"", "Age/Sex", got)
+		}
+	}
+}
+
+// TestMerge checks that records in more than one export are read once, from
+// the newest, and that each record says which export it came from.
+//
+// Verifies: P-080.
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, rows string, modified time.Time) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte("Submission ID,Common Name,Count\n"+rows), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	now := time.Now()
+	old := write("old.csv", "S1,American Robin,1\nS2,Steller's Jay,1\n", now.Add(-time.Hour))
+	newer := write("new.csv", "S1,American Robin,3\nS3,Wrentit,1\n", now)
+	tie := write("tie.csv", "S3,Wrentit,2\n", now)
+
+	records, err := Merge([]string{newer, old, tie}, AutoDateOrder)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	var got []string
+	for rec, err := range records {
+		if err != nil {
+			t.Fatalf("reading records: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %s:%d", rec.SubmissionID, rec.Count, filepath.Base(rec.Source), rec.Line))
+	}
+	// tie.csv is as new as new.csv and later on the command line, so it
+	// comes first; S1 is new.csv's, not old.csv's.
+	want := []string{"S3 2 tie.csv:2", "S1 3 new.csv:2", "S2 1 old.csv:3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Merge() read %q, want %q", got, want)
+	}
+
+	if _, err := Merge([]string{newer, filepath.Join(dir, "missing.csv")}, AutoDateOrder); err == nil {
+		t.Error("Merge() with a missing export succeeded, want an error")
+	}
+
+	// A snapshot copied since it was exported is newer by its file, but older
+	// by what it holds, and that wins.
+	dated := func(name, rows string, modified time.Time) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte("Submission ID,Count,Date\n"+rows), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	copied := dated("copied.csv", "S1,1,2023-05-01\n", now)
+	latest := dated("latest.csv", "S1,4,2023-05-01\nS4,1,2024-06-01\n", now.Add(-time.Hour))
+	records, err = Merge([]string{latest, copied}, AutoDateOrder)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	for rec, err := range records {
+		if err != nil {
+			t.Fatalf("reading records: %v", err)
+		}
+		if rec.SubmissionID == "S1" && filepath.Base(rec.Source) != "latest.csv" {
+			t.Errorf("S1 read from %s, want latest.csv, which has the latest observation", filepath.Base(rec.Source))
+		}
+	}
+}
+
 // TestDownloadMLAssetCleansUpOnError checks that a failed download leaves
 // nothing behind. Every early return used to abandon the temp file, and on
 // Windows its open handle too — which is what issue #1 reported as "The process
@@ -396,7 +903,7 @@ func TestDownloadMLAssetCleansUpOnError(t *testing.T) {
 	defer server.Close()
 	server.Config.ErrorLog = log.New(io.Discard, "", 0) // the abort is expected
 
-	if _, _, err := downloadMLAsset(server.URL, "12345"); err == nil {
+	if _, _, err := downloadMLAsset(context.Background(), server.URL, "12345"); err == nil {
 		t.Fatal("downloadMLAsset() with a truncated body returned no error")
 	}
 
@@ -408,3 +915,35 @@ func TestDownloadMLAssetCleansUpOnError(t *testing.T) {
 		t.Errorf("Left %s behind in the temp directory after a failed download (T-023)", e.Name())
 	}
 }
+
+// TestDownloadMLAssetCanceled checks that canceling the context stops a
+// download partway through the body, and that the partial file goes too.
+//
+// Verifies: T-039, T-023.
+func TestDownloadMLAssetCanceled(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("the first of many megabytes"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // the rest never comes
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := downloadMLAsset(ctx, server.URL, "12345")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("downloadMLAsset() error = %v, want the context's deadline", err)
+	}
+	left, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range left {
+		t.Errorf("Left %s behind in the temp directory after a canceled download (T-023)", e.Name())
+	}
+}
@@ -0,0 +1,147 @@
+package ebird
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// An encoding is how an export's bytes differ from the UTF-8 CSV eBird
+// writes. Opening the export in Excel and saving it again is the usual way
+// a file comes to differ: Excel adds a byte order mark to "CSV UTF-8", writes
+// plain "CSV" in the Windows code page, and on older Macs ends lines with a
+// bare carriage return, which encoding/csv doesn't take for a line break.
+type encoding struct {
+	bom         bool // starts with a UTF-8 byte order mark
+	windows1252 bool // isn't UTF-8, so is taken to be Windows-1252
+	crOnly      bool // lines end in a carriage return alone
+}
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// detectEncoding reads all of f to find how it is encoded. Any byte sequence
+// that isn't UTF-8 makes the whole file Windows-1252, which, unlike a guess
+// made row by row, can't read one name in the file one way and the next the
+// other.
+func detectEncoding(f io.Reader) (encoding, error) {
+	var e encoding
+	r := bufio.NewReader(f)
+	start, _ := r.Peek(len(utf8BOM))
+	switch {
+	case bytes.HasPrefix(start, utf8BOM):
+		e.bom = true
+		r.Discard(len(utf8BOM))
+	case bytes.HasPrefix(start, utf16LEBOM), bytes.HasPrefix(start, utf16BEBOM):
+		return e, errors.New(`the file is UTF-16 text, not CSV; download the export again, or save it from Excel as "CSV UTF-8"`)
+	}
+	var sawCR, sawLF bool
+	for {
+		c, size, err := r.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return e, err
+		}
+		switch {
+		case c == utf8.RuneError && size == 1:
+			e.windows1252 = true
+		case c == '\r':
+			sawCR = true
+		case c == '\n':
+			sawLF = true
+		}
+	}
+	e.crOnly = sawCR && !sawLF
+	return e, nil
+}
+
+// String describes what reading the file in e takes, for the log, or returns
+// "" if it is the UTF-8 CSV eBird wrote.
+func (e encoding) String() string {
+	var applied []string
+	if e.bom {
+		applied = append(applied, "skipped a UTF-8 byte order mark")
+	}
+	if e.windows1252 {
+		applied = append(applied, "converted Windows-1252 text to UTF-8")
+	}
+	if e.crOnly {
+		applied = append(applied, "read carriage returns as line breaks")
+	}
+	return strings.Join(applied, "; ")
+}
+
+// reader returns f read as UTF-8 with lines ending in newlines.
+func (e encoding) reader(f io.Reader) io.Reader {
+	if e.bom {
+		f = skip(f, len(utf8BOM))
+	}
+	if !e.windows1252 && !e.crOnly {
+		return f
+	}
+	return &normalizer{r: f, e: e, raw: make([]byte, 32<<10)}
+}
+
+// skip returns f less its first n bytes.
+func skip(f io.Reader, n int) io.Reader {
+	r := bufio.NewReader(f)
+	r.Discard(n)
+	return r
+}
+
+// A normalizer converts what it reads according to e.
+type normalizer struct {
+	r       io.Reader
+	e       encoding
+	raw     []byte
+	buf     []byte
+	pending []byte // the part of buf converted and not yet read
+	err     error  // from r, returned once pending is read
+}
+
+func (n *normalizer) Read(p []byte) (int, error) {
+	for len(n.pending) == 0 && n.err == nil {
+		k, err := n.r.Read(n.raw)
+		n.buf = n.buf[:0]
+		for _, c := range n.raw[:k] {
+			switch {
+			case c == '\r' && n.e.crOnly:
+				n.buf = append(n.buf, '\n')
+			case c >= 0x80 && n.e.windows1252:
+				n.buf = utf8.AppendRune(n.buf, windows1252(c))
+			default:
+				n.buf = append(n.buf, c)
+			}
+		}
+		n.pending = n.buf
+		n.err = err
+	}
+	if len(n.pending) == 0 {
+		return 0, n.err
+	}
+	k := copy(p, n.pending)
+	n.pending = n.pending[k:]
+	return k, nil
+}
+
+// windows1252 returns the character c stands for in Windows-1252. Above
+// 0x9F it agrees with Latin-1 and Unicode; below, it has the curly quotes,
+// dashes and a few letters Excel writes. The five bytes it leaves undefined
+// are read as Latin-1's control characters, as browsers do.
+func windows1252(c byte) rune {
+	if c < 0x80 || c > 0x9F {
+		return rune(c)
+	}
+	return [...]rune{
+		'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+		0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+	}[c-0x80]
+}
@@ -0,0 +1,111 @@
+package ebird
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Category is the kind of taxon an eBird scientific name stands for. Only
+// species and subspecies are taxa iNaturalist has an exact match for; the
+// rest are how eBird records a bird that wasn't, or couldn't be, identified
+// to species.
+type Category int
+
+const (
+	Species    Category = iota // Struthio camelus
+	Subspecies                 // Junco hyemalis oreganus, Junco hyemalis [oreganus Group]
+	Spuh                       // Melanitta sp.
+	Slash                      // Aythya marila/affinis
+	Hybrid                     // Anas platyrhynchos x rubripes, and intergrades
+	Domestic                   // Cairina moschata (Domestic type)
+	Form                       // Oceanodroma castro (Grant's)
+)
+
+// Categories lists every Category, in order.
+var Categories = []Category{Species, Subspecies, Spuh, Slash, Hybrid, Domestic, Form}
+
+func (c Category) String() string {
+	switch c {
+	case Species:
+		return "species"
+	case Subspecies:
+		return "subspecies"
+	case Spuh:
+		return "spuh"
+	case Slash:
+		return "slash"
+	case Hybrid:
+		return "hybrid"
+	case Domestic:
+		return "domestic"
+	case Form:
+		return "form"
+	}
+	return fmt.Sprintf("Category(%d)", int(c))
+}
+
+func (c Category) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Category) UnmarshalText(b []byte) error {
+	for _, v := range Categories {
+		if string(b) == v.String() {
+			*c = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown taxon category %q: want species, subspecies, spuh, slash, hybrid, domestic, or form", b)
+}
+
+// A Taxon is an eBird scientific name taken apart. Genus, Species, and
+// Subspecies are the parts of the name before any qualifier: the first parent
+// of a hybrid, the base species of a domestic type or form. Species holds
+// both halves of a slash ("marila/affinis"), and Genus both genera of a
+// slash between genera ("Larus/Chroicocephalus").
+type Taxon struct {
+	Category   Category
+	Genus      string
+	Species    string
+	Subspecies string
+}
+
+// ParseTaxon takes apart an eBird scientific name.
+func ParseTaxon(scientificName string) Taxon {
+	name := strings.TrimSpace(scientificName)
+	var t Taxon
+	switch {
+	case strings.HasSuffix(name, " (Domestic type)"):
+		t.Category = Domestic
+		name = strings.TrimSuffix(name, " (Domestic type)")
+	case strings.HasSuffix(name, ")") && strings.Contains(name, " ("):
+		t.Category = Form
+		name = name[:strings.Index(name, " (")]
+	case name == "sp." || strings.HasSuffix(name, " sp."):
+		t.Category = Spuh
+		name = strings.TrimSuffix(strings.TrimSuffix(name, "sp."), " ")
+	case strings.Contains(name, " x "):
+		t.Category = Hybrid
+		name = name[:strings.Index(name, " x ")]
+	case strings.Contains(name, "/"):
+		t.Category = Slash
+	}
+	parts := strings.SplitN(name, " ", 3)
+	t.Genus = parts[0]
+	if len(parts) > 1 {
+		t.Species = parts[1]
+	}
+	if len(parts) > 2 {
+		// eBird writes a group of subspecies in brackets.
+		t.Subspecies = strings.Trim(parts[2], "[]")
+		if t.Category == Species {
+			t.Category = Subspecies
+		}
+	}
+	return t
+}
+
+// Taxon takes apart the record's scientific name.
+func (r Record) Taxon() Taxon {
+	return ParseTaxon(r.ScientificName)
+}
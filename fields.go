@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// A fieldMapping copies one value of an eBird record into an iNaturalist
+// observation field.
+type fieldMapping struct {
+	field  int
+	source fieldSource
+	// backfill is set for a field birdsync didn't always set, which it fills
+	// in on the observations created without it. The others were set when
+	// every birdsync observation was created, so one without a value had it
+	// removed on iNaturalist, and is left that way.
+	backfill bool
+}
+
+// A fieldSource is where a field's values come from: an export column, or a
+// value computed from the record.
+type fieldSource struct {
+	name string // the column's header name, or the computed value's name
+	// datatype is the iNaturalist datatype every value fits: text, numeric,
+	// or date. Any value fits a text field.
+	datatype string
+	value    func(ebird.Record) string
+}
+
+// numericColumns are the export's columns that hold numbers.
+var numericColumns = []string{
+	"Count", "Taxonomic Order", "Latitude", "Longitude", "Duration (Min)", "All Obs Reported",
+	"Distance Traveled (km)", "Area Covered (ha)", "Number of Observers",
+}
+
+// column returns the source that reads the export column with the given
+// header name. A numeric column's value that isn't a number, such as the X
+// eBird writes in Count for a species present in uncounted numbers, reads as
+// empty, so that it sets no field rather than one that refuses it.
+func column(name string) fieldSource {
+	if !slices.Contains(numericColumns, name) {
+		return fieldSource{name, "text", func(r ebird.Record) string { return r.Column(name) }}
+	}
+	return fieldSource{name, "numeric", func(r ebird.Record) string {
+		v := r.Column(name)
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return ""
+		}
+		return v
+	}}
+}
+
+// computedSources are the values a --fields file can name in place of a
+// column.
+var computedSources = map[string]fieldSource{
+	"checklist_url": {"checklist_url", "text", ebird.Record.URL},
+	"observed_on": {"observed_on", "date", func(r ebird.Record) string {
+		observed, err := r.Observed()
+		if err != nil {
+			return ""
+		}
+		return observed.Format(time.DateOnly)
+	}},
+	"taxon_category": {"taxon_category", "text", func(r ebird.Record) string {
+		return r.Taxon().Category.String()
+	}},
+	"breeding_code": {"breeding_code", "text", breedingCode},
+}
+
+// syncKeyFields are the fields every mapping sets, from the columns that make
+// the sync key (P-019). EBirdField and EBirdScientificNameField are used to
+// match iNaturalist observations to the corresponding eBird checklist and
+// species entry. We cannot rely on the taxon in the iNaturalist observation
+// because it may be changed after upload.
+var syncKeyFields = []fieldMapping{
+	{inat.EBirdField, column("Submission ID"), false},
+	{inat.EBirdScientificNameField, column("Scientific Name"), false},
+}
+
+// defaultObservationFields is the mapping used without --fields.
+var defaultObservationFields = append([]fieldMapping{
+	{inat.CountField, column("Count"), false},
+	{inat.CommonNameField, column("Common Name"), false},
+	{inat.LocationField, column("Location"), false},
+	{inat.CountyField, column("County"), false},
+	{inat.StateOrProvinceField, column("State/Province"), false},
+	{inat.NumObserversField, column("Number of Observers"), false},
+	{inat.DistanceField, column("Distance Traveled (km)"), true},
+}, syncKeyFields...)
+
+// observationFields lists the observation fields birdsync sets on the
+// observations it creates (P-039), and, for those marked backfill, fills in
+// on those it created before the field was added here (P-084). --fields
+// replaces it (P-085).
+var observationFields = defaultObservationFields
+
+// A fieldConfig is one entry of a --fields file.
+type fieldConfig struct {
+	Field    int    `json:"field"`
+	Column   string `json:"column,omitempty"`
+	Computed string `json:"computed,omitempty"`
+	Backfill bool   `json:"backfill,omitempty"`
+}
+
+// readFieldMappings reads a --fields file: a JSON list of fieldConfigs. The
+// sync key's fields are added if it leaves them out, and it may not map them
+// from anything else.
+func readFieldMappings(filename string) ([]fieldMapping, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("readFieldMappings: %w", err)
+	}
+	var configs []fieldConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("readFieldMappings(%s): %w", filename, err)
+	}
+	var mappings []fieldMapping
+	var errs []error
+	seen := map[int]bool{}
+	for i, c := range configs {
+		bad := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("%s: entry %d (field %d): %s",
+				filename, i+1, c.Field, fmt.Sprintf(format, args...)))
+		}
+		var m fieldMapping
+		switch {
+		case c.Field <= 0:
+			bad("no field ID")
+			continue
+		case seen[c.Field]:
+			bad("field mapped twice")
+			continue
+		case (c.Column == "") == (c.Computed == ""):
+			bad("want one of column or computed")
+			continue
+		case c.Column != "":
+			m = fieldMapping{c.Field, column(c.Column), c.Backfill}
+		default:
+			source, ok := computedSources[c.Computed]
+			if !ok {
+				bad("unknown computed value %q: want checklist_url, observed_on, taxon_category, or breeding_code", c.Computed)
+				continue
+			}
+			m = fieldMapping{c.Field, source, c.Backfill}
+		}
+		for _, k := range syncKeyFields {
+			if k.field == m.field && k.source.name != m.source.name {
+				bad("the sync key's field must be mapped from %q", k.source.name)
+			}
+		}
+		seen[c.Field] = true
+		mappings = append(mappings, m)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	for _, k := range syncKeyFields {
+		if !seen[k.field] {
+			mappings = append(mappings, k)
+		}
+	}
+	return mappings, nil
+}
+
+// checkFieldDatatypes checks each mapping's values against the datatype of
+// its field on iNaturalist, and, for a field that allows only some values,
+// against those, so that a field that can't hold them is found before
+// anything is created rather than by a failure on every record. The values
+// are those of records, which are only read if a field restricts them.
+func checkFieldDatatypes(inatClient inatClient, mappings []fieldMapping, records iter.Seq2[ebird.Record, error]) error {
+	problems, err := fieldProblems(inatClient, mappings, records)
+	if err != nil {
+		return fmt.Errorf("checkFieldDatatypes: %w", err)
+	}
+	return errors.Join(problems...)
+}
+
+// usableFields returns mappings without those whose field can't hold their
+// values, logging each it leaves out. It is for the default mapping, whose
+// fields nobody chose, so a field iNaturalist doesn't have as birdsync
+// expects is better left unset than a reason to stop (P-084). The sync key's
+// fields are never left out; a problem with one is an error.
+func usableFields(inatClient inatClient, mappings []fieldMapping, records iter.Seq2[ebird.Record, error]) ([]fieldMapping, error) {
+	problems, err := fieldProblems(inatClient, mappings, records)
+	if err != nil {
+		return nil, fmt.Errorf("usableFields: %w", err)
+	}
+	var usable []fieldMapping
+	for i, m := range mappings {
+		switch {
+		case problems[i] == nil:
+			usable = append(usable, m)
+		case slices.ContainsFunc(syncKeyFields, func(k fieldMapping) bool { return k.field == m.field }):
+			return nil, fmt.Errorf("usableFields: %w", problems[i])
+		default:
+			log.Printf("Not setting %s: %v", m.source.name, problems[i])
+		}
+	}
+	return usable, nil
+}
+
+// fieldProblems returns what keeps each mapping's field, as iNaturalist
+// defines it, from holding the mapping's values in records: nil for a mapping
+// it can.
+func fieldProblems(inatClient inatClient, mappings []fieldMapping, records iter.Seq2[ebird.Record, error]) ([]error, error) {
+	ids := make([]int, len(mappings))
+	for i, m := range mappings {
+		ids[i] = m.field
+	}
+	fields, err := inatClient.GetObservationFields(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]inat.ObservationField{}
+	for _, f := range fields {
+		byID[f.ID] = f
+	}
+	problems := make([]error, len(mappings))
+	restricted := map[int][]string{} // the allowed values, by mapping
+	for i, m := range mappings {
+		f, ok := byID[m.field]
+		switch {
+		case !ok:
+			problems[i] = fmt.Errorf("observation field %d doesn't exist", m.field)
+		case f.Datatype != "text" && f.Datatype != m.source.datatype:
+			problems[i] = fmt.Errorf("observation field %d (%s) holds %s values, but %q is %s",
+				m.field, f.Name, f.Datatype, m.source.name, m.source.datatype)
+		case f.AllowedValues != "":
+			restricted[i] = strings.Split(f.AllowedValues, "|")
+		}
+	}
+	if len(restricted) == 0 || records == nil {
+		return problems, nil
+	}
+	disallowed := map[int][]string{} // by mapping, in the order first read
+	for rec, err := range records {
+		if err != nil {
+			return nil, err
+		}
+		for i, allowed := range restricted {
+			v := mappings[i].source.value(rec)
+			if v != "" && !slices.Contains(allowed, v) && !slices.Contains(disallowed[i], v) {
+				disallowed[i] = append(disallowed[i], v)
+			}
+		}
+	}
+	for i, values := range disallowed {
+		m, f := mappings[i], byID[mappings[i].field]
+		var quoted []string
+		for _, v := range values[:min(len(values), maxValuesReported)] {
+			quoted = append(quoted, strconv.Quote(v))
+		}
+		if len(values) > maxValuesReported {
+			quoted = append(quoted, fmt.Sprintf("and %d more", len(values)-maxValuesReported))
+		}
+		problems[i] = fmt.Errorf("observation field %d (%s) allows only %s, but %q has %s",
+			m.field, f.Name, strings.ReplaceAll(f.AllowedValues, "|", ", "), m.source.name, strings.Join(quoted, ", "))
+	}
+	return problems, nil
+}
+
+// maxValuesReported is how many of a column's values outside a field's
+// allowed values fieldProblems names.
+const maxValuesReported = 5
+
+// recordFieldValues returns the observation field values for rec. A value the
+// record leaves empty, as a stationary checklist does its distance, sets no
+// field.
+func recordFieldValues(rec ebird.Record) []inat.ObservationFieldValue {
+	var values []inat.ObservationFieldValue
+	for _, m := range observationFields {
+		if v := m.source.value(rec); v != "" {
+			values = append(values, inat.ObservationFieldValue{ObservationFieldID: m.field, Value: v})
+		}
+	}
+	return values
+}
+
+// missingFieldValues returns those of rec's backfill field values that o has
+// no value for. A field o has a value for keeps it, even one that differs
+// from the export: it may have been corrected on iNaturalist.
+func missingFieldValues(rec ebird.Record, o indexedObservation) []inat.ObservationFieldValue {
+	var missing []inat.ObservationFieldValue
+	for _, m := range observationFields {
+		if v := m.source.value(rec); m.backfill && v != "" {
+			if _, ok := o.Fields[m.field]; !ok {
+				missing = append(missing, inat.ObservationFieldValue{ObservationFieldID: m.field, Value: v})
+			}
+		}
+	}
+	return missing
+}
+
+// indexedFieldValues returns r's observation field values by field ID. They
+// are all kept, not only those of the fields observationFields backfills,
+// because a mirror outlives the --fields of the run that made it.
+func indexedFieldValues(r inat.Result) map[int]string {
+	var values map[int]string
+	for _, ofv := range r.Ofvs {
+		if ofv.Value != "" {
+			if values == nil {
+				values = map[int]string{}
+			}
+			values[ofv.FieldID] = ofv.Value
+		}
+	}
+	return values
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// writeFieldsFile writes a --fields file holding data, and returns its name.
+func writeFieldsFile(t *testing.T, data string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "fields.json")
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// TestReadFieldMappings checks that a --fields file is read with the sync
+// key's fields added, and that each mistake in one is reported.
+//
+// Verifies: P-085.
+func TestReadFieldMappings(t *testing.T) {
+	mappings, err := readFieldMappings(writeFieldsFile(t, `[
+		{"field": 1, "column": "Count"},
+		{"field": 50001, "column": "Location ID"},
+		{"field": 50002, "computed": "checklist_url", "backfill": true}
+	]`))
+	if err != nil {
+		t.Fatalf("readFieldMappings() error = %v", err)
+	}
+	var got []string
+	for _, m := range mappings {
+		got = append(got, m.source.name)
+	}
+	want := []string{"Count", "Location ID", "checklist_url", "Submission ID", "Scientific Name"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mapped from %q, want %q", got, want)
+	}
+	if !mappings[2].backfill || mappings[1].backfill {
+		t.Errorf("backfill = %v, %v, want false, true", mappings[1].backfill, mappings[2].backfill)
+	}
+
+	_, err = readFieldMappings(writeFieldsFile(t, `[
+		{"column": "Count"},
+		{"field": 50001, "column": "Location ID"},
+		{"field": 50001, "column": "Location"},
+		{"field": 50002, "column": "Count", "computed": "checklist_url"},
+		{"field": 50003, "computed": "checklist"},
+		{"field": 6033, "column": "Location ID"}
+	]`))
+	if err == nil {
+		t.Fatal("readFieldMappings() succeeded, want an error")
+	}
+	for _, want := range []string{
+		"entry 1 (field 0): no field ID",
+		"entry 3 (field 50001): field mapped twice",
+		"entry 4 (field 50002): want one of column or computed",
+		`entry 5 (field 50003): unknown computed value "checklist"`,
+		`entry 6 (field 6033): the sync key's field must be mapped from "Submission ID"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("readFieldMappings() error missing %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestCheckFieldDatatypes checks that a field that can't hold the values
+// mapped to it, doesn't exist, or doesn't allow a value the export has for
+// it, is refused, and that a text field takes any value.
+//
+// Verifies: P-085.
+func TestCheckFieldDatatypes(t *testing.T) {
+	mockInat := &mockINatClient{fieldDefinitions: []inat.ObservationField{
+		{ID: inat.CountField, Name: "Count", Datatype: "numeric"},
+		{ID: inat.DistanceField, Name: "Distance", Datatype: "numeric"},
+		{ID: 50001, Name: "eBird Location ID", Datatype: "text"},
+		{ID: 50002, Name: "Date Seen", Datatype: "date"},
+		{ID: 50003, Name: "Checklist Minutes", Datatype: "numeric"},
+		{ID: 50005, Name: "Survey Protocol", Datatype: "text", AllowedValues: "Traveling|Stationary"},
+	}}
+	records := func(protocols ...string) iter.Seq2[ebird.Record, error] {
+		m := &mockEBirdClient{}
+		for _, p := range protocols {
+			m.records = append(m.records, ebird.Record{Protocol: p})
+		}
+		records, _ := m.Records(nil, ebird.AutoDateOrder)
+		return records
+	}
+	ok := []fieldMapping{
+		{inat.CountField, column("Count"), false},
+		{inat.DistanceField, column("Distance Traveled (km)"), true},
+		{50001, column("Duration (Min)"), false},
+		{50002, computedSources["observed_on"], false},
+		{50005, column("Protocol"), false},
+	}
+	if err := checkFieldDatatypes(mockInat, ok, records("Traveling", "Stationary", "")); err != nil {
+		t.Errorf("checkFieldDatatypes() error = %v", err)
+	}
+
+	bad := []fieldMapping{
+		{50003, column("Location"), false},
+		{50004, column("Count"), false},
+		{50005, column("Protocol"), false},
+	}
+	err := checkFieldDatatypes(mockInat, bad, records("Traveling", "Incidental", "Area", "Incidental"))
+	if err == nil {
+		t.Fatal("checkFieldDatatypes() succeeded, want an error")
+	}
+	for _, want := range []string{
+		`observation field 50003 (Checklist Minutes) holds numeric values, but "Location" is text`,
+		"observation field 50004 doesn't exist",
+		`observation field 50005 (Survey Protocol) allows only Traveling, Stationary, but "Protocol" has "Incidental", "Area"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("checkFieldDatatypes() error missing %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestNumericColumnNotANumber checks that a numeric column's value that isn't
+// a number, such as an uncounted X, sets no field.
+//
+// Verifies: P-039.
+func TestNumericColumnNotANumber(t *testing.T) {
+	for count, want := range map[string]string{"3": "3", "X": "", "": ""} {
+		if got := column("Count").value(ebird.Record{Count: count}); got != want {
+			t.Errorf("Count %q reads as %q, want %q", count, got, want)
+		}
+	}
+}
+
+// TestUsableFields checks that a default field iNaturalist doesn't have, or
+// that can't hold its column's values, is left out, and that a problem with
+// the sync key's is an error instead.
+//
+// Verifies: P-084.
+func TestUsableFields(t *testing.T) {
+	mockInat := &mockINatClient{fieldDefinitions: []inat.ObservationField{
+		{ID: inat.DistanceField, Name: "Distance", Datatype: "numeric"},
+		{ID: 50001, Name: "Duration", Datatype: "date"},
+		{ID: inat.EBirdField, Name: "eBird Checklist", Datatype: "text"},
+		{ID: inat.EBirdScientificNameField, Name: "eBird Scientific Name", Datatype: "text"},
+	}}
+	mappings := append([]fieldMapping{
+		{inat.DistanceField, column("Distance Traveled (km)"), true},
+		{50001, column("Duration (Min)"), true},
+		{50002, column("Protocol"), true},
+	}, syncKeyFields...)
+	usable, err := usableFields(mockInat, mappings, nil)
+	if err != nil {
+		t.Fatalf("usableFields() error = %v", err)
+	}
+	var got []int
+	for _, m := range usable {
+		got = append(got, m.field)
+	}
+	if want := []int{inat.DistanceField, inat.EBirdField, inat.EBirdScientificNameField}; !reflect.DeepEqual(got, want) {
+		t.Errorf("usable fields %v, want %v", got, want)
+	}
+
+	mockInat.fieldDefinitions = mockInat.fieldDefinitions[:3]
+	if _, err := usableFields(mockInat, mappings, nil); err == nil {
+		t.Error("usableFields() without the eBird Scientific Name field succeeded, want an error")
+	}
+}
+
+// TestFieldsFileSync checks that the observations a sync creates have the
+// fields --fields maps, the sync key's among them, and no others.
+//
+// Verifies: P-085, P-019.
+func TestFieldsFileSync(t *testing.T) {
+	resetFlags()
+	fieldsFile = writeFieldsFile(t, `[
+		{"field": 50001, "column": "Location ID"},
+		{"field": 50002, "computed": "observed_on"},
+		{"field": 50003, "column": "Age/Sex"}
+	]`)
+	checkArgs(nil)
+	defer resetFlags()
+
+	rec := crowRecord("S970")
+	rec.LocationID = "L123456"
+	rec.Extra = map[string]string{"Age/Sex": "Juvenile (1)"}
+	mockInat := &mockINatClient{}
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: []ebird.Record{rec}}, "myUserID", mockInat)
+
+	if len(mockInat.created) != 1 {
+		t.Fatalf("created %d observations, want 1", len(mockInat.created))
+	}
+	got := map[int]any{}
+	for _, f := range mockInat.created[0].ObservationFieldValuesAttributes {
+		got[f.ObservationFieldID] = f.Value
+	}
+	want := map[int]any{
+		50001:                         "L123456",
+		50002:                         "2023-01-03",
+		50003:                         "Juvenile (1)",
+		inat.EBirdField:               "S970",
+		inat.EBirdScientificNameField: "Corvus brachyrhynchos",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("created with fields %v, want %v", got, want)
+	}
+}
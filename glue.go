@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"iter"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Sajmani/birdsync/ebird"
 	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
 )
 
 // dateTimeFlag validates a command line flag containing a date or a date & time.
@@ -33,16 +37,49 @@ func (f *dateTimeFlag) Time() time.Time {
 	return f.t
 }
 
+// categoriesFlag is a comma-separated set of eBird taxon categories, kept in
+// order so that two flags naming the same set compare equal.
+type categoriesFlag []ebird.Category
+
+func (f *categoriesFlag) String() string {
+	var names []string
+	for _, c := range *f {
+		names = append(names, c.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *categoriesFlag) Set(s string) error {
+	var set categoriesFlag
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		var c ebird.Category
+		if err := c.UnmarshalText([]byte(name)); err != nil {
+			return err
+		}
+		set = append(set, c)
+	}
+	slices.Sort(set)
+	*f = slices.Compact(set)
+	return nil
+}
+
+func (f categoriesFlag) has(c ebird.Category) bool {
+	return slices.Contains(f, c)
+}
+
 // ebirdClient encapsulates the ebird package functions for testing.
 type ebirdClient interface {
-	Records(string) (iter.Seq[ebird.Record], error)
+	Records([]string, ebird.DateOrder) (iter.Seq2[ebird.Record, error], error)
 	DownloadMLAsset(string) (string, bool, error)
 }
 
 type ebirdClientImpl struct{}
 
-func (ebirdClientImpl) Records(path string) (iter.Seq[ebird.Record], error) {
-	return ebird.Records(path)
+func (ebirdClientImpl) Records(paths []string, order ebird.DateOrder) (iter.Seq2[ebird.Record, error], error) {
+	return ebird.Merge(paths, order)
 }
 
 func (ebirdClientImpl) DownloadMLAsset(id string) (string, bool, error) {
@@ -53,10 +90,18 @@ func (ebirdClientImpl) DownloadMLAsset(id string) (string, bool, error) {
 type inatClient interface {
 	GetUserID() string
 	GetAPIToken() string
-	DownloadObservations(string, time.Time, time.Time, ...string) ([]inat.Result, error)
+	QueryObservations(context.Context, inat.ObservationQuery, ...string) iter.Seq2[inat.Result, error]
+	CountObservations(context.Context, inat.ObservationQuery) (int, error)
+	GetObservations([]uuid.UUID, ...string) ([]inat.Result, error)
 	CreateObservation(inat.Observation) error
 	UpdateObservation(inat.Observation) error
+	DeleteObservation(uuid.UUID) error
 	UploadMedia(string, bool, string, string) error
+	AddAnnotation(uuid.UUID, inat.Annotation) error
+	CreateIdentification(inat.Identification) error
+	GetObservationFields([]int) ([]inat.ObservationField, error)
+	SearchTaxa(string) ([]inat.Taxon, error)
+	Budget() *inat.Budget
 }
 
 type inatClientImpl struct {
@@ -71,8 +116,24 @@ func (c inatClientImpl) GetAPIToken() string {
 	return inat.GetAPIToken()
 }
 
-func (c inatClientImpl) DownloadObservations(userID string, after, before time.Time, fields ...string) ([]inat.Result, error) {
-	return c.client.DownloadObservations(userID, after, before, fields...)
+func (c inatClientImpl) QueryObservations(ctx context.Context, q inat.ObservationQuery, fields ...string) iter.Seq2[inat.Result, error] {
+	return c.client.QueryObservations(ctx, q, fields...)
+}
+
+func (c inatClientImpl) CountObservations(ctx context.Context, q inat.ObservationQuery) (int, error) {
+	return c.client.CountObservations(ctx, q)
+}
+
+func (c inatClientImpl) GetObservations(uuids []uuid.UUID, fields ...string) ([]inat.Result, error) {
+	return c.client.GetObservations(uuids, fields...)
+}
+
+func (c inatClientImpl) GetObservationFields(ids []int) ([]inat.ObservationField, error) {
+	return c.client.GetObservationFields(ids)
+}
+
+func (c inatClientImpl) SearchTaxa(q string) ([]inat.Taxon, error) {
+	return c.client.SearchTaxa(q)
 }
 
 func (c inatClientImpl) CreateObservation(obs inat.Observation) error {
@@ -83,6 +144,22 @@ func (c inatClientImpl) UpdateObservation(obs inat.Observation) error {
 	return c.client.UpdateObservation(obs)
 }
 
+func (c inatClientImpl) DeleteObservation(u uuid.UUID) error {
+	return c.client.DeleteObservation(u)
+}
+
 func (c inatClientImpl) UploadMedia(filename string, isPhoto bool, assetID, obsUUID string) error {
 	return c.client.UploadMedia(filename, isPhoto, assetID, obsUUID)
 }
+
+func (c inatClientImpl) AddAnnotation(obsUUID uuid.UUID, a inat.Annotation) error {
+	return c.client.AddAnnotation(obsUUID, a)
+}
+
+func (c inatClientImpl) CreateIdentification(ident inat.Identification) error {
+	return c.client.CreateIdentification(ident)
+}
+
+func (c inatClientImpl) Budget() *inat.Budget {
+	return c.client.Budget()
+}
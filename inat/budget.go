@@ -0,0 +1,248 @@
+package inat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultDailyRequests is how many requests a day iNaturalist's recommended
+// practices ask a client to stay under (T-035).
+const DefaultDailyRequests = 10000
+
+const (
+	// budgetWindow is the rolling window a Budget counts over.
+	budgetWindow = 24 * time.Hour
+	// budgetBucket is the granularity of the count. A request is counted
+	// until a window after the end of its bucket, so the count errs high,
+	// by at most one bucket, rather than low.
+	budgetBucket = 10 * time.Minute
+	// budgetLockWait is how long spend waits for another run to release
+	// the count, and budgetLockStale how old a lock is when it is taken to
+	// have been left by a run killed while holding it. A run holds the lock
+	// only to read and rewrite a small file, so both are generous.
+	budgetLockWait  = 30 * time.Second
+	budgetLockStale = 10 * time.Second
+)
+
+// A Budget caps the requests one iNaturalist user's clients make in any 24
+// hours, across runs (T-035, P-075). The count is kept in a file, read and
+// rewritten on every request under a lock file beside it, so that runs one
+// after another, or two at once, share it. A nil *Budget allows every request.
+type Budget struct {
+	mu       sync.Mutex
+	filename string // "" keeps the count in memory, for one run
+	userID   string
+	limit    int
+	users    map[string][]requestCount // the count, when filename is ""
+}
+
+// A requestCount is the number of requests made in the budgetBucket starting
+// at Start.
+type requestCount struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// NewBudget returns a budget of limit requests a day for userID, counted in
+// filename, which holds the counts of every user that shares it.
+func NewBudget(filename, userID string, limit int) *Budget {
+	return &Budget{filename: filename, userID: userID, limit: limit}
+}
+
+// ErrBudgetSpent is returned, wrapped in a *BudgetError, for a request the
+// budget doesn't allow.
+var ErrBudgetSpent = errors.New("daily iNaturalist request budget spent")
+
+// A BudgetError reports that a request wasn't sent because the budget was
+// spent, and when it next allows one.
+type BudgetError struct {
+	Limit int
+	Until time.Time
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%v: made %d requests in the last 24 hours; the next is allowed at %s",
+		ErrBudgetSpent, e.Limit, e.Until.Local().Format(time.DateTime))
+}
+
+func (e *BudgetError) Unwrap() error { return ErrBudgetSpent }
+
+// Limit returns the most requests the budget allows in 24 hours, or 0 for no
+// limit.
+func (b *Budget) Limit() int {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// Available returns when n more requests fit in the budget: the zero time if
+// they fit now.
+func (b *Budget) Available(n int) (time.Time, error) {
+	if b == nil {
+		return time.Time{}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	users, err := b.load()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return available(users[b.userID], b.limit, n, time.Now()), nil
+}
+
+// spend counts one request, if the budget allows it. It is called just before
+// each request is sent, retries included, since iNaturalist counts those too.
+func (b *Budget) spend() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	unlock, err := b.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	users, err := b.load()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	counts := users[b.userID]
+	if t := available(counts, b.limit, 1, now); !t.IsZero() {
+		return &BudgetError{Limit: b.limit, Until: t}
+	}
+	start := now.Truncate(budgetBucket).UTC()
+	if n := len(counts); n > 0 && counts[n-1].Start.Equal(start) {
+		counts[n-1].Count++
+	} else {
+		counts = append(counts, requestCount{Start: start, Count: 1})
+	}
+	users[b.userID] = counts
+	return b.save(users)
+}
+
+// available returns when n more requests fit in limit, given counts, oldest
+// first: the zero time if they fit now.
+func available(counts []requestCount, limit, n int, now time.Time) time.Time {
+	spent := 0
+	for _, c := range counts {
+		spent += c.Count
+	}
+	if spent+n <= limit {
+		return time.Time{}
+	}
+	for _, c := range counts {
+		spent -= c.Count
+		if spent+n <= limit {
+			return c.Start.Add(budgetBucket + budgetWindow)
+		}
+	}
+	// More than the whole budget never fits; this is when the most will.
+	return now.Truncate(budgetBucket).Add(budgetBucket + budgetWindow)
+}
+
+// lock keeps other runs from rewriting the count file until unlock is called,
+// so that two runs can't both read a count and each write back its own
+// request on top of it, losing the other's. The lock is a file created beside
+// the count, which only one run can create. Available needs no lock: the count
+// file is replaced whole, so it is always read complete.
+func (b *Budget) lock() (unlock func(), err error) {
+	if b.filename == "" {
+		return func() {}, nil
+	}
+	lockname := b.filename + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockname), 0o700); err != nil {
+		return nil, fmt.Errorf("locking request budget %s: %w", b.filename, err)
+	}
+	deadline := time.Now().Add(budgetLockWait)
+	for {
+		f, err := os.OpenFile(lockname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockname) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("locking request budget %s: %w", b.filename, err)
+		}
+		if fi, err := os.Stat(lockname); err == nil && time.Since(fi.ModTime()) > budgetLockStale {
+			os.Remove(lockname)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("locking request budget %s: %s has been held for %v; remove it if no other birdsync is running",
+				b.filename, lockname, budgetLockWait)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// load returns every user's counts, without those that have left the window.
+func (b *Budget) load() (map[string][]requestCount, error) {
+	users := b.users
+	if b.filename != "" {
+		users = nil
+		data, err := os.ReadFile(b.filename)
+		if err == nil {
+			err = json.Unmarshal(data, &users)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading request budget %s: %w", b.filename, err)
+		}
+	}
+	if users == nil {
+		users = map[string][]requestCount{}
+	}
+	cutoff := time.Now().Add(-budgetWindow - budgetBucket)
+	for user, counts := range users {
+		i := 0
+		for i < len(counts) && !counts[i].Start.After(cutoff) {
+			i++
+		}
+		if i == len(counts) {
+			delete(users, user)
+		} else {
+			users[user] = counts[i:]
+		}
+	}
+	return users, nil
+}
+
+// save writes every user's counts back, replacing the file whole so that a
+// run killed partway leaves the old count rather than none.
+func (b *Budget) save(users map[string][]requestCount) error {
+	if b.filename == "" {
+		b.users = users
+		return nil
+	}
+	data, err := json.Marshal(users)
+	if err != nil {
+		return fmt.Errorf("writing request budget %s: %w", b.filename, err)
+	}
+	dir := filepath.Dir(b.filename)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("writing request budget %s: %w", b.filename, err)
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(b.filename)+".*")
+	if err != nil {
+		return fmt.Errorf("writing request budget %s: %w", b.filename, err)
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), b.filename)
+	}
+	if err != nil {
+		return fmt.Errorf("writing request budget %s: %w", b.filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package inat
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestBudgetCapsRequests checks that a client stops sending once its user's
+// budget is spent, and that the count carries over to a later client, as it
+// does between runs, for that user and no other.
+//
+// Verifies: T-035, P-075.
+func TestBudgetCapsRequests(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+	filename := filepath.Join(t.TempDir(), "requests.json")
+
+	client := newTestClient(server.URL, "", "")
+	client.SetBudget(NewBudget(filename, "alice", 3))
+	for i := range 3 {
+		if err := client.UpdateObservation(Observation{UUID: uuid.New()}); err != nil {
+			t.Fatalf("request %d of 3: %v", i+1, err)
+		}
+	}
+	err := client.UpdateObservation(Observation{UUID: uuid.New()})
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || !errors.Is(err, ErrBudgetSpent) {
+		t.Fatalf("fourth request error = %v, want a *BudgetError", err)
+	}
+	if d := time.Until(budgetErr.Until); d < 24*time.Hour || d > 24*time.Hour+budgetBucket {
+		t.Errorf("next request allowed in %v, want a day", d)
+	}
+	if requests := requests.Load(); requests != 3 {
+		t.Errorf("server saw %d requests, want 3", requests)
+	}
+
+	client = newTestClient(server.URL, "", "")
+	client.SetBudget(NewBudget(filename, "alice", 3))
+	if err := client.UpdateObservation(Observation{UUID: uuid.New()}); !errors.Is(err, ErrBudgetSpent) {
+		t.Errorf("the next run's first request error = %v, want the budget spent", err)
+	}
+	client.SetBudget(NewBudget(filename, "bob", 3))
+	if err := client.UpdateObservation(Observation{UUID: uuid.New()}); err != nil {
+		t.Errorf("another user's request error = %v, want it sent", err)
+	}
+}
+
+// TestBudgetWindowRolls checks that requests stop counting a day after they
+// were made, and that Available says when enough of them will have.
+//
+// Verifies: P-075.
+func TestBudgetWindowRolls(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "requests.json")
+	now := time.Now().Truncate(budgetBucket).UTC()
+	counts := fmt.Sprintf(`{"alice":[{"start":%q,"count":50},{"start":%q,"count":4},{"start":%q,"count":5}]}`,
+		now.Add(-25*time.Hour).Format(time.RFC3339), // out of the window
+		now.Add(-20*time.Hour).Format(time.RFC3339),
+		now.Add(-time.Hour).Format(time.RFC3339))
+	if err := os.WriteFile(filename, []byte(counts), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBudget(filename, "alice", 10)
+	for _, tt := range []struct {
+		n    int
+		want time.Time
+	}{
+		{1, time.Time{}},
+		{2, now.Add(-20*time.Hour + budgetBucket + budgetWindow)},
+		{5, now.Add(-20*time.Hour + budgetBucket + budgetWindow)},
+		{6, now.Add(-time.Hour + budgetBucket + budgetWindow)},
+	} {
+		got, err := b.Available(tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("Available(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+	var nilBudget *Budget
+	if got, err := nilBudget.Available(1000000); err != nil || !got.IsZero() {
+		t.Errorf("nil Budget.Available = %v, %v; want no limit", got, err)
+	}
+}
+
+// TestBudgetSharedAtOnce checks that two runs spending from one count file at
+// the same time lose none of each other's requests.
+//
+// Verifies: P-075.
+func TestBudgetSharedAtOnce(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "requests.json")
+	const runs, each = 2, 50
+	var wg sync.WaitGroup
+	for range runs {
+		b := NewBudget(filename, "alice", runs*each)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				if err := b.spend(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	b := NewBudget(filename, "alice", runs*each)
+	if until, err := b.Available(1); err != nil || until.IsZero() {
+		t.Errorf("Available(1) = %v, %v after %d requests; want the budget spent", until, err, runs*each)
+	}
+	if _, err := os.Stat(filename + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package inat
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +25,12 @@ const (
 	BaseURL = "https://api.inaturalist.org/v2"
 )
 
+// A Client makes requests to the iNaturalist API on behalf of one user,
+// paced (T-035) and, given a Budget, counted. Each method that makes requests
+// has a variant with the suffix Context, whose context bounds the whole call,
+// pacing, retries, and checks on whether a failed write landed included, so a
+// caller can use it to set a deadline or cancel. The method without the suffix
+// calls its variant with context.Background().
 type Client struct {
 	apiToken  string
 	userAgent string
@@ -34,6 +42,9 @@ type Client struct {
 	mu                 sync.Mutex
 	minRequestInterval time.Duration
 	lastRequest        time.Time
+	maxAttempts        int
+	retryBackoff       time.Duration
+	budget             *Budget
 }
 
 func NewClient(baseURL, apiToken, userAgent string) *Client {
@@ -42,6 +53,8 @@ func NewClient(baseURL, apiToken, userAgent string) *Client {
 		apiToken:           apiToken,
 		userAgent:          userAgent,
 		minRequestInterval: DefaultMinRequestInterval,
+		maxAttempts:        DefaultMaxAttempts,
+		retryBackoff:       DefaultRetryBackoff,
 	}
 }
 
@@ -72,22 +85,51 @@ func (c *Client) SetMinRequestInterval(d time.Duration) {
 	c.minRequestInterval = d
 }
 
-// pace blocks until enough time has passed since the previous request.
-func (c *Client) pace() {
+// SetBudget caps the requests the client makes, counted with those of every
+// other client sharing b. A request over the cap fails with a *BudgetError
+// without being sent. A nil b, the default, sets no cap.
+func (c *Client) SetBudget(b *Budget) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if !c.lastRequest.IsZero() {
-		if wait := c.minRequestInterval - time.Since(c.lastRequest); wait > 0 {
-			time.Sleep(wait)
-		}
+	c.budget = b
+}
+
+// Budget returns the budget set by SetBudget.
+func (c *Client) Budget() *Budget {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.budget
+}
+
+// pace blocks until enough time has passed since the previous request, or
+// until ctx is done.
+func (c *Client) pace(ctx context.Context) error {
+	// The slot is reserved under the lock and waited for outside it, so a
+	// caller whose context is canceled stops waiting at once rather than
+	// once the callers queued ahead of it have had their turns. Its slot
+	// goes unused, which delays the next caller by one interval at most.
+	c.mu.Lock()
+	slot := time.Now()
+	if next := c.lastRequest.Add(c.minRequestInterval); !c.lastRequest.IsZero() && next.After(slot) {
+		slot = next
 	}
-	c.lastRequest = time.Now()
+	c.lastRequest = slot
+	c.mu.Unlock()
+	return sleep(ctx, time.Until(slot))
 }
 
+// roundTrip sends req, which carries the caller's context, once. Callers use
+// send, which retries.
 func (c *Client) roundTrip(req *http.Request) (string, error) {
 	// Every request in this package goes through here, which is the only
-	// reason one pacing call is enough.
-	c.pace()
+	// reason one pacing call is enough. A retry is paced like any other
+	// request.
+	if err := c.pace(req.Context()); err != nil {
+		return "", fmt.Errorf("waiting to send request: %w", err)
+	}
+	if err := c.Budget().spend(); err != nil {
+		return "", err
+	}
 	req.Header.Set("User-Agent", c.userAgent)
 	req.Header.Set("Authorization", c.apiToken)
 
@@ -96,7 +138,7 @@ func (c *Client) roundTrip(req *http.Request) (string, error) {
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("making HTTP request: %w", err)
+		return "", transportError{fmt.Errorf("making HTTP request: %w", err)}
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusUnauthorized {
@@ -108,7 +150,7 @@ func (c *Client) roundTrip(req *http.Request) (string, error) {
 	}
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("reading HTTP response: %w", err)
+		return "", transportError{fmt.Errorf("reading HTTP response: %w", err)}
 	}
 	body := string(b)
 	if debug {
@@ -117,7 +159,13 @@ func (c *Client) roundTrip(req *http.Request) (string, error) {
 	return body, nil
 }
 
+// CreateObservation is CreateObservationContext with a background context.
 func (c *Client) CreateObservation(obs Observation) error {
+	return c.CreateObservationContext(context.Background(), obs)
+}
+
+// CreateObservationContext creates obs, under the UUID it carries.
+func (c *Client) CreateObservationContext(ctx context.Context, obs Observation) error {
 	buf := &bytes.Buffer{}
 	err := json.NewEncoder(buf).Encode(CreateObservation{
 		Observation: obs,
@@ -125,11 +173,16 @@ func (c *Client) CreateObservation(obs Observation) error {
 	if err != nil {
 		return fmt.Errorf("CreateObservation: %w", err)
 	}
-	req, err := http.NewRequest("POST", c.baseURL+"/observations", buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/observations", buf)
 	if err != nil {
 		return fmt.Errorf("CreateObservation: %w", err)
 	}
-	_, err = c.roundTrip(req)
+	// The observation's UUID is chosen here rather than by the service, so
+	// whether a failed create landed anyway can be asked by UUID.
+	_, err = c.send(req, func(ctx context.Context) (bool, error) {
+		results, err := c.GetObservationsContext(ctx, []uuid.UUID{obs.UUID})
+		return len(results) > 0, err
+	})
 	if err != nil {
 		return fmt.Errorf("CreateObservation: %w", err)
 	}
@@ -137,7 +190,14 @@ func (c *Client) CreateObservation(obs Observation) error {
 	return nil
 }
 
+// UpdateObservation is UpdateObservationContext with a background context.
 func (c *Client) UpdateObservation(obs Observation) error {
+	return c.UpdateObservationContext(context.Background(), obs)
+}
+
+// UpdateObservationContext updates the observation obs.UUID to obs, leaving
+// its photos alone.
+func (c *Client) UpdateObservationContext(ctx context.Context, obs Observation) error {
 	buf := &bytes.Buffer{}
 	err := json.NewEncoder(buf).Encode(UpdateObservation{
 		IgnorePhotos: true, // don't clobber photos!
@@ -146,11 +206,11 @@ func (c *Client) UpdateObservation(obs Observation) error {
 	if err != nil {
 		return fmt.Errorf("UpdateObservation: %w", err)
 	}
-	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/observations/%s", c.baseURL, obs.UUID), buf)
+	req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/observations/%s", c.baseURL, obs.UUID), buf)
 	if err != nil {
 		return fmt.Errorf("UpdateObservation: %w", err)
 	}
-	_, err = c.roundTrip(req)
+	_, err = c.send(req, nil)
 	if err != nil {
 		return fmt.Errorf("UpdateObservation: %w", err)
 	}
@@ -158,12 +218,90 @@ func (c *Client) UpdateObservation(obs Observation) error {
 	return nil
 }
 
+// AddAnnotation is AddAnnotationContext with a background context.
+func (c *Client) AddAnnotation(obsUUID uuid.UUID, a Annotation) error {
+	return c.AddAnnotationContext(context.Background(), obsUUID, a)
+}
+
+// AddAnnotationContext annotates the observation obsUUID with a.
+func (c *Client) AddAnnotationContext(ctx context.Context, obsUUID uuid.UUID, a Annotation) error {
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(CreateAnnotation{
+		Annotation: ObservationAnnotation{
+			ResourceType: "Observation",
+			ResourceID:   obsUUID,
+			Annotation:   a,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("AddAnnotation: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/annotations", buf)
+	if err != nil {
+		return fmt.Errorf("AddAnnotation: %w", err)
+	}
+	// An observation can't carry the same annotation twice, so the second
+	// attempt at one that landed would be refused; ask first.
+	_, err = c.send(req, func(ctx context.Context) (bool, error) {
+		results, err := c.GetObservationsContext(ctx, []uuid.UUID{obsUUID}, "annotations.all")
+		return len(results) > 0 && slices.Contains(results[0].Annotations, a), err
+	})
+	if err != nil {
+		return fmt.Errorf("AddAnnotation: %w", err)
+	}
+	log.Printf("Annotated %s with term %d, value %d\n", ObservationURL(obsUUID), a.ControlledAttributeID, a.ControlledValueID)
+	return nil
+}
+
+// CreateIdentification is CreateIdentificationContext with a background
+// context.
+func (c *Client) CreateIdentification(ident Identification) error {
+	return c.CreateIdentificationContext(context.Background(), ident)
+}
+
+// CreateIdentificationContext adds ident to its observation.
+func (c *Client) CreateIdentificationContext(ctx context.Context, ident Identification) error {
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(CreateIdentification{Identification: ident})
+	if err != nil {
+		return fmt.Errorf("CreateIdentification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/identifications", buf)
+	if err != nil {
+		return fmt.Errorf("CreateIdentification: %w", err)
+	}
+	// Sending an identification that landed again would add a second and
+	// withdraw the first, so ask first whether the observation already has
+	// it.
+	_, err = c.send(req, func(ctx context.Context) (bool, error) {
+		results, err := c.GetObservationsContext(ctx, []uuid.UUID{ident.ObservationID}, "identifications.all")
+		landed := len(results) > 0 && results[0].HasIdentification(ident)
+		return landed, err
+	})
+	if err != nil {
+		return fmt.Errorf("CreateIdentification: %w", err)
+	}
+	log.Printf("Identified %s as taxon %d\n", ObservationURL(ident.ObservationID), ident.TaxonID)
+	return nil
+}
+
+// DeleteObservation is DeleteObservationContext with a background context.
 func (c *Client) DeleteObservation(id uuid.UUID) error {
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/observations/%s", c.baseURL, id), nil)
+	return c.DeleteObservationContext(context.Background(), id)
+}
+
+// DeleteObservationContext deletes the observation id.
+func (c *Client) DeleteObservationContext(ctx context.Context, id uuid.UUID) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/observations/%s", c.baseURL, id), nil)
 	if err != nil {
 		return fmt.Errorf("DeleteObservation: %w", err)
 	}
-	_, err = c.roundTrip(req)
+	// Sending a delete that landed again would fail with a 404, so it is
+	// checked like a create, and landed if the observation is gone.
+	_, err = c.send(req, func(ctx context.Context) (bool, error) {
+		results, err := c.GetObservationsContext(ctx, []uuid.UUID{id})
+		return len(results) == 0, err
+	})
 	if err != nil {
 		return fmt.Errorf("DeleteObservation: %w", err)
 	}
@@ -171,7 +309,14 @@ func (c *Client) DeleteObservation(id uuid.UUID) error {
 	return nil
 }
 
+// UploadMedia is UploadMediaContext with a background context.
 func (c *Client) UploadMedia(filename string, isPhoto bool, mlAssetID string, obsUUID string) error {
+	return c.UploadMediaContext(context.Background(), filename, isPhoto, mlAssetID, obsUUID)
+}
+
+// UploadMediaContext uploads filename, the Macaulay Library asset mlAssetID,
+// to the observation obsUUID, as a photo if isPhoto and otherwise as a sound.
+func (c *Client) UploadMediaContext(ctx context.Context, filename string, isPhoto bool, mlAssetID string, obsUUID string) error {
 	destFilename := "ML" + mlAssetID + path.Ext(filename)
 	var fieldName string
 	var postURL string
@@ -208,13 +353,17 @@ func (c *Client) UploadMedia(filename string, isPhoto bool, mlAssetID string, ob
 		return fmt.Errorf("UploadMedia: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", postURL, &requestBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", postURL, &requestBody)
 	if err != nil {
 		return fmt.Errorf("UploadMedia: %w", err)
 	}
 	// Set the Content-Type header to the multipart writer's boundary.
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	_, err = c.roundTrip(req)
+	// An upload landed if the observation has a file by the name it was
+	// sent under.
+	_, err = c.send(req, func(ctx context.Context) (bool, error) {
+		return c.hasMedia(ctx, obsUUID, destFilename)
+	})
 	if err != nil {
 		return fmt.Errorf("UploadMedia: %w", err)
 	}
@@ -222,6 +371,32 @@ func (c *Client) UploadMedia(filename string, isPhoto bool, mlAssetID string, ob
 	return nil
 }
 
+// hasMedia reports whether observation obsUUID has a photo or sound that was
+// uploaded as filename.
+func (c *Client) hasMedia(ctx context.Context, obsUUID, filename string) (bool, error) {
+	u, err := uuid.Parse(obsUUID)
+	if err != nil {
+		return false, fmt.Errorf("hasMedia(%s): %w", obsUUID, err)
+	}
+	results, err := c.GetObservationsContext(ctx, []uuid.UUID{u}, "photos.all", "sounds.all")
+	if err != nil {
+		return false, fmt.Errorf("hasMedia(%s): %w", obsUUID, err)
+	}
+	for _, r := range results {
+		for _, p := range r.Photos {
+			if p.OriginalFilename == filename {
+				return true, nil
+			}
+		}
+		for _, s := range r.Sounds {
+			if s.OriginalFilename == filename {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 // maxErrorBody caps how much of a failed response is kept. iNaturalist's
 // explanations are short; anything longer is probably an HTML error page.
 const maxErrorBody = 512
@@ -234,10 +409,16 @@ type StatusError struct {
 	StatusCode int
 	Status     string
 	Body       string
+	// RetryAfter is how long a 429 or 503 asked the client to wait before
+	// trying again, or zero if it didn't say.
+	RetryAfter time.Duration
 }
 
 func newStatusError(resp *http.Response) *StatusError {
 	e := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
 	// A failure to read the body must not mask the failure being reported.
 	b, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
 	if err != nil {
@@ -1,24 +1,27 @@
 package inat
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// newTestClient returns a client with pacing switched off. Only the pacing
-// tests want a real delay between requests; everywhere else it would add
-// minutes to the suite and cover nothing.
+// newTestClient returns a client with pacing and retry backoff switched off.
+// Only the pacing and retry tests want a real delay between requests;
+// everywhere else it would add minutes to the suite and cover nothing.
 func newTestClient(baseURL, apiToken, userAgent string) *Client {
 	c := NewClient(baseURL, apiToken, userAgent)
 	c.SetMinRequestInterval(0)
+	c.SetRetryPolicy(DefaultMaxAttempts, 0)
 	return c
 }
 
@@ -111,6 +114,64 @@ func TestClient_DeleteObservation(t *testing.T) {
 	}
 }
 
+// TestClient_AddAnnotation checks that an annotation is posted against the
+// observation's UUID with the term and value given.
+//
+// Verifies: P-083.
+func TestClient_AddAnnotation(t *testing.T) {
+	obsUUID := uuid.New()
+	var body CreateAnnotation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/annotations" {
+			t.Errorf("Expected POST /annotations, got %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Decoding request body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, "test-token", "test-user-agent")
+
+	a := Annotation{ControlledAttributeID: LifeStageTerm, ControlledValueID: LifeStageJuvenile}
+	if err := client.AddAnnotation(obsUUID, a); err != nil {
+		t.Errorf("AddAnnotation() error = %v", err)
+	}
+	want := ObservationAnnotation{ResourceType: "Observation", ResourceID: obsUUID, Annotation: a}
+	if body.Annotation != want {
+		t.Errorf("annotation = %+v, want %+v", body.Annotation, want)
+	}
+}
+
+// TestClient_CreateIdentification checks that an identification is posted
+// against the observation's UUID with the taxon and body given.
+//
+// Verifies: P-090, T-044.
+func TestClient_CreateIdentification(t *testing.T) {
+	ident := Identification{ObservationID: uuid.New(), TaxonID: 8021, Body: "Identified on eBird"}
+	var body CreateIdentification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/identifications" {
+			t.Errorf("Expected POST /identifications, got %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Decoding request body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, "test-token", "test-user-agent")
+
+	if err := client.CreateIdentification(ident); err != nil {
+		t.Errorf("CreateIdentification() error = %v", err)
+	}
+	if body.Identification != ident {
+		t.Errorf("identification = %+v, want %+v", body.Identification, ident)
+	}
+}
+
 // TestStatusErrorIncludesBody checks that a refusal carries iNaturalist's
 // explanation. Without it every failure read "bad HTTP status: 422
 // Unprocessable Entity", whether the file was too large, the format
@@ -275,3 +336,45 @@ func TestDefaultMinRequestIntervalMatchesGuidance(t *testing.T) {
 		t.Error("NewClient does not pace by default; a caller that forgets to set it would run unthrottled")
 	}
 }
+
+// TestContextCancelsRequest checks that a caller's deadline applies to a
+// request in flight, and to the wait for a pacing slot before it is sent.
+//
+// Verifies: T-039.
+func TestContextCancelsRequest(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method == "DELETE" {
+			<-r.Context().Done() // never answers
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, "", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.DeleteObservationContext(ctx, uuid.New()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DeleteObservationContext() error = %v, want the context's deadline", err)
+	}
+
+	// After the first request the next slot is an hour away, so only the
+	// context can end the wait.
+	client = NewClient(server.URL, "", "")
+	client.SetMinRequestInterval(time.Hour)
+	if err := client.CreateObservation(Observation{}); err != nil {
+		t.Fatalf("CreateObservation() error = %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := client.CreateObservationContext(ctx, Observation{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CreateObservationContext() error = %v, want the context's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("canceled wait for a pacing slot took %v", elapsed)
+	}
+	if requests := requests.Load(); requests != 2 {
+		t.Errorf("server saw %d requests, want 2: the canceled one must not be sent", requests)
+	}
+}
@@ -2,11 +2,14 @@
 package inat
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"iter"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -18,107 +21,332 @@ import (
 // The dates d1 and d2 specify the start and end of the observation date range if nonzero.
 // The fields list specifies which fields are populated in the results.
 func (c *Client) DownloadObservations(inatUserID string, d1, d2 time.Time, fields ...string) ([]Result, error) {
-	const dateFormat = "2006-01-02"
-	var d1str, d2str string
-	if !d1.IsZero() {
-		d1str = " after " + d1.Format(dateFormat)
-	}
-	if !d2.IsZero() {
-		d2str = " before " + d2.Format(dateFormat)
-	}
-	log.Printf("Downloading observations for %s%s%s", inatUserID, d1str, d2str)
-
-	// From https://www.inaturalist.org/pages/api+recommended+practices:
-	// If using the API to fetch a lot of results, please use the highest supported per_page value.
-	// For example you can get up to 200 observations in a single request,
-	// which would be faster and more efficient than fetching the default 30 results at a time.
-	const perPage = 200
-
-	// Page with id_above rather than page numbers. From the same page:
-	//
-	//	The page and per_page parameters can be used to fetch up to (for many
-	//	endpoints) 10k results. An error will be thrown if results beyond 10k
-	//	are requested.
-	//
-	// A birder with more than 10,000 iNaturalist observations could not sync at
-	// all (issue #5). Sorting by id ascending and resuming from the last id
-	// seen has no such ceiling. Note that total_results shrinks as the cursor
-	// advances, so it can't decide when to stop; a short page can.
+	return c.DownloadObservationsContext(context.Background(), inatUserID, d1, d2, fields...)
+}
+
+// DownloadObservationsContext is DownloadObservations with a context, which
+// bounds the whole download rather than each page of it.
+func (c *Client) DownloadObservationsContext(ctx context.Context, inatUserID string, d1, d2 time.Time, fields ...string) ([]Result, error) {
 	var results []Result
-	var totalResults int
-	var idAbove int
-	for {
-		u, err := url.Parse(c.baseURL + "/observations")
+	for r, err := range c.StreamObservations(ctx, inatUserID, d1, d2, fields...) {
 		if err != nil {
-			return nil, fmt.Errorf("DownloadObservations: %w", err)
+			return nil, err
 		}
-		q := u.Query()
-		q.Set("user_id", inatUserID)
-		q.Set("per_page", strconv.Itoa(perPage))
-		q.Set("order_by", "id")
-		q.Set("order", "asc")
-		if idAbove > 0 {
-			q.Set("id_above", strconv.Itoa(idAbove))
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// StreamObservations yields the observations DownloadObservations returns,
+// one page at a time, so that a caller holding only what it needs of each
+// one never holds the whole account. It stops after yielding an error, with
+// a zero Result. Ending the loop early stops the download. ctx bounds the
+// whole download.
+func (c *Client) StreamObservations(ctx context.Context, inatUserID string, d1, d2 time.Time, fields ...string) iter.Seq2[Result, error] {
+	return c.QueryObservations(ctx, ObservationQuery{UserID: inatUserID, D1: d1, D2: d2}, fields...)
+}
+
+// An ObservationQuery selects some of a user's observations. A zero field
+// doesn't narrow the selection.
+type ObservationQuery struct {
+	UserID string
+	// D1 and D2 bound the date observed, inclusively.
+	D1, D2 time.Time
+	// UpdatedSince selects the observations created or changed since then.
+	// It doesn't see deletions.
+	UpdatedSince time.Time
+	// IDAbove and IDBelow bound the observation id, exclusively.
+	IDAbove, IDBelow int
+}
+
+func (q ObservationQuery) String() string {
+	const dateFormat = "2006-01-02"
+	s := q.UserID
+	if !q.D1.IsZero() {
+		s += " after " + q.D1.Format(dateFormat)
+	}
+	if !q.D2.IsZero() {
+		s += " before " + q.D2.Format(dateFormat)
+	}
+	if !q.UpdatedSince.IsZero() {
+		s += " updated since " + q.UpdatedSince.Format(time.DateTime)
+	}
+	if q.IDAbove > 0 || q.IDBelow > 0 {
+		s += fmt.Sprintf(" with ids in (%d, %d)", q.IDAbove, q.IDBelow)
+	}
+	return s
+}
+
+// values returns the query parameters that select q.
+func (q ObservationQuery) values() url.Values {
+	const dateFormat = "2006-01-02"
+	v := url.Values{}
+	v.Set("user_id", q.UserID)
+	// Deliberately unfiltered by taxon. Restricting to iconic_taxa[]=Aves
+	// downloaded fewer observations, but hid any observation without an
+	// iconic taxon — the state iNaturalist leaves an observation in when it
+	// can't resolve the eBird name birdsync gave it. Those became invisible
+	// to duplicate detection and were re-created on every run. The saving
+	// was two HTTP requests on a 1478-observation account; see CR-003 in
+	// spec/decisions.md.
+	if !q.D1.IsZero() {
+		v.Set("d1", q.D1.Format(dateFormat))
+	}
+	if !q.D2.IsZero() {
+		v.Set("d2", q.D2.Format(dateFormat))
+	}
+	if !q.UpdatedSince.IsZero() {
+		v.Set("updated_since", q.UpdatedSince.UTC().Format(time.RFC3339))
+	}
+	if q.IDAbove > 0 {
+		v.Set("id_above", strconv.Itoa(q.IDAbove))
+	}
+	if q.IDBelow > 0 {
+		v.Set("id_below", strconv.Itoa(q.IDBelow))
+	}
+	return v
+}
+
+// QueryObservations is StreamObservations for the observations q selects.
+func (c *Client) QueryObservations(ctx context.Context, q ObservationQuery, fields ...string) iter.Seq2[Result, error] {
+	return func(yield func(Result, error) bool) {
+		log.Printf("Downloading observations for %s", q)
+
+		// From https://www.inaturalist.org/pages/api+recommended+practices:
+		// If using the API to fetch a lot of results, please use the highest supported per_page value.
+		// For example you can get up to 200 observations in a single request,
+		// which would be faster and more efficient than fetching the default 30 results at a time.
+		const perPage = 200
+
+		// Page with id_above rather than page numbers. From the same page:
+		//
+		//	The page and per_page parameters can be used to fetch up to (for many
+		//	endpoints) 10k results. An error will be thrown if results beyond 10k
+		//	are requested.
+		//
+		// A birder with more than 10,000 iNaturalist observations could not sync at
+		// all (issue #5). Sorting by id ascending and resuming from the last id
+		// seen has no such ceiling. Note that total_results shrinks as the cursor
+		// advances, so it can't decide when to stop; a short page can.
+		var downloaded, totalResults int
+		idAbove := q.IDAbove
+		for {
+			u, err := url.Parse(c.baseURL + "/observations")
+			if err != nil {
+				yield(Result{}, fmt.Errorf("QueryObservations: %w", err))
+				return
+			}
+			page := q
+			page.IDAbove = idAbove
+			v := page.values()
+			v.Set("per_page", strconv.Itoa(perPage))
+			v.Set("order_by", "id")
+			v.Set("order", "asc")
+			// "id" is the paging cursor, and unlike uuid the v2 API returns it only
+			// when asked. The client adds it rather than trusting every caller to
+			// know that paging depends on it.
+			v.Set("fields", strings.Join(append([]string{"id"}, fields...), ","))
+			u.RawQuery = v.Encode()
+
+			req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+			if err != nil {
+				yield(Result{}, fmt.Errorf("QueryObservations: %w", err))
+				return
+			}
+			body, err := c.send(req, nil)
+			if err != nil {
+				yield(Result{}, fmt.Errorf("QueryObservations: after id %d: %w", idAbove, err))
+				return
+			}
+
+			var observations Observations
+			err = json.Unmarshal([]byte(body), &observations)
+			if err != nil {
+				yield(Result{}, fmt.Errorf("QueryObservations: decoding results after id %d: %w", idAbove, err))
+				return
+			}
+
+			if len(observations.Results) == 0 {
+				return
+			}
+			if totalResults == 0 { // only the first response sees the whole set
+				totalResults = observations.TotalResults
+			}
+			// Insist the cursor moves. If id_above were ignored — stripped by a
+			// proxy, unsupported by a future API version — or if "id" came back
+			// zero because the field wasn't returned, the loop would otherwise
+			// fetch the same page forever, hammering the service and breaching the
+			// rate limit it asks clients to respect. This is checked before the
+			// page is yielded, so a caller never sees a page twice.
+			last := observations.Results[len(observations.Results)-1].ID
+			if last <= idAbove {
+				yield(Result{}, fmt.Errorf(
+					"QueryObservations: cursor did not advance past id %d; the API may be ignoring id_above or omitting id",
+					idAbove))
+				return
+			}
+			idAbove = last
+			for _, r := range observations.Results {
+				if !yield(r, nil) {
+					return
+				}
+			}
+			downloaded += len(observations.Results)
+			log.Printf("Downloaded %d of %d observations", downloaded, totalResults)
+			if len(observations.Results) < perPage {
+				return
+			}
 		}
-		// Deliberately unfiltered by taxon. Restricting to iconic_taxa[]=Aves
-		// downloaded fewer observations, but hid any observation without an
-		// iconic taxon — the state iNaturalist leaves an observation in when it
-		// can't resolve the eBird name birdsync gave it. Those became invisible
-		// to duplicate detection and were re-created on every run. The saving
-		// was two HTTP requests on a 1478-observation account; see CR-003 in
-		// spec/decisions.md.
-		if !d1.IsZero() {
-			q.Set("d1", d1.Format(dateFormat))
+	}
+}
+
+// CountObservations returns how many observations q selects, at the cost of
+// one request however many there are.
+func (c *Client) CountObservations(ctx context.Context, q ObservationQuery) (int, error) {
+	u, err := url.Parse(c.baseURL + "/observations")
+	if err != nil {
+		return 0, fmt.Errorf("CountObservations(%s): %w", q, err)
+	}
+	v := q.values()
+	// One result, the smallest page, for its total_results.
+	v.Set("per_page", "1")
+	v.Set("fields", "id")
+	u.RawQuery = v.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("CountObservations(%s): %w", q, err)
+	}
+	body, err := c.send(req, nil)
+	if err != nil {
+		return 0, fmt.Errorf("CountObservations(%s): %w", q, err)
+	}
+	var observations Observations
+	if err := json.Unmarshal([]byte(body), &observations); err != nil {
+		return 0, fmt.Errorf("CountObservations(%s): decoding results: %w", q, err)
+	}
+	return observations.TotalResults, nil
+}
+
+// maxUUIDsPerRequest bounds how many UUIDs GetObservations puts in one URL.
+// Each is 37 characters with its comma, so this keeps the URL well inside the
+// 8 KB many servers and proxies accept.
+const maxUUIDsPerRequest = 100
+
+// GetObservations returns the observations with the given UUIDs. An
+// observation that no longer exists is simply absent from the results.
+// The fields list specifies which fields are populated in the results.
+func (c *Client) GetObservations(uuids []uuid.UUID, fields ...string) ([]Result, error) {
+	return c.GetObservationsContext(context.Background(), uuids, fields...)
+}
+
+// GetObservationsContext is GetObservations with a context.
+func (c *Client) GetObservationsContext(ctx context.Context, uuids []uuid.UUID, fields ...string) ([]Result, error) {
+	var results []Result
+	for batch := range slices.Chunk(uuids, maxUUIDsPerRequest) {
+		ids := make([]string, len(batch))
+		for i, u := range batch {
+			ids[i] = u.String()
 		}
-		if !d2.IsZero() {
-			q.Set("d2", d2.Format(dateFormat))
+		u, err := url.Parse(c.baseURL + "/observations/" + strings.Join(ids, ","))
+		if err != nil {
+			return nil, fmt.Errorf("GetObservations: %w", err)
 		}
-		// "id" is the paging cursor, and unlike uuid the v2 API returns it only
-		// when asked. The client adds it rather than trusting every caller to
-		// know that paging depends on it.
-		q.Set("fields", strings.Join(append([]string{"id"}, fields...), ","))
+		q := u.Query()
+		// Ask for the whole batch on one page; the default page is smaller.
+		q.Set("per_page", strconv.Itoa(len(batch)))
+		q.Set("fields", strings.Join(append([]string{"id", "uuid"}, fields...), ","))
 		u.RawQuery = q.Encode()
-
-		req, err := http.NewRequest("GET", u.String(), nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 		if err != nil {
-			return nil, fmt.Errorf("DownloadObservations: %w", err)
+			return nil, fmt.Errorf("GetObservations: %w", err)
 		}
-		body, err := c.roundTrip(req)
+		body, err := c.send(req, nil)
 		if err != nil {
-			return nil, fmt.Errorf("DownloadObservations: after id %d: %w", idAbove, err)
+			return nil, fmt.Errorf("GetObservations: %w", err)
 		}
-
 		var observations Observations
-		err = json.Unmarshal([]byte(body), &observations)
-		if err != nil {
-			return nil, fmt.Errorf("DownloadObservations: decoding results after id %d: %w", idAbove, err)
+		if err := json.Unmarshal([]byte(body), &observations); err != nil {
+			return nil, fmt.Errorf("GetObservations: decoding results: %w", err)
 		}
+		results = append(results, observations.Results...)
+	}
+	return results, nil
+}
+
+// maxFieldIDsPerRequest bounds how many field IDs GetObservationFields puts in
+// one URL, and so how many results it asks for on one page. A mapping names a
+// dozen or so, so one request is the rule.
+const maxFieldIDsPerRequest = 100
 
-		if len(observations.Results) == 0 {
-			break
+// GetObservationFields returns the definitions of the observation fields with
+// the given IDs. A field that doesn't exist is absent from the results.
+func (c *Client) GetObservationFields(ids []int) ([]ObservationField, error) {
+	return c.GetObservationFieldsContext(context.Background(), ids)
+}
+
+// GetObservationFieldsContext is GetObservationFields with a context.
+func (c *Client) GetObservationFieldsContext(ctx context.Context, ids []int) ([]ObservationField, error) {
+	var fields []ObservationField
+	for batch := range slices.Chunk(ids, maxFieldIDsPerRequest) {
+		s := make([]string, len(batch))
+		for i, id := range batch {
+			s[i] = strconv.Itoa(id)
 		}
-		if totalResults == 0 { // only the first response sees the whole set
-			totalResults = observations.TotalResults
+		u, err := url.Parse(c.baseURL + "/observation_fields/" + strings.Join(s, ","))
+		if err != nil {
+			return nil, fmt.Errorf("GetObservationFields: %w", err)
 		}
-		results = append(results, observations.Results...)
-		// Insist the cursor moves. If id_above were ignored — stripped by a
-		// proxy, unsupported by a future API version — or if "id" came back
-		// zero because the field wasn't returned, the loop would otherwise
-		// fetch the same page forever, hammering the service and breaching the
-		// rate limit it asks clients to respect.
-		last := observations.Results[len(observations.Results)-1].ID
-		if last <= idAbove {
-			return nil, fmt.Errorf(
-				"DownloadObservations: cursor did not advance past id %d; the API may be ignoring id_above or omitting id",
-				idAbove)
+		q := u.Query()
+		q.Set("per_page", strconv.Itoa(len(batch)))
+		q.Set("fields", "id,name,datatype,allowed_values")
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("GetObservationFields: %w", err)
+		}
+		body, err := c.send(req, nil)
+		if err != nil {
+			return nil, fmt.Errorf("GetObservationFields: %w", err)
 		}
-		idAbove = last
-		log.Printf("Downloaded %d of %d observations", len(results), totalResults)
-		if len(observations.Results) < perPage {
-			break
+		var results ObservationFields
+		if err := json.Unmarshal([]byte(body), &results); err != nil {
+			return nil, fmt.Errorf("GetObservationFields: decoding results: %w", err)
 		}
+		fields = append(fields, results.Results...)
 	}
-	return results, nil
+	return fields, nil
+}
+
+// SearchTaxa returns the taxa with a name, scientific or common, that matches
+// q, best match first.
+func (c *Client) SearchTaxa(q string) ([]Taxon, error) {
+	return c.SearchTaxaContext(context.Background(), q)
+}
+
+// SearchTaxaContext is SearchTaxa with a context.
+func (c *Client) SearchTaxaContext(ctx context.Context, q string) ([]Taxon, error) {
+	u, err := url.Parse(c.baseURL + "/taxa")
+	if err != nil {
+		return nil, fmt.Errorf("SearchTaxa: %w", err)
+	}
+	query := u.Query()
+	query.Set("q", q)
+	query.Set("per_page", "30")
+	query.Set("fields", "id,name,rank,is_active,matched_term,preferred_common_name,ancestor_ids")
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("SearchTaxa: %w", err)
+	}
+	body, err := c.send(req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("SearchTaxa: %w", err)
+	}
+	var taxa Taxa
+	if err := json.Unmarshal([]byte(body), &taxa); err != nil {
+		return nil, fmt.Errorf("SearchTaxa: decoding results: %w", err)
+	}
+	return taxa.Results, nil
 }
 
 func TestObservation() Observation {
@@ -1,15 +1,21 @@
 package inat
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // TestDownloadObservations checks the basics of a download: the request
@@ -285,3 +291,193 @@ func TestDownloadObservationsCursorMustAdvance(t *testing.T) {
 		t.Errorf("Made %d requests before giving up, want 2", requests)
 	}
 }
+
+// TestGetObservationsBatches checks that observations are fetched by UUID, at
+// most maxUUIDsPerRequest to a request, each batch on a single page.
+//
+// Verifies: P-072.
+func TestGetObservationsBatches(t *testing.T) {
+	var batches []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids := strings.Split(strings.TrimPrefix(r.URL.Path, "/observations/"), ",")
+		batches = append(batches, len(ids))
+		if got, want := r.URL.Query().Get("per_page"), strconv.Itoa(len(ids)); got != want {
+			t.Errorf("per_page = %q, want %q", got, want)
+		}
+		if got := r.URL.Query().Get("fields"); got != "id,uuid,ofvs.all" {
+			t.Errorf("fields = %q, want %q", got, "id,uuid,ofvs.all")
+		}
+		var results []Result
+		for _, id := range ids {
+			results = append(results, Result{UUID: uuid.MustParse(id)})
+		}
+		json.NewEncoder(w).Encode(Observations{TotalResults: len(results), Results: results})
+	}))
+	defer server.Close()
+
+	uuids := make([]uuid.UUID, maxUUIDsPerRequest+1)
+	for i := range uuids {
+		uuids[i] = uuid.New()
+	}
+	client := newTestClient(server.URL, "", "")
+	results, err := client.GetObservations(uuids, "ofvs.all")
+	if err != nil {
+		t.Fatalf("GetObservations() error = %v", err)
+	}
+	if len(results) != len(uuids) || results[maxUUIDsPerRequest].UUID != uuids[maxUUIDsPerRequest] {
+		t.Errorf("got %d results, want one for each of %d UUIDs", len(results), len(uuids))
+	}
+	if !slices.Equal(batches, []int{maxUUIDsPerRequest, 1}) {
+		t.Errorf("requests asked for %v observations, want %v", batches, []int{maxUUIDsPerRequest, 1})
+	}
+}
+
+// TestGetObservationFields checks that field definitions are fetched by ID,
+// and that a field that doesn't exist is left out rather than an error.
+//
+// Verifies: P-085.
+func TestGetObservationFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Path, "/observation_fields/396,99999999"; got != want {
+			t.Errorf("path = %q, want %q", got, want)
+		}
+		if got, want := r.URL.Query().Get("fields"), "id,name,datatype,allowed_values"; got != want {
+			t.Errorf("fields = %q, want %q", got, want)
+		}
+		json.NewEncoder(w).Encode(ObservationFields{TotalResults: 1, Results: []ObservationField{
+			{ID: 396, Name: "Distance", Datatype: "numeric"},
+		}})
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, "", "")
+	fields, err := client.GetObservationFields([]int{396, 99999999})
+	if err != nil {
+		t.Fatalf("GetObservationFields() error = %v", err)
+	}
+	want := []ObservationField{{ID: 396, Name: "Distance", Datatype: "numeric"}}
+	if !slices.Equal(fields, want) {
+		t.Errorf("GetObservationFields() = %+v, want %+v", fields, want)
+	}
+}
+
+// TestSearchTaxa checks the query a taxa search sends and that its results
+// are read.
+//
+// Verifies: P-086.
+func TestSearchTaxa(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/taxa" {
+			t.Errorf("path = %q, want /taxa", r.URL.Path)
+		}
+		if got, want := r.URL.Query().Get("q"), "Corvus brachyrhynchos"; got != want {
+			t.Errorf("q = %q, want %q", got, want)
+		}
+		for _, field := range []string{"matched_term", "ancestor_ids"} {
+			if got := r.URL.Query().Get("fields"); !strings.Contains(got, field) {
+				t.Errorf("fields = %q, want %s among them", got, field)
+			}
+		}
+		json.NewEncoder(w).Encode(Taxa{TotalResults: 1, Results: []Taxon{
+			{ID: 8021, Name: "Corvus brachyrhynchos", Rank: "species", IsActive: true, MatchedTerm: "Corvus brachyrhynchos", AncestorIDs: []int{3, 7823, 8021}},
+		}})
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, "", "")
+	taxa, err := client.SearchTaxa("Corvus brachyrhynchos")
+	if err != nil {
+		t.Fatalf("SearchTaxa() error = %v", err)
+	}
+	if len(taxa) != 1 || taxa[0].ID != 8021 || !taxa[0].IsActive || len(taxa[0].AncestorIDs) != 3 {
+		t.Errorf("SearchTaxa() = %+v, want the one active taxon 8021 with its ancestors", taxa)
+	}
+}
+
+// TestStreamObservationsYieldsPageByPage checks that the stream hands over
+// each page before fetching the next, and that a caller that stops early
+// fetches no more.
+//
+// Verifies: T-022.
+func TestStreamObservationsYieldsPageByPage(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		page := make([]Result, 200) // always full, so only the caller can stop it
+		for i := range page {
+			page[i] = Result{ID: n*1000 + i + 1}
+		}
+		json.NewEncoder(w).Encode(Observations{TotalResults: 100000, Results: page})
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, "", "")
+	var seen int
+	for r, err := range client.StreamObservations(context.Background(), "testuser", time.Time{}, time.Time{}) {
+		if err != nil {
+			t.Fatalf("StreamObservations() error = %v", err)
+		}
+		seen++
+		if want := int32((seen-1)/200 + 1); requests.Load() != want {
+			t.Fatalf("result %d (id %d) arrived after %d requests, want %d", seen, r.ID, requests.Load(), want)
+		}
+		if seen == 250 {
+			break
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("server saw %d requests for 250 results, want 2", got)
+	}
+}
+
+// TestQueryObservationsSendsQuery checks that an ObservationQuery's bounds
+// reach iNaturalist as the v2 API's parameters, paging included, and that
+// CountObservations reads total_results from a one-result page.
+//
+// Verifies: P-076, T-041.
+func TestQueryObservationsSendsQuery(t *testing.T) {
+	var mu sync.Mutex
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query())
+		mu.Unlock()
+		json.NewEncoder(w).Encode(Observations{TotalResults: 42, Results: []Result{{ID: 150}}})
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, "", "")
+	since := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	q := ObservationQuery{UserID: "testuser", UpdatedSince: since, IDAbove: 100, IDBelow: 200}
+	for _, err := range client.QueryObservations(context.Background(), q, "description") {
+		if err != nil {
+			t.Fatalf("QueryObservations() error = %v", err)
+		}
+	}
+	n, err := client.CountObservations(context.Background(), q)
+	if err != nil || n != 42 {
+		t.Errorf("CountObservations() = %d, %v; want 42", n, err)
+	}
+
+	if len(queries) != 2 {
+		t.Fatalf("server saw %d requests, want 2", len(queries))
+	}
+	for i, v := range queries {
+		for param, want := range map[string]string{
+			"user_id":       "testuser",
+			"updated_since": "2025-03-01T12:00:00Z",
+			"id_above":      "100",
+			"id_below":      "200",
+		} {
+			if got := v.Get(param); got != want {
+				t.Errorf("request %d: %s = %q, want %q", i+1, param, got, want)
+			}
+		}
+	}
+	if got := queries[0].Get("fields"); got != "id,description" {
+		t.Errorf("query fields = %q, want id,description", got)
+	}
+	if got := queries[1].Get("per_page"); got != "1" {
+		t.Errorf("count per_page = %q, want 1", got)
+	}
+}
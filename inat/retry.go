@@ -0,0 +1,176 @@
+package inat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is how many times a request is sent before its
+	// failure is returned: the first attempt and three retries.
+	DefaultMaxAttempts = 4
+	// DefaultRetryBackoff is the wait before the first retry. Each retry
+	// after it waits twice as long as the one before, jittered.
+	DefaultRetryBackoff = 2 * time.Second
+	// MaxRetryAfter is the longest Retry-After the client waits out. A
+	// service asking for longer isn't going to recover within a run, and
+	// waiting silently for an hour looks like a hang.
+	MaxRetryAfter = 2 * time.Minute
+)
+
+// SetRetryPolicy overrides how many times a request is attempted and the
+// backoff before the first retry. One attempt turns retrying off. Tests set
+// the backoff to zero.
+func (c *Client) SetRetryPolicy(maxAttempts int, backoff time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxAttempts = max(maxAttempts, 1)
+	c.retryBackoff = backoff
+}
+
+// A landedFunc reports whether an earlier attempt at a request took effect
+// although the client saw it fail: the response was lost on the way back, or
+// the service did the work and then answered with an error.
+//
+// It is what makes a POST safe to retry. Sending a create again after it
+// landed would make a second observation, and sending an upload again would
+// attach a second copy of the photo, so before resending one the client asks
+// iNaturalist whether the first got through (T-040).
+type landedFunc func(ctx context.Context) (bool, error)
+
+// transportError marks a failure to get a response at all, which, like a 5xx,
+// may well not happen on the next attempt.
+type transportError struct{ err error }
+
+func (e transportError) Error() string { return e.err.Error() }
+func (e transportError) Unwrap() error { return e.err }
+
+// send is roundTrip with retries. A failure is retried only if it is
+// transient (StatusError.Permanent, or no response at all) and only if the
+// request is safe to send again: a GET or a PUT, which can be repeated without
+// changing the outcome, or a request with a landed check.
+func (c *Client) send(req *http.Request, landed landedFunc) (string, error) {
+	ctx := req.Context()
+	resendable := landed != nil || req.Method == http.MethodGet || req.Method == http.MethodPut
+	for attempt := 1; ; attempt++ {
+		body, err := c.roundTrip(req)
+		if err == nil {
+			return body, nil
+		}
+		wait, ok := c.retryWait(err, attempt)
+		if !resendable || !ok {
+			return "", err
+		}
+		log.Printf("%s %s: %v; retrying in %v", req.Method, req.URL.Path, err, wait.Round(time.Millisecond))
+		if waitErr := sleep(ctx, wait); waitErr != nil {
+			return "", fmt.Errorf("%w (gave up waiting to retry: %v)", err, waitErr)
+		}
+		if landed != nil {
+			ok, checkErr := landed(ctx)
+			if checkErr != nil {
+				return "", fmt.Errorf("%w (not retried: couldn't check whether it took effect: %v)", err, checkErr)
+			}
+			if ok {
+				log.Printf("%s %s took effect despite the error; not sending it again", req.Method, req.URL.Path)
+				return "", nil
+			}
+		}
+		if req, err = rewind(req); err != nil {
+			return "", err
+		}
+	}
+}
+
+// retryWait reports whether the error from the given attempt is worth another,
+// and if so how long to wait first.
+func (c *Client) retryWait(err error, attempt int) (time.Duration, bool) {
+	c.mu.Lock()
+	maxAttempts, backoff := c.maxAttempts, c.retryBackoff
+	c.mu.Unlock()
+	if attempt >= maxAttempts {
+		return 0, false
+	}
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		if statusErr.Permanent() {
+			return 0, false
+		}
+		if statusErr.RetryAfter > MaxRetryAfter {
+			return 0, false
+		}
+		if statusErr.RetryAfter > 0 {
+			return statusErr.RetryAfter, true
+		}
+	case errors.As(err, new(transportError)):
+		// A canceled caller wants no more attempts.
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, false
+		}
+	default:
+		// A 401, or a request that couldn't be paced or built. Neither
+		// comes good by asking again.
+		return 0, false
+	}
+	// Jitter the wait across its upper half, so that clients that failed
+	// together don't all retry together.
+	d := backoff << (attempt - 1)
+	if d <= 0 {
+		return 0, true
+	}
+	return d/2 + rand.N(d/2+1), true
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or an HTTP date. It returns zero if there is none it can read.
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// rewind returns a copy of req, with its body from the start, for sending
+// again.
+func rewind(req *http.Request) (*http.Request, error) {
+	again := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return again, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("can't resend %s %s: its body can't be read again", req.Method, req.URL.Path)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("can't resend %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	again.Body = body
+	return again, nil
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,214 @@
+package inat
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A flakyServer fails the first n requests by method with a status, and
+// answers GET /observations/{uuid} with whatever observations it holds.
+type flakyServer struct {
+	mu           sync.Mutex
+	fail         map[string]int // method -> failures left
+	status       int
+	retryAfter   string
+	requests     map[string]int // method -> requests seen
+	bodies       []string       // bodies of the POSTs seen
+	observations []Result
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.Method]++
+	if r.Method == http.MethodPost {
+		b, _ := io.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(b))
+	}
+	if s.fail[r.Method] > 0 {
+		s.fail[r.Method]--
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(s.status)
+		return
+	}
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(Observations{TotalResults: len(s.observations), Results: s.observations})
+	}
+}
+
+func newFlakyServer(t *testing.T, status int, fail map[string]int) (*flakyServer, *Client) {
+	s := &flakyServer{fail: fail, status: status, requests: map[string]int{}}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, newTestClient(server.URL, "test-token", "test-user-agent")
+}
+
+// TestRetriesTransientFailures checks that a request that fails with a 5xx is
+// sent again, and that the caller sees only the final outcome.
+//
+// Verifies: T-040.
+func TestRetriesTransientFailures(t *testing.T) {
+	s, client := newFlakyServer(t, http.StatusBadGateway, map[string]int{http.MethodPut: 2})
+	if err := client.UpdateObservation(Observation{UUID: uuid.New()}); err != nil {
+		t.Errorf("UpdateObservation() after two 502s error = %v", err)
+	}
+	if got := s.requests[http.MethodPut]; got != 3 {
+		t.Errorf("server saw %d PUTs, want 3", got)
+	}
+}
+
+// TestRetryGivesUp checks that a service that keeps failing gets
+// DefaultMaxAttempts requests and no more, and that the error it returned
+// reaches the caller still classifiable.
+//
+// Verifies: T-040.
+func TestRetryGivesUp(t *testing.T) {
+	s, client := newFlakyServer(t, http.StatusServiceUnavailable, map[string]int{http.MethodPut: 100})
+	err := client.UpdateObservation(Observation{UUID: uuid.New()})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("UpdateObservation() error = %v, want the 503", err)
+	}
+	if got := s.requests[http.MethodPut]; got != DefaultMaxAttempts {
+		t.Errorf("server saw %d PUTs, want %d", got, DefaultMaxAttempts)
+	}
+}
+
+// TestNoRetryOnPermanentFailure checks that a request the service refused on
+// its merits isn't sent again: it would be refused again.
+//
+// Verifies: T-040, P-063.
+func TestNoRetryOnPermanentFailure(t *testing.T) {
+	s, client := newFlakyServer(t, http.StatusUnprocessableEntity, map[string]int{http.MethodPut: 100})
+	if err := client.UpdateObservation(Observation{UUID: uuid.New()}); err == nil {
+		t.Error("UpdateObservation() against a refusing server returned no error")
+	}
+	if got := s.requests[http.MethodPut]; got != 1 {
+		t.Errorf("server saw %d PUTs, want 1", got)
+	}
+}
+
+// TestRetryHonorsRetryAfter checks that a 429 is retried after the wait the
+// service asked for, not the client's own backoff, and that a wait longer than
+// MaxRetryAfter is returned to the caller rather than waited out.
+//
+// Verifies: T-040.
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	s, client := newFlakyServer(t, http.StatusTooManyRequests, map[string]int{http.MethodPut: 1})
+	s.retryAfter = "1"
+	start := time.Now()
+	if err := client.UpdateObservation(Observation{UUID: uuid.New()}); err != nil {
+		t.Errorf("UpdateObservation() after a 429 error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the second Retry-After asked for", elapsed)
+	}
+
+	s, client = newFlakyServer(t, http.StatusTooManyRequests, map[string]int{http.MethodPut: 1})
+	s.retryAfter = "3600"
+	err := client.UpdateObservation(Observation{UUID: uuid.New()})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != time.Hour {
+		t.Errorf("UpdateObservation() error = %v, want the 429 with its hour-long Retry-After", err)
+	}
+	if got := s.requests[http.MethodPut]; got != 1 {
+		t.Errorf("server saw %d PUTs, want 1", got)
+	}
+}
+
+// TestRetryCreateChecksItLanded checks that a create that failed is sent again
+// only if the observation isn't there: a 502 can come back from a create that
+// worked, and sending that one again would make a duplicate.
+//
+// Verifies: T-040.
+func TestRetryCreateChecksItLanded(t *testing.T) {
+	obs := Observation{UUID: uuid.New()}
+
+	s, client := newFlakyServer(t, http.StatusBadGateway, map[string]int{http.MethodPost: 1})
+	s.observations = []Result{{UUID: obs.UUID}} // it landed
+	if err := client.CreateObservation(obs); err != nil {
+		t.Errorf("CreateObservation() that landed despite a 502 error = %v", err)
+	}
+	if got := s.requests[http.MethodPost]; got != 1 {
+		t.Errorf("server saw %d POSTs for a create that landed, want 1", got)
+	}
+
+	s, client = newFlakyServer(t, http.StatusBadGateway, map[string]int{http.MethodPost: 1})
+	if err := client.CreateObservation(obs); err != nil {
+		t.Errorf("CreateObservation() after a 502 error = %v", err)
+	}
+	if got := s.requests[http.MethodPost]; got != 2 {
+		t.Fatalf("server saw %d POSTs for a create that didn't land, want 2", got)
+	}
+	if s.bodies[0] != s.bodies[1] || !strings.Contains(s.bodies[1], obs.UUID.String()) {
+		t.Errorf("resent create = %s, want the first again:\n%s", s.bodies[1], s.bodies[0])
+	}
+}
+
+// TestRetryUploadChecksItLanded checks that an upload is sent again only if
+// the observation has no file by the name it was uploaded as.
+//
+// Verifies: T-040.
+func TestRetryUploadChecksItLanded(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "12345.jpg")
+	if err := os.WriteFile(filename, []byte("not really a JPEG"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	obsUUID := uuid.New()
+
+	s, client := newFlakyServer(t, http.StatusGatewayTimeout, map[string]int{http.MethodPost: 1})
+	s.observations = []Result{{UUID: obsUUID, Photos: []Photo{{OriginalFilename: "ML12345.jpg"}}}}
+	if err := client.UploadMedia(filename, true, "12345", obsUUID.String()); err != nil {
+		t.Errorf("UploadMedia() that landed despite a 504 error = %v", err)
+	}
+	if got := s.requests[http.MethodPost]; got != 1 {
+		t.Errorf("server saw %d POSTs for an upload that landed, want 1", got)
+	}
+
+	s, client = newFlakyServer(t, http.StatusGatewayTimeout, map[string]int{http.MethodPost: 1})
+	s.observations = []Result{{UUID: obsUUID, Photos: []Photo{{OriginalFilename: "ML99999.jpg"}}}}
+	if err := client.UploadMedia(filename, true, "12345", obsUUID.String()); err != nil {
+		t.Errorf("UploadMedia() after a 504 error = %v", err)
+	}
+	if got := s.requests[http.MethodPost]; got != 2 {
+		t.Errorf("server saw %d POSTs for an upload that didn't land, want 2", got)
+	}
+	if len(s.bodies) == 2 && s.bodies[0] != s.bodies[1] {
+		t.Error("resent upload differs from the first")
+	}
+}
+
+// Verifies: T-040.
+func TestParseRetryAfter(t *testing.T) {
+	for _, tt := range []struct {
+		h    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		if got := parseRetryAfter(tt.h); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.h, got, tt.want)
+		}
+	}
+	h := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(h); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, want about an hour", h, got)
+	}
+}
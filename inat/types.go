@@ -2,6 +2,7 @@ package inat
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/google/uuid"
 )
@@ -20,6 +21,55 @@ const (
 	EBirdScientificNameField = 20215
 )
 
+const (
+	// iNaturalist controlled terms, the attributes of an annotation, and
+	// their values. Look up IDs using:
+	// https://api.inaturalist.org/v1/controlled_terms
+	LifeStageTerm          = 1
+	LifeStageAdult         = 2
+	LifeStageEgg           = 7
+	LifeStageJuvenile      = 8
+	AliveOrDeadTerm        = 17
+	Alive                  = 18
+	Dead                   = 19
+	EvidenceOfPresenceTerm = 22
+	EvidenceOrganism       = 24
+	EvidenceEgg            = 29
+	EvidenceConstruction   = 35 // a nest
+)
+
+// An Annotation is a controlled term, such as Life Stage, and its value on an
+// observation, such as Juvenile.
+type Annotation struct {
+	ControlledAttributeID int `json:"controlled_attribute_id"`
+	ControlledValueID     int `json:"controlled_value_id"`
+}
+
+type CreateAnnotation struct {
+	Fields     any                   `json:"fields,omitempty"`
+	Annotation ObservationAnnotation `json:"annotation"`
+}
+
+type ObservationAnnotation struct {
+	ResourceType string    `json:"resource_type"`
+	ResourceID   uuid.UUID `json:"resource_id"`
+	Annotation
+}
+
+type CreateIdentification struct {
+	Fields         any            `json:"fields,omitempty"`
+	Identification Identification `json:"identification"`
+}
+
+// An Identification is one to add to an observation, as its owner when the
+// client's token is the owner's.
+type Identification struct {
+	ObservationID uuid.UUID `json:"observation_id"`
+	TaxonID       int       `json:"taxon_id"`
+	// Body is a remark shown with the identification.
+	Body string `json:"body,omitempty"`
+}
+
 type CreateObservation struct {
 	Fields      any         `json:"fields,omitempty"`
 	Observation Observation `json:"observation,omitempty"`
@@ -57,8 +107,8 @@ type Observation struct {
 	SiteID                           int                     `json:"site_id,omitempty"`
 	SpeciesGuess                     string                  `json:"species_guess,omitempty"`
 	TagList                          string                  `json:"tag_list,omitempty"`
-	TaxonID                          float64                 `json:"taxon_id,omitempty"`
-	TaxonName                        float64                 `json:"taxon_name,omitempty"`
+	TaxonID                          int                     `json:"taxon_id,omitempty"`
+	TaxonName                        string                  `json:"taxon_name,omitempty"`
 	TimeZone                         string                  `json:"time_zone,omitempty"`
 	User                             *User                   `json:"user,omitempty"`
 	UUID                             uuid.UUID               `json:"uuid,omitempty"`
@@ -82,10 +132,31 @@ type User struct {
 }
 
 type ObservationFieldValue struct {
+	// ID is the id of the value an update replaces. Without one, an update
+	// adds a value, and an observation that had one has two.
+	ID                 int `json:"id,omitempty"`
 	ObservationFieldID int `json:"observation_field_id,omitempty"`
 	Value              any `json:"value,omitempty"`
 }
 
+// An ObservationField is the definition of an observation field: what its
+// values are, and, if it restricts them, the values it allows.
+type ObservationField struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Datatype is text, numeric, date, time, datetime, taxon, or dna.
+	Datatype string `json:"datatype,omitempty"`
+	// AllowedValues separates the values the field allows with "|". It is
+	// empty if the field allows any value of its datatype.
+	AllowedValues string `json:"allowed_values,omitempty"`
+}
+
+// ObservationFields is returned by GetObservationFields' endpoint.
+type ObservationFields struct {
+	Results      []ObservationField `json:"results,omitempty"`
+	TotalResults int                `json:"total_results,omitempty"`
+}
+
 // Observations is returned by https://api.inaturalist.org/v2/observations
 type Observations struct {
 	Page         int      `json:"page,omitempty"`
@@ -95,23 +166,54 @@ type Observations struct {
 }
 
 type Result struct {
-	CreatedAt   string `json:"created_at,omitempty"`
-	Description string `json:"description,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+	CreatedAt   string       `json:"created_at,omitempty"`
+	Description string       `json:"description,omitempty"`
 	// ID is the numeric observation id. It is the cursor for paging: the API
 	// refuses to page past 10,000 results by page number, so DownloadObservations
 	// walks the set with id_above (T-036). Unlike UUID, the v2 API only returns
 	// it when "id" is named in the fields parameter.
-	ID                   int       `json:"id,omitempty"`
-	IdentificationsCount int       `json:"identifications_count,omitempty"`
-	ObservedOn           string    `json:"observed_on,omitempty"`
-	Ofvs                 []Ofv     `json:"ofvs,omitempty"`
-	Photos               []Photo   `json:"photos,omitempty"`
-	PositionalAccuracy   int       `json:"positional_accuracy,omitempty"`
-	PreferredCommonName  string    `json:"preferred_common_name,omitempty"`
-	QualityGrade         string    `json:"quality_grade,omitempty"`
-	Sounds               []Sound   `json:"sounds,omitempty"`
-	Taxon                Taxon     `json:"taxon,omitempty"`
-	UUID                 uuid.UUID `json:"uuid,omitempty"`
+	ID                   int                    `json:"id,omitempty"`
+	Identifications      []IdentificationResult `json:"identifications,omitempty"`
+	IdentificationsCount int                    `json:"identifications_count,omitempty"`
+	ObservedOn           string                 `json:"observed_on,omitempty"`
+	Ofvs                 []Ofv                  `json:"ofvs,omitempty"`
+	Photos               []Photo                `json:"photos,omitempty"`
+	PositionalAccuracy   int                    `json:"positional_accuracy,omitempty"`
+	PreferredCommonName  string                 `json:"preferred_common_name,omitempty"`
+	QualityGrade         string                 `json:"quality_grade,omitempty"`
+	Sounds               []Sound                `json:"sounds,omitempty"`
+	Taxon                Taxon                  `json:"taxon,omitempty"`
+	UpdatedAt            string                 `json:"updated_at,omitempty"`
+	UUID                 uuid.UUID              `json:"uuid,omitempty"`
+}
+
+// An IdentificationResult is an identification of an observation, as the
+// observations endpoint returns it.
+type IdentificationResult struct {
+	// Current is false for an identification its identifier has withdrawn
+	// or replaced with another.
+	Current bool `json:"current,omitempty"`
+	// OwnObservation is set for the identifications of the observation's
+	// owner.
+	OwnObservation bool   `json:"own_observation,omitempty"`
+	Body           string `json:"body,omitempty"`
+	Taxon          Taxon  `json:"taxon,omitempty"`
+}
+
+// OwnerIdentified reports whether the observation's owner has identified
+// it, even if they have since withdrawn the identification.
+func (r Result) OwnerIdentified() bool {
+	return slices.ContainsFunc(r.Identifications, func(i IdentificationResult) bool { return i.OwnObservation })
+}
+
+// HasIdentification reports whether the observation has ident, an
+// identification of its owner's: one of ident's taxon with ident's body. The
+// body tells it from the identification a taxon_id makes, which has none.
+func (r Result) HasIdentification(ident Identification) bool {
+	return slices.ContainsFunc(r.Identifications, func(i IdentificationResult) bool {
+		return i.OwnObservation && i.Taxon.ID == ident.TaxonID && i.Body == ident.Body
+	})
 }
 
 func (r Result) URL() string {
@@ -162,4 +264,21 @@ type Taxon struct {
 	ID                  int    `json:"id,omitempty"`
 	Name                string `json:"name,omitempty"`
 	PreferredCommonName string `json:"preferred_common_name,omitempty"`
+	// Rank is species, subspecies, genus, and so on.
+	Rank string `json:"rank,omitempty"`
+	// IsActive is false for a taxon iNaturalist has replaced, which
+	// observations shouldn't be given.
+	IsActive bool `json:"is_active,omitempty"`
+	// MatchedTerm is the name a search matched, which is another of the
+	// taxon's names, such as a synonym, when it isn't Name.
+	MatchedTerm string `json:"matched_term,omitempty"`
+	// AncestorIDs are the IDs of the taxa this one belongs to, from the root
+	// of the tree of life down to, and including, this one.
+	AncestorIDs []int `json:"ancestor_ids,omitempty"`
+}
+
+// Taxa is returned by https://api.inaturalist.org/v2/taxa
+type Taxa struct {
+	Results      []Taxon `json:"results,omitempty"`
+	TotalResults int     `json:"total_results,omitempty"`
 }
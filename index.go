@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"slices"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// syncIndex is what birdsync knows about the user's existing iNaturalist
+// observations when it decides what to do with each eBird record.
+type syncIndex struct {
+	// previouslySynced holds the observations carrying a complete sync key
+	// (P-019), which are the ones birdsync created.
+	previouslySynced map[ebird.ObservationID]indexedObservation
+	// fuzzyMatch holds every other observation under its date and each of its
+	// names, for --fuzzy (P-031).
+	fuzzyMatch map[fuzzyKey][]string
+	// taxa resolves the names of the records to create (P-086). It isn't
+	// part of a checkpoint's snapshot; nil resolves none.
+	taxa *taxonResolver
+}
+
+type fuzzyKey struct {
+	observedDate string // 2006-01-02
+	name         string
+}
+
+// An indexedObservation is what the index keeps of an observation birdsync
+// created: the fields a decision about its eBird record reads, and no others.
+// The index used to hold each whole inat.Result, photo records, taxon and
+// all, which put the memory for an account of 100,000 observations in the
+// gigabytes (T-022).
+//
+// The mirror (P-076) keeps one for every observation in the account, which
+// is why ID, ObservedOn and TaxonCommonName are here: the mirror refreshes by
+// ID, and fuzzy matching reads the other two.
+type indexedObservation struct {
+	ID   int                 `json:"id,omitempty"`
+	UUID uuid.UUID           `json:"uuid"`
+	Key  ebird.ObservationID `json:"key"`
+	// ObservedOn is iNaturalist's observation date, 2006-01-02.
+	ObservedOn string `json:"observed_on,omitempty"`
+	// Description is birdsync's ledger of the assets it has uploaded (P-040).
+	Description string `json:"description,omitempty"`
+	// Photos and Sounds count the media attached, which the ledger is
+	// checked against.
+	Photos int `json:"photos,omitempty"`
+	Sounds int `json:"sounds,omitempty"`
+	// TaxonName and CommonName are for log messages.
+	TaxonName  string `json:"taxon_name,omitempty"`
+	CommonName string `json:"common_name,omitempty"`
+	// TaxonCommonName is the taxon's own common name, which fuzzy matching
+	// reads alongside TaxonName.
+	TaxonCommonName string `json:"taxon_common_name,omitempty"`
+	// Annotations are checked against the record's breeding code (P-083).
+	Annotations []inat.Annotation `json:"annotations,omitempty"`
+	// Fields holds the observation field values, by field ID, which are
+	// checked for the fields birdsync fills in on older observations
+	// (P-084).
+	Fields map[int]string `json:"fields,omitempty"`
+	// OwnerIdentified is set once the owner has identified the
+	// observation; one they haven't is identified (P-090).
+	OwnerIdentified bool `json:"owner_identified,omitempty"`
+	// KeyValueID is the id of the eBird Scientific Name value, which a
+	// re-key replaces (P-089).
+	KeyValueID int `json:"key_value_id,omitempty"`
+	// Taxon is the observation's taxon, with its rank and ancestors, which
+	// taxa-report compares with its eBird name's (P-088). Only an
+	// observation with a sync key keeps it.
+	Taxon *inat.Taxon `json:"taxon,omitempty"`
+}
+
+// indexObservation returns what the index keeps of r.
+func indexObservation(r inat.Result) indexedObservation {
+	o := indexedObservation{
+		ID:   r.ID,
+		UUID: r.UUID,
+		Key: ebird.ObservationID{
+			SubmissionID:   r.ObservationFieldValue(inat.EBirdField),
+			ScientificName: r.ObservationFieldValue(inat.EBirdScientificNameField),
+		},
+		ObservedOn:      r.ObservedOn,
+		Description:     r.Description,
+		Photos:          len(r.Photos),
+		Sounds:          len(r.Sounds),
+		TaxonName:       r.Taxon.Name,
+		CommonName:      r.PreferredCommonName,
+		TaxonCommonName: r.Taxon.PreferredCommonName,
+		Annotations:     r.Annotations,
+		Fields:          indexedFieldValues(r),
+		OwnerIdentified: r.OwnerIdentified(),
+	}
+	if o.Key.Valid() {
+		for _, ofv := range r.Ofvs {
+			if ofv.FieldID == inat.EBirdScientificNameField && o.KeyValueID == 0 {
+				o.KeyValueID = ofv.ID
+			}
+		}
+		o.Taxon = &inat.Taxon{
+			ID:          r.Taxon.ID,
+			Name:        r.Taxon.Name,
+			Rank:        r.Taxon.Rank,
+			AncestorIDs: r.Taxon.AncestorIDs,
+		}
+	}
+	return o
+}
+
+// URLWithSpecies is inat.Result.URLWithSpecies.
+func (o indexedObservation) URLWithSpecies() string {
+	return fmt.Sprintf("%s [%s] (%s)", inat.ObservationURL(o.UUID), o.TaxonName, o.CommonName)
+}
+
+// indexFields are the observation fields an indexedObservation is made from.
+var indexFields = []string{"description", "observed_on", "photos.all", "sounds.all", "taxon.all", "ofvs.all", "annotations.all", "identifications.all"}
+
+// downloadIndex indexes the user's observations inside the --after/--before
+// window. With a --config_dir they come from the mirror (P-076), brought up
+// to date first; without one they are downloaded. Canceling ctx stops the
+// download, and downloadIndex returns ctx's error (P-074); so does spending
+// the request budget, and it returns the *inat.BudgetError (P-075).
+func downloadIndex(ctx context.Context, inatClient inatClient, inatUserID string) (syncIndex, error) {
+	var ix syncIndex
+	var err error
+	if configDir == "" {
+		ix, err = indexObservations(inatClient.QueryObservations(ctx, inat.ObservationQuery{
+			UserID: inatUserID,
+			D1:     after.Time(),
+			D2:     before.Time(),
+		}, indexFields...))
+	} else {
+		var m *mirror
+		if m, err = refreshMirror(ctx, inatClient, inatUserID); err == nil {
+			ix = m.index(after.Time(), before.Time())
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		return syncIndex{}, ctx.Err()
+	}
+	var budgetErr *inat.BudgetError
+	if errors.As(err, &budgetErr) {
+		return syncIndex{}, budgetErr
+	}
+	if err != nil {
+		// Nothing useful can happen without the existing observations: syncing
+		// blind would duplicate everything the user already has.
+		log.Fatalf("Downloading iNaturalist observations: %v", err)
+	}
+	return ix, nil
+}
+
+func newSyncIndex() syncIndex {
+	return syncIndex{
+		previouslySynced: map[ebird.ObservationID]indexedObservation{},
+		fuzzyMatch:       map[fuzzyKey][]string{},
+	}
+}
+
+// indexObservations indexes results as they arrive, so that what is held is
+// the index, never the download (T-022).
+func indexObservations(results iter.Seq2[inat.Result, error]) (syncIndex, error) {
+	ix := newSyncIndex()
+	for r, err := range results {
+		if err != nil {
+			return syncIndex{}, err
+		}
+		ix.add(indexObservation(r))
+	}
+	debugf("Previously synced %d observations\n", len(ix.previouslySynced))
+	return ix, nil
+}
+
+// rekey moves the observation indexed under from to to, as a re-key moves it
+// on iNaturalist (P-089).
+func (ix syncIndex) rekey(from, to ebird.ObservationID) {
+	o, ok := ix.previouslySynced[from]
+	if !ok {
+		return
+	}
+	delete(ix.previouslySynced, from)
+	o.Key = to
+	ix.previouslySynced[to] = o
+}
+
+// add indexes o.
+func (ix syncIndex) add(o indexedObservation) {
+	if o.Key.Valid() {
+		ix.previouslySynced[o.Key] = o
+		return
+	}
+	// This iNaturalist observation was not created by birdsync.
+	// Record its date and common name for fuzzy matching.
+	addFuzzy := func(name string) {
+		if name == "" {
+			return // an empty name would match every unnamed eBird record
+		}
+		key := fuzzyKey{
+			observedDate: o.ObservedOn, // iNaturalist always uses format 2006-01-02
+			name:         name,
+		}
+		ix.fuzzyMatch[key] = append(ix.fuzzyMatch[key], o.UUID.String())
+		slices.Sort(ix.fuzzyMatch[key])
+		debugf("fuzzy match: add %s to %+v", o.UUID, key)
+	}
+	addFuzzy(o.TaxonCommonName)
+	addFuzzy(o.TaxonName)
+}
+
+// indexJSON is the form a syncIndex is saved in, for a checkpoint (P-073).
+// JSON can't key a map by a struct, so the maps are saved as lists.
+type indexJSON struct {
+	Synced []indexedObservation `json:"synced"`
+	Fuzzy  []fuzzyJSON          `json:"fuzzy"`
+}
+
+type fuzzyJSON struct {
+	ObservedDate string   `json:"observed_date"`
+	Name         string   `json:"name"`
+	UUIDs        []string `json:"uuids"`
+}
+
+func (ix syncIndex) MarshalJSON() ([]byte, error) {
+	var v indexJSON
+	for _, o := range ix.previouslySynced {
+		v.Synced = append(v.Synced, o)
+	}
+	for key, uuids := range ix.fuzzyMatch {
+		v.Fuzzy = append(v.Fuzzy, fuzzyJSON{ObservedDate: key.observedDate, Name: key.name, UUIDs: uuids})
+	}
+	return json.Marshal(v)
+}
+
+func (ix *syncIndex) UnmarshalJSON(b []byte) error {
+	var v indexJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*ix = newSyncIndex()
+	for _, o := range v.Synced {
+		ix.previouslySynced[o.Key] = o
+	}
+	for _, f := range v.Fuzzy {
+		ix.fuzzyMatch[fuzzyKey{observedDate: f.ObservedDate, name: f.Name}] = f.UUIDs
+	}
+	return nil
+}
+
+// fuzzy reports whether a non-birdsync observation on observedDate
+// (2006-01-02) goes by any of names, and if so, under which key.
+func (ix syncIndex) fuzzy(observedDate string, names ...string) (fuzzyKey, bool) {
+	for _, name := range names {
+		if name == "" {
+			continue // an empty name would match every unnamed taxon
+		}
+		key := fuzzyKey{
+			name:         name,
+			observedDate: observedDate,
+		}
+		if _, ok := ix.fuzzyMatch[key]; ok {
+			return key, true
+		}
+	}
+	return fuzzyKey{}, false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// syntheticAccount yields n observations as the download would, half of them
+// birdsync's, each with the photo records and taxon of a real one, and none
+// of them held anywhere but in the loop.
+func syntheticAccount(n int) iter.Seq2[inat.Result, error] {
+	return func(yield func(inat.Result, error) bool) {
+		for i := range n {
+			r := inat.Result{
+				ID:          i + 1,
+				UUID:        uuid.New(),
+				ObservedOn:  "2024-05-01",
+				Description: "Observed by eBird checklist https://ebird.org/checklist/S1",
+				Taxon:       inat.Taxon{Name: "Corvus brachyrhynchos", PreferredCommonName: "American Crow"},
+			}
+			for j := range 20 {
+				r.Photos = append(r.Photos, inat.Photo{
+					ID:               j,
+					URL:              fmt.Sprintf("https://inaturalist-open-data.s3.amazonaws.com/photos/%d%02d/square.jpg", i, j),
+					Attribution:      "(c) A Birder, some rights reserved (CC BY-NC)",
+					OriginalFilename: fmt.Sprintf("ML%d%02d.jpg", i, j),
+				})
+			}
+			if i%2 == 0 {
+				r.Ofvs = []inat.Ofv{
+					{FieldID: inat.EBirdField, Value: fmt.Sprintf("S%d", i)},
+					{FieldID: inat.EBirdScientificNameField, Value: "Corvus brachyrhynchos"},
+				}
+			}
+			if !yield(r, nil) {
+				return
+			}
+		}
+	}
+}
+
+// TestIndexKeepsWhatDecisionsRead checks that indexing a download holds the
+// index and not the download: an account whose observations would take
+// around 100 MB as inat.Results indexes in a small fraction of that.
+//
+// Verifies: T-022.
+func TestIndexKeepsWhatDecisionsRead(t *testing.T) {
+	resetFlags()
+	const n = 20000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	ix, err := indexObservations(syntheticAccount(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	held := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	runtime.KeepAlive(ix)
+
+	if len(ix.previouslySynced) != n/2 {
+		t.Fatalf("indexed %d birdsync observations, want %d", len(ix.previouslySynced), n/2)
+	}
+	if limit := int64(20 << 20); held > limit {
+		t.Errorf("index of %d observations holds %d MB, want under %d MB", n, held>>20, limit>>20)
+	}
+}
+
+// TestIndexSurvivesSnapshot checks that an index saved for a checkpoint reads
+// back the same, which is what a resumed run decides against (P-073).
+//
+// Verifies: P-073.
+func TestIndexSurvivesSnapshot(t *testing.T) {
+	resetFlags()
+	ix, err := indexObservations(syntheticAccount(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(ix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got syncIndex
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ix) {
+		t.Errorf("index after a snapshot = %+v, want %+v", got, ix)
+	}
+	key := ebird.ObservationID{SubmissionID: "S4", ScientificName: "Corvus brachyrhynchos"}
+	if o := got.previouslySynced[key]; o.Photos != 20 || !strings.Contains(o.Description, "ebird.org") {
+		t.Errorf("%s after a snapshot = %+v, want its description and 20 photos", key, o)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/google/uuid"
+)
+
+// journalFilename is the journal's name inside --config_dir.
+const journalFilename = "journal.jsonl"
+
+// A journalOp is the kind of a journal entry.
+type journalOp string
+
+const (
+	// opStart begins a run, and records what it was run on.
+	opStart journalOp = "start"
+	// opCreate, opUpdate, and opUpload record one call each to
+	// CreateObservation, UpdateObservation, and UploadMedia.
+	opCreate journalOp = "create"
+	opUpdate journalOp = "update"
+	opUpload journalOp = "upload"
+	// opAnnotate records one call to AddAnnotation (P-083).
+	opAnnotate journalOp = "annotate"
+	// opIdentify records one call to CreateIdentification (P-090).
+	opIdentify journalOp = "identify"
+	// opDelete records one call to DeleteObservation, made by birdsync undo.
+	opDelete journalOp = "delete"
+	// opEnd records that a run finished, or with an Error that it was
+	// stopped early. A run with no end entry died.
+	opEnd journalOp = "end"
+)
+
+// A journalEntry is one line of the journal.
+type journalEntry struct {
+	Run  string    `json:"run"`
+	Time time.Time `json:"time"`
+	Op   journalOp `json:"op"`
+
+	// Set on opStart.
+	Command   string `json:"command,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	CSV       string `json:"csv,omitempty"`
+	CSVSHA256 string `json:"csv_sha256,omitempty"`
+	// Undo is the run that an undo run is undoing.
+	Undo string `json:"undo,omitempty"`
+	// Resume is the interrupted run that a sync run picks up from (P-073).
+	Resume string `json:"resume,omitempty"`
+
+	// Set on the calls. An entry with no Error succeeded.
+	Source string               `json:"source,omitempty"`
+	Line   int                  `json:"line,omitempty"`
+	Key    *ebird.ObservationID `json:"key,omitempty"`
+	UUID   string               `json:"uuid,omitempty"`
+	// FormerKey is the sync key a re-key replaced with Key (P-089), so
+	// that undo can follow the observation to its new one.
+	FormerKey *ebird.ObservationID `json:"former_key,omitempty"`
+	Asset     string               `json:"asset,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// A journal is birdsync's own record of what it has done to an account: every
+// write it made, whether the write worked, and which run made it (P-071).
+//
+// Without it the only history is what birdsync left in iNaturalist — the sync
+// key and the description — which says what exists, not which run put it there
+// or what was attempted and failed.
+//
+// The file is append-only JSON lines, so a run killed partway leaves every
+// entry before the kill intact. A nil *journal records nothing, which is what
+// a dry run, or a run with no --config_dir, gets.
+type journal struct {
+	f     *os.File
+	runID string
+}
+
+// startRun opens the journal in --config_dir and records start, the start of
+// a run. It hashes start.CSV if there is one and the caller hasn't. It returns
+// nil under --dryrun, which writes nothing to iNaturalist and so has nothing to
+// record.
+func startRun(start journalEntry) *journal {
+	if dryRun || configDir == "" {
+		return nil
+	}
+	filename := filepath.Join(configDir, journalFilename)
+	if err := os.MkdirAll(configDir, 0o700); err != nil {
+		log.Fatalf("Opening journal: %v", err)
+	}
+	// The journal names the user and their checklists, so it is private.
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Fatalf("Opening journal: %v", err)
+	}
+	if err := dropPartialLine(f); err != nil {
+		log.Fatalf("Opening journal: %v", err)
+	}
+	j := &journal{f: f, runID: uuid.New().String()}
+	if start.CSV != "" && start.CSVSHA256 == "" {
+		sum, err := fileSHA256(start.CSV)
+		if err != nil {
+			log.Printf("Couldn't hash %s for the journal: %v", start.CSV, err)
+		}
+		start.CSVSHA256 = sum
+	}
+	start.Op = opStart
+	j.record(start)
+	log.Printf("Recording run %s in %s", j.runID, filename)
+	return j
+}
+
+// dropPartialLine removes a partial last line, which is what a run killed
+// mid-write leaves. Appending after it would join this run's first entry onto
+// it and corrupt both. The partial entry was for a write whose outcome was
+// never known, so dropping it loses nothing the journal could vouch for.
+func dropPartialLine(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("dropPartialLine: %w", err)
+	}
+	// An entry is far shorter than this, so the last newline is in range.
+	tail := make([]byte, min(fi.Size(), 1<<20))
+	if _, err := f.ReadAt(tail, fi.Size()-int64(len(tail))); err != nil {
+		return fmt.Errorf("dropPartialLine: %w", err)
+	}
+	if len(tail) == 0 || tail[len(tail)-1] == '\n' {
+		return nil
+	}
+	keep := fi.Size() - int64(len(tail)) + int64(bytes.LastIndexByte(tail, '\n')+1)
+	if err := f.Truncate(keep); err != nil {
+		return fmt.Errorf("dropPartialLine: %w", err)
+	}
+	return nil
+}
+
+// record appends e to the journal. A journal that can't be written is fatal:
+// carrying on would make writes the journal doesn't know about, and the
+// journal is only worth having if it is complete.
+func (j *journal) record(e journalEntry) {
+	if j == nil {
+		return
+	}
+	e.Run = j.runID
+	e.Time = time.Now().UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Fatalf("Writing journal: %v", err)
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		log.Fatalf("Writing journal: %v", err)
+	}
+	// Sync, because the point of a journal is to survive the process dying.
+	if err := j.f.Sync(); err != nil {
+		log.Fatalf("Writing journal: %v", err)
+	}
+}
+
+// end records that the run finished, or with err why it stopped early, and
+// closes the journal.
+func (j *journal) end(err error) {
+	if j == nil {
+		return
+	}
+	j.record(journalEntry{Op: opEnd, Error: errorString(err)})
+	if err := j.f.Close(); err != nil {
+		log.Printf("Closing journal: %v", err)
+	}
+}
+
+// errorString returns err's text, or "" for success.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// readJournal returns every entry in the journal file, oldest first.
+func readJournal(filename string) ([]journalEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("readJournal(%s): %w", filename, err)
+	}
+	defer f.Close()
+	var entries []journalEntry
+	// A run killed mid-write can leave a partial last line, until the next
+	// run drops it (dropPartialLine). A bad line anywhere else is corruption,
+	// and is reported.
+	var badLine error
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20) // an error message can make a line long
+	for line := 1; scanner.Scan(); line++ {
+		if badLine != nil {
+			return nil, badLine
+		}
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			badLine = fmt.Errorf("readJournal(%s): line %d: %w", filename, line, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("readJournal(%s): %w", filename, err)
+	}
+	return entries, nil
+}
+
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("fileSHA256(%s): %w", filename, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("fileSHA256(%s): %w", filename, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// exportsSHA256 identifies a set of exports: the hash of the one export, as
+// fileSHA256 has it, or of several, a hash of their hashes in order.
+func exportsSHA256(filenames []string) (string, error) {
+	if len(filenames) == 1 {
+		return fileSHA256(filenames[0])
+	}
+	h := sha256.New()
+	for _, filename := range filenames {
+		sum, err := fileSHA256(filename)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(h, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sajmani/birdsync/ebird"
+)
+
+// TestJournalRecordsEveryWrite checks that a run's journal holds one entry per
+// write, failures included, between a start entry and an end entry.
+//
+// Verifies: P-071.
+func TestJournalRecordsEveryWrite(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	mockEbird := &mockEBirdClient{records: []ebird.Record{{
+		Line:             2,
+		SubmissionID:     "S500",
+		ScientificName:   "Corvus brachyrhynchos",
+		CommonName:       "American Crow",
+		Date:             "2023-01-03",
+		Time:             "03:00 PM",
+		MLCatalogNumbers: "11111 22222",
+	}}}
+	mockInat := &mockINatClient{failUploads: map[string]error{
+		"22222": errors.New("connection reset"),
+	}}
+
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+
+	entries, err := readJournal(filepath.Join(configDir, journalFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		op     journalOp
+		asset  string
+		failed bool
+	}{
+		{opStart, "", false},
+		{opCreate, "", false},
+		{opUpload, "11111", false},
+		{opUpload, "22222", true},
+		{opUpdate, "", false},
+		{opEnd, "", false},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("journal has %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	uuid := mockInat.created[0].UUID.String()
+	for i, w := range want {
+		e := entries[i]
+		if e.Op != w.op || e.Asset != w.asset || (e.Error != "") != w.failed {
+			t.Errorf("entry %d = %s %q error %q, want %s %q failed=%v", i, e.Op, e.Asset, e.Error, w.op, w.asset, w.failed)
+		}
+		if e.Run != entries[0].Run {
+			t.Errorf("entry %d is for run %s, want %s", i, e.Run, entries[0].Run)
+		}
+		if w.op != opStart && w.op != opEnd && e.UUID != uuid {
+			t.Errorf("entry %d is for observation %s, want %s", i, e.UUID, uuid)
+		}
+	}
+	if start := entries[0]; start.Command != "sync" || start.UserID != "myUserID" || start.CSV != "MyEBirdData.csv" {
+		t.Errorf("start entry = %+v, want command sync for myUserID on MyEBirdData.csv", start)
+	}
+}
+
+// TestDryRunWritesNoJournal checks that a dry run, which writes nothing to
+// iNaturalist, leaves nothing in the journal either.
+//
+// Verifies: P-071.
+func TestDryRunWritesNoJournal(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	dryRun = true
+	defer func() { dryRun = false }()
+	mockEbird, mockInat := planFixture()
+
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+
+	if _, err := os.Stat(filepath.Join(configDir, journalFilename)); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote a journal (stat error %v)", err)
+	}
+}
+
+// TestJournalSurvivesPartialLine checks that a partial last line, which is
+// what a run killed mid-write leaves, neither breaks reading the journal nor
+// corrupts the next run's entries. A bad line anywhere else is an error.
+func TestJournalSurvivesPartialLine(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	filename := filepath.Join(configDir, journalFilename)
+	if err := os.WriteFile(filename, []byte(`{"run":"a","op":"start"}`+"\n"+`{"run":"a","op":"cre`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readJournal(filename)
+	if err != nil {
+		t.Fatalf("readJournal with a partial last line: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("read %d entries, want 1", len(entries))
+	}
+
+	startRun(journalEntry{Command: "sync", CSV: "MyEBirdData.csv"}).end(nil)
+
+	entries, err = readJournal(filename)
+	if err != nil {
+		t.Fatalf("readJournal after the next run: %v", err)
+	}
+	if len(entries) != 3 || entries[1].Op != opStart || entries[2].Op != opEnd {
+		t.Errorf("journal after the next run = %+v, want the old start, then a new start and end", entries)
+	}
+
+	if err := os.WriteFile(filename, []byte(`{"run":"a","op":"cre`+"\n"+`{"run":"a","op":"end"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readJournal(filename); err == nil {
+		t.Error("readJournal accepted a bad line followed by good ones")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/Sajmani/birdsync/ebird"
@@ -14,9 +15,9 @@ import (
 // the number of photos and sounds in the observation itself.
 //
 // TODO: Correct these differences by resyncing the media.
-func mediaChange(rec ebird.Record, r inat.Result) (mlAssetSet, string) {
+func mediaChange(rec ebird.Record, o indexedObservation) (mlAssetSet, string) {
 	eSet := eBirdMLAssets(rec.MLCatalogNumbers)
-	iSet, fSet := iNatMLAssets(r)
+	iSet, fSet := ledgerMLAssets(o.Description)
 	// An asset already recorded either way is not offered for upload again: a
 	// permanent failure is remembered precisely so it isn't retried (P-063).
 	known := iSet
@@ -39,8 +40,8 @@ func mediaChange(rec ebird.Record, r inat.Result) (mlAssetSet, string) {
 		diffs = append(diffs, fmt.Sprintf("%d ML Asset IDs previously failed to upload and will not be retried: %s",
 			fSet.Len(), fSet))
 	}
-	photoCount := len(r.Photos)
-	soundCount := len(r.Sounds)
+	photoCount := o.Photos
+	soundCount := o.Sounds
 	mediaCount := photoCount + soundCount
 	descCount := iSet.Len()
 	if descCount != mediaCount {
@@ -109,7 +110,12 @@ const failedNote = "(upload failed permanently; delete this line from the descri
 // description, separating those birdsync uploaded from those the service
 // permanently refused.
 func iNatMLAssets(r inat.Result) (uploaded, failed mlAssetSet) {
-	for _, line := range strings.Split(r.Description, "\n") {
+	return ledgerMLAssets(r.Description)
+}
+
+// ledgerMLAssets is iNatMLAssets for a description on its own.
+func ledgerMLAssets(description string) (uploaded, failed mlAssetSet) {
+	for _, line := range strings.Split(description, "\n") {
 		i := strings.Index(line, "macaulaylibrary.org/asset/")
 		if i < 0 {
 			continue
@@ -124,6 +130,27 @@ func iNatMLAssets(r inat.Result) (uploaded, failed mlAssetSet) {
 	return uploaded, failed
 }
 
+// attachedMLAssets returns the Macaulay Library assets attached to an
+// observation, which UploadMedia names ML<id>.<ext>. Unlike the description,
+// which birdsync updates after uploading, this is what iNaturalist actually
+// has, so it is what an interrupted run is checked against (P-073).
+func attachedMLAssets(r inat.Result) mlAssetSet {
+	var set mlAssetSet
+	add := func(filename string) {
+		name := strings.TrimSuffix(filename, path.Ext(filename))
+		if id, ok := strings.CutPrefix(name, "ML"); ok && id != "" {
+			set.Add(id)
+		}
+	}
+	for _, p := range r.Photos {
+		add(p.OriginalFilename)
+	}
+	for _, s := range r.Sounds {
+		add(s.OriginalFilename)
+	}
+	return set
+}
+
 // assetLine renders one description line for an asset.
 func assetLine(id string, ok bool) string {
 	if ok {
@@ -125,7 +125,7 @@ func TestMediaChange(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotSet, got := mediaChange(tt.rec, tt.r)
+			gotSet, got := mediaChange(tt.rec, indexObservation(tt.r))
 			if got != tt.want {
 				t.Errorf("mediaChange() got = %q, want %q", got, tt.want)
 			}
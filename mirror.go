@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// mirrorFilename is the file in --config_dir that holds the mirror.
+const mirrorFilename = "mirror.json"
+
+// mirrorVersion is the version of the mirror file's format. A mirror of any
+// other version is discarded and downloaded again. Version 2 added
+// annotations, version 4 observation field values, version 5 whether the
+// owner has identified each observation, version 6 the taxon of each
+// observation birdsync created, and version 7 the id of its sync key's
+// scientific name.
+const mirrorVersion = 7
+
+const (
+	// mirrorSweepInterval is how old the last full download may be before the
+	// next refresh downloads the account whole again rather than what
+	// changed. The sweep is the backstop for anything the incremental refresh
+	// can miss.
+	mirrorSweepInterval = 7 * 24 * time.Hour
+	// updatedSinceMargin is how far before a refresh started the next one
+	// asks for changes from, so that clock skew between this machine and
+	// iNaturalist can't open a gap between them.
+	updatedSinceMargin = time.Hour
+	// reconcileSpan is the most mirrored observations an id range may hold
+	// for reconcile to list the range rather than split it: one page.
+	reconcileSpan = 200
+)
+
+// A mirror is a local copy of what birdsync reads of the user's iNaturalist
+// observations, the whole account regardless of --after and --before, kept in
+// --config_dir between runs (P-076). Downloading an account of tens of
+// thousands of observations costs a request for every 200 of them; keeping a
+// mirror costs a run a request or two for what changed since the last one.
+type mirror struct {
+	Version int    `json:"version"`
+	UserID  string `json:"user_id"`
+	// UpdatedSince is when the next refresh asks for changes from.
+	UpdatedSince time.Time `json:"updated_since"`
+	// Swept is when the account was last downloaded whole.
+	Swept        time.Time            `json:"swept"`
+	Observations []indexedObservation `json:"observations"`
+
+	byID map[int]indexedObservation
+}
+
+// refreshMirror brings the user's mirror up to date and saves it. A mirror
+// that doesn't exist yet, or whose last sweep is more than
+// mirrorSweepInterval old, is replaced by a download of the whole account.
+// Any other asks iNaturalist for the observations updated since the last
+// refresh, which include those created since, and then reconciles the
+// mirror's ids with the account's to find those deleted.
+//
+// The mirror is saved under --dryrun too. It is a copy of what was read, not
+// a record of anything done, and a dry run that paid for a full download
+// shouldn't leave the next run to pay for another.
+func refreshMirror(ctx context.Context, inatClient inatClient, userID string) (*mirror, error) {
+	filename := filepath.Join(configDir, mirrorFilename)
+	start := time.Now()
+	m, err := loadMirror(filename, userID)
+	if err != nil {
+		log.Printf("Downloading the whole account: %v", err)
+	}
+	if m == nil || start.Sub(m.Swept) >= mirrorSweepInterval {
+		m = &mirror{Version: mirrorVersion, UserID: userID, Swept: start, byID: map[int]indexedObservation{}}
+		if err := m.update(ctx, inatClient, inat.ObservationQuery{UserID: userID}); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Printf("Updating the mirror of %d observations with changes since %s",
+			len(m.byID), m.UpdatedSince.Local().Format(time.DateTime))
+		q := inat.ObservationQuery{UserID: userID, UpdatedSince: m.UpdatedSince}
+		if err := m.update(ctx, inatClient, q); err != nil {
+			return nil, err
+		}
+		if err := m.reconcile(ctx, inatClient, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	m.UpdatedSince = start.Add(-updatedSinceMargin)
+	m.Observations = slices.SortedFunc(maps.Values(m.byID), func(a, b indexedObservation) int {
+		return a.ID - b.ID
+	})
+	if err := writeFileAtomic(filename, m); err != nil {
+		return nil, fmt.Errorf("refreshMirror: %w", err)
+	}
+	return m, nil
+}
+
+// loadMirror reads the mirror of userID's account from filename. It returns
+// nil if there is none to use: no file, or one for another user or version.
+func loadMirror(filename, userID string) (*mirror, error) {
+	b, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loadMirror(%s): %w", filename, err)
+	}
+	var m mirror
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("loadMirror(%s): %w", filename, err)
+	}
+	if m.Version != mirrorVersion || m.UserID != userID {
+		return nil, nil
+	}
+	m.byID = make(map[int]indexedObservation, len(m.Observations))
+	for _, o := range m.Observations {
+		m.byID[o.ID] = o
+	}
+	m.Observations = nil
+	return &m, nil
+}
+
+// update adds or replaces the observations q selects.
+func (m *mirror) update(ctx context.Context, inatClient inatClient, q inat.ObservationQuery) error {
+	for r, err := range inatClient.QueryObservations(ctx, q, indexFields...) {
+		if err != nil {
+			return fmt.Errorf("mirror.update: %w", err)
+		}
+		o := indexObservation(r)
+		if !o.Key.Valid() {
+			// Only the ledger, annotations, and fields of a birdsync
+			// observation are read.
+			o.Description, o.Annotations, o.Fields = "", nil, nil
+		}
+		m.byID[r.ID] = o
+	}
+	return nil
+}
+
+// reconcile drops the mirrored observations with ids in (above, below) that
+// have been deleted from the account. A zero bound is no bound.
+//
+// After update the mirror holds every observation in the account and
+// possibly some that are gone, so a range holds as many in the account as in
+// the mirror only if none of its mirrored observations are gone. A range that
+// differs is split in two at its middle mirrored id and each half reconciled
+// in turn, until a range is small enough to list; so a run costs one count
+// when nothing was deleted, and a few per deletion when something was.
+//
+// An observation created between update and a count makes the account's side
+// of that range larger, which can hide a deletion in it; the next sweep
+// drops that one.
+func (m *mirror) reconcile(ctx context.Context, inatClient inatClient, above, below int) error {
+	var ids []int
+	for id := range m.byID {
+		if id > above && (below == 0 || id < below) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	q := inat.ObservationQuery{UserID: m.UserID, IDAbove: above, IDBelow: below}
+	n, err := inatClient.CountObservations(ctx, q)
+	if err != nil {
+		return fmt.Errorf("mirror.reconcile: %w", err)
+	}
+	if n >= len(ids) {
+		return nil
+	}
+	if len(ids) <= reconcileSpan {
+		present := map[int]bool{}
+		for r, err := range inatClient.QueryObservations(ctx, q) {
+			if err != nil {
+				return fmt.Errorf("mirror.reconcile: %w", err)
+			}
+			present[r.ID] = true
+		}
+		for _, id := range ids {
+			if !present[id] {
+				debugf("mirror: %s was deleted", inat.ObservationURL(m.byID[id].UUID))
+				delete(m.byID, id)
+			}
+		}
+		return nil
+	}
+	slices.Sort(ids)
+	mid := ids[len(ids)/2]
+	if err := m.reconcile(ctx, inatClient, above, mid); err != nil {
+		return err
+	}
+	return m.reconcile(ctx, inatClient, mid-1, below)
+}
+
+// index indexes the mirrored observations observed inside [d1, d2], as a
+// download with those bounds would have. A zero bound is no bound.
+func (m *mirror) index(d1, d2 time.Time) syncIndex {
+	const dateFormat = "2006-01-02"
+	ix := newSyncIndex()
+	for _, o := range m.byID {
+		if !d1.IsZero() && (o.ObservedOn == "" || o.ObservedOn < d1.Format(dateFormat)) {
+			continue
+		}
+		if !d2.IsZero() && (o.ObservedOn == "" || o.ObservedOn > d2.Format(dateFormat)) {
+			continue
+		}
+		ix.add(o)
+	}
+	debugf("Previously synced %d observations\n", len(ix.previouslySynced))
+	return ix
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// mirrorAccount returns an account of n observations, every other one
+// birdsync's, that were last changed a year ago.
+func mirrorAccount(n int) *mockINatClient {
+	m := &mockINatClient{}
+	updated := time.Now().AddDate(-1, 0, 0).UTC().Format(time.RFC3339)
+	for i := range n {
+		r := inat.Result{
+			ID:         i + 1,
+			UUID:       uuid.New(),
+			ObservedOn: "2024-05-01",
+			UpdatedAt:  updated,
+			Taxon:      inat.Taxon{Name: "Corvus brachyrhynchos", PreferredCommonName: "American Crow"},
+		}
+		if i%2 == 0 {
+			r.Ofvs = []inat.Ofv{
+				{FieldID: inat.EBirdField, Value: fmt.Sprintf("S%d", i)},
+				{FieldID: inat.EBirdScientificNameField, Value: "Corvus brachyrhynchos"},
+			}
+		}
+		m.observations = append(m.observations, r)
+	}
+	return m
+}
+
+// mustDownloadIndex indexes the account, failing the test if it can't.
+func mustDownloadIndex(t *testing.T, inatClient inatClient) syncIndex {
+	t.Helper()
+	ix, err := downloadIndex(context.Background(), inatClient, "myUserID")
+	if err != nil {
+		t.Fatalf("downloadIndex() error = %v", err)
+	}
+	return ix
+}
+
+// TestMirrorRefreshesWhatChanged checks that a run after the first asks for
+// what changed rather than the whole account, and sees the change.
+//
+// Verifies: P-076.
+func TestMirrorRefreshesWhatChanged(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	mockInat := mirrorAccount(1000)
+	mustDownloadIndex(t, mockInat)
+	if mockInat.downloads != 1 {
+		t.Fatalf("first run downloaded %d times, want once", mockInat.downloads)
+	}
+
+	mockInat.observations[0].Ofvs[0].Value = "S99999"
+	mockInat.observations[0].UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	mockInat.queries = 0
+	ix := mustDownloadIndex(t, mockInat)
+
+	// One request for the change and one to count the account.
+	if mockInat.queries != 2 {
+		t.Errorf("refresh made %d queries, want 2", mockInat.queries)
+	}
+	if len(ix.previouslySynced) != 500 {
+		t.Errorf("index has %d birdsync observations, want 500", len(ix.previouslySynced))
+	}
+	for key := range ix.previouslySynced {
+		if key.SubmissionID == "S0" {
+			t.Errorf("index still has %s after it changed to S99999", key)
+		}
+	}
+}
+
+// TestMirrorDropsDeletions checks that an observation deleted from the
+// account leaves the mirror on the next run, which finds it in a few
+// requests, not by downloading the account again.
+//
+// Verifies: P-076.
+func TestMirrorDropsDeletions(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	mockInat := mirrorAccount(1000)
+	mustDownloadIndex(t, mockInat)
+
+	gone := mockInat.observations[700]
+	mockInat.deleted = append(mockInat.deleted, gone.UUID)
+	mockInat.queries = 0
+	ix := mustDownloadIndex(t, mockInat)
+
+	if mockInat.queries > 10 {
+		t.Errorf("found the deletion in %d queries, want no more than 10", mockInat.queries)
+	}
+	if len(ix.previouslySynced) != 499 {
+		t.Errorf("index has %d birdsync observations, want 499", len(ix.previouslySynced))
+	}
+	m, err := loadMirror(filepath.Join(configDir, mirrorFilename), "myUserID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.byID[gone.ID]; ok || len(m.byID) != 999 {
+		t.Errorf("mirror has %d observations, including %d: %v; want 999, without it", len(m.byID), gone.ID, ok)
+	}
+}
+
+// TestMirrorSweeps checks that a mirror whose last full download is older than
+// mirrorSweepInterval is downloaded whole again, and that a mirror of another
+// user's account isn't used.
+//
+// Verifies: P-076.
+func TestMirrorSweeps(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	mockInat := mirrorAccount(10)
+	mustDownloadIndex(t, mockInat)
+
+	filename := filepath.Join(configDir, mirrorFilename)
+	m, err := loadMirror(filename, "myUserID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Swept = time.Now().Add(-mirrorSweepInterval)
+	m.Observations = m.Observations[:0]
+	for _, o := range m.byID {
+		m.Observations = append(m.Observations, o)
+	}
+	if err := writeFileAtomic(filename, m); err != nil {
+		t.Fatal(err)
+	}
+	mockInat.downloads = 0
+	mustDownloadIndex(t, mockInat)
+	if mockInat.downloads != 1 || mockInat.queries != 2 {
+		t.Errorf("stale mirror refreshed with %d downloads and %d queries in all, want one more full download",
+			mockInat.downloads, mockInat.queries)
+	}
+
+	if m, _ := loadMirror(filename, "someoneElse"); m != nil {
+		t.Error("loaded myUserID's mirror for someoneElse")
+	}
+}
+
+// TestMirrorSeesUndo checks that a record whose observation birdsync undo
+// deleted is created again by the next sync, which it wouldn't be if the
+// mirror still held the observation.
+//
+// Verifies: P-076, P-072.
+func TestMirrorSeesUndo(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	mockInat := &mockINatClient{}
+	run := syncRun(t, mockInat, crowRecord("S600"))
+	if err := undoRun(readTestJournal(t), run, mockInat, func(int) bool { return true }); err != nil {
+		t.Fatalf("undoRun: %v", err)
+	}
+	syncRun(t, mockInat, crowRecord("S600"))
+
+	if got := createdKeys(mockInat); len(got) != 2 {
+		t.Errorf("created %v, want S600 again after the undo", got)
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// An actionKind is what birdsync does with one eBird record.
+type actionKind string
+
+const (
+	// createAction creates a new observation, then uploads its media.
+	createAction actionKind = "create"
+	// updateAction uploads media added in eBird to an observation birdsync
+	// created on an earlier run, and appends them to its description, and
+	// adds the annotations and observation field values it lacks, and
+	// re-keys it after eBird renames its species.
+	updateAction actionKind = "update"
+	skipAction   actionKind = "skip"
+)
+
+// A skipReason names the rule that skipped a record. Each has its own counter
+// in the summary, so the reasons are fixed values rather than free text.
+type skipReason string
+
+const (
+	skipInvalid          skipReason = "invalid"
+	skipAfter            skipReason = "after"
+	skipBefore           skipReason = "before"
+	skipCategory         skipReason = "category"
+	skipPreviouslySynced skipReason = "previously-synced"
+	skipFuzzy            skipReason = "fuzzy"
+	skipUnverifiable     skipReason = "unverifiable"
+	// skipLookup is a record whose name couldn't be looked up on
+	// iNaturalist. Unlike the others, a rerun doesn't skip it again.
+	skipLookup skipReason = "lookup"
+)
+
+// An action is the decision made about one eBird record. Every record yields
+// exactly one, skips included, so a plan accounts for the whole export.
+type action struct {
+	Kind actionKind `json:"kind"`
+	// Source is the export the record was read from, and Line its line
+	// there, so a reviewer can find it.
+	Source string              `json:"source,omitempty"`
+	Line   int                 `json:"line"`
+	Key    ebird.ObservationID `json:"key"`
+	// Skip is set only for skipAction.
+	Skip skipReason `json:"skip,omitempty"`
+	// Reason explains the decision to the person reviewing a plan.
+	Reason string `json:"reason"`
+	// Observation is the observation to create, including the UUID it will
+	// be created under. For an update it holds the existing observation's
+	// UUID and its description as it was when the decision was made, which is
+	// what apply checks for drift.
+	Observation *inat.Observation `json:"observation,omitempty"`
+	// Media lists the Macaulay Library assets to upload.
+	Media []string `json:"media,omitempty"`
+	// ObservedOn is the observation date of a record to be created
+	// (2006-01-02), so apply can repeat the --fuzzy check.
+	ObservedOn string `json:"observed_on,omitempty"`
+	// Attached are assets already attached to the observation that its
+	// description doesn't list yet, which a run that died between uploading
+	// and updating leaves behind (P-073). They need listing, not uploading.
+	Attached []string `json:"attached,omitempty"`
+	// Annotations are the annotations to add, from the record's breeding
+	// code (P-083).
+	Annotations []inat.Annotation `json:"annotations,omitempty"`
+	// Fields are the observation field values an update adds (P-084). A
+	// create sets them in Observation.
+	Fields []inat.ObservationFieldValue `json:"fields,omitempty"`
+	// FormerKey is the sync key an update's observation carries, set when
+	// eBird has renamed its species since it was synced, and the update
+	// re-keys it to Key (P-089), replacing the scientific name value whose
+	// id is KeyValueID.
+	FormerKey  *ebird.ObservationID `json:"former_key,omitempty"`
+	KeyValueID int                  `json:"key_value_id,omitempty"`
+	// Identification is the owner's identification to add: the taxon the
+	// record's name resolves to, for an observation created without one or
+	// synced before birdsync identified observations (P-090). A TaxonID of
+	// 0 is looked up when the action is carried out.
+	Identification *inat.Identification `json:"identification,omitempty"`
+}
+
+// plan decides what to do with each eBird record, without doing any of it.
+// Nothing here writes: the decisions are the same whether they are carried
+// out straight away, as a plain run does, or reviewed first (P-069).
+func (ix syncIndex) plan(records iter.Seq[ebird.Record]) iter.Seq[action] {
+	return func(yield func(action) bool) {
+		for rec := range records {
+			if !yield(ix.decide(rec)) {
+				return
+			}
+		}
+	}
+}
+
+// decide tests rec against each rule in the order P-026 fixes, and returns
+// the first that applies.
+func (ix syncIndex) decide(rec ebird.Record) action {
+	key := rec.ObservationID()
+	skip := func(reason skipReason, format string, args ...any) action {
+		return action{
+			Kind:   skipAction,
+			Source: rec.Source,
+			Line:   rec.Line,
+			Key:    key,
+			Skip:   reason,
+			Reason: fmt.Sprintf(format, args...),
+		}
+	}
+
+	observed, err := rec.Observed()
+	if err != nil {
+		// A malformed row costs that row, not the run. eBird's export
+		// varies between users, so one unparseable date in a twelve
+		// thousand row file is realistic, and aborting partway would
+		// leave the sync half done (P-062).
+		log.Printf("line %d: SKIPPING record with bad date/time: %v", rec.Line, err)
+		return skip(skipInvalid, "bad date/time: %v", err)
+	}
+	// Skip records that were not observed between --after and --before.
+	if !after.Time().IsZero() && observed.Before(after.Time()) {
+		debugf("line %d: SKIPPING record observed on %s (before --after=%s)",
+			rec.Line, observed, after.Time())
+		return skip(skipAfter, "observed on %s, before --after=%s", observed, after.Time())
+	}
+	if !before.Time().IsZero() && observed.After(before.Time()) {
+		debugf("line %d: SKIPPING record observed on %s (after --before=%s)",
+			rec.Line, observed, before.Time())
+		return skip(skipBefore, "observed on %s, after --before=%s", observed, before.Time())
+	}
+	// Skip records whose taxon is in a category --exclude_categories names,
+	// or not in one --include_categories names.
+	if taxon := rec.Taxon(); excludeCategories.has(taxon.Category) {
+		debugf("line %d: SKIPPING %s, a %s (--exclude_categories=%s)",
+			rec.Line, rec.ScientificName, taxon.Category, &excludeCategories)
+		return skip(skipCategory, "%s is a %s (--exclude_categories)", rec.ScientificName, taxon.Category)
+	}
+	if taxon := rec.Taxon(); len(includeCategories) > 0 && !includeCategories.has(taxon.Category) {
+		debugf("line %d: SKIPPING %s, a %s (--include_categories=%s)",
+			rec.Line, rec.ScientificName, taxon.Category, &includeCategories)
+		return skip(skipCategory, "%s is a %s, not in --include_categories", rec.ScientificName, taxon.Category)
+	}
+
+	// Skip records that have previously been uploaded by birdsync, under
+	// this name or one eBird has since renamed to it.
+	r, ok := ix.previouslySynced[key]
+	var formerKey *ebird.ObservationID
+	keyValueID := 0
+	if !ok {
+		var former ebird.ObservationID
+		if r, former, ok = ix.renamed(key); ok {
+			formerKey, keyValueID = &former, r.KeyValueID
+			// Later records see the observation under its new key, as
+			// they will once it has been re-keyed, so that a split
+			// can't re-key it twice. A resumed run sees it so too
+			// (checkpoint.Rekeyed).
+			ix.rekey(former, key)
+		}
+	}
+	if ok {
+		debugf("line %d: Already synced %s to iNaturalist as %s\n",
+			rec.Line, key, r.URLWithSpecies())
+		addedMediaIDs, summary := mediaChange(rec, r)
+		if summary != "" {
+			log.Printf("Media assets differ between eBird %s and iNaturalist %s: %s",
+				rec.URLWithSpecies(), r.URLWithSpecies(), summary)
+		}
+		// An observation synced before its breeding code was annotated,
+		// or before birdsync annotated at all, is annotated now.
+		annotations := missingAnnotations(recordAnnotations(rec), r.Annotations)
+		// Likewise the fields birdsync sets, such as the checklist's
+		// distance, that it didn't set when the observation was created.
+		fields := missingFieldValues(rec, r)
+		// And one synced before birdsync identified what it created. A
+		// name that has to be looked up for it is looked up when the
+		// update is carried out, where overBudget counts the lookup, and
+		// not while planning (identify).
+		var ident *inat.Identification
+		if !r.OwnerIdentified {
+			if taxonID, ok := ix.taxa.known(rec); ok {
+				ident = ownerIdentification(rec, taxonID, r.UUID)
+			} else {
+				ident = &inat.Identification{ObservationID: r.UUID, Body: identificationBody(rec)}
+			}
+		}
+		if formerKey == nil && ident == nil && addedMediaIDs.Len() == 0 && len(annotations) == 0 && len(fields) == 0 {
+			return skip(skipPreviouslySynced, "already synced as %s", inat.ObservationURL(r.UUID))
+		}
+		var reasons []string
+		if formerKey != nil {
+			reasons = append(reasons, fmt.Sprintf("synced as %s, which eBird has renamed", formerKey.ScientificName))
+		}
+		if addedMediaIDs.Len() > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d media assets added in eBird since the last sync", addedMediaIDs.Len()))
+		}
+		if len(annotations) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d annotations for breeding code %q missing", len(annotations), rec.BreedingCode))
+		}
+		if len(fields) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d observation fields missing", len(fields)))
+		}
+		if ident != nil {
+			reasons = append(reasons, "no owner identification")
+		}
+		return action{
+			Kind:   updateAction,
+			Source: rec.Source,
+			Line:   rec.Line,
+			Key:    key,
+			Reason: strings.Join(reasons, "; "),
+			Observation: &inat.Observation{
+				UUID:        r.UUID,
+				Description: r.Description,
+			},
+			Media:          addedMediaIDs.ids,
+			Annotations:    annotations,
+			Fields:         fields,
+			FormerKey:      formerKey,
+			KeyValueID:     keyValueID,
+			Identification: ident,
+		}
+	}
+
+	// eBird writes dates in several formats, so compare against the parsed
+	// observation date rather than the raw CSV field, which may be "1/2/2006".
+	observedOn := observed.Format(time.DateOnly)
+	if fuzzy {
+		// Skip records for the same bird and date as an existing non-birdsync observation.
+		debugf("line %d: fuzzy match: check %s %q %q", rec.Line, observedOn, rec.CommonName, rec.ScientificName)
+		if fk, ok := ix.fuzzy(observedOn, rec.CommonName, rec.ScientificName); ok {
+			log.Printf("line %d: SKIPPING fuzzy match: observation for same bird and date: %+v", rec.Line, fk)
+			return skip(skipFuzzy, "a non-birdsync observation of %s on %s exists", fk.name, fk.observedDate)
+		}
+	}
+
+	assetIDs := eBirdMLAssets(rec.MLCatalogNumbers)
+	// Skip records without media assets if --verifiable is set. This is
+	// checked before the record is parsed any further, so that a record is
+	// counted against the rule that actually skipped it (P-026).
+	if verifiable && assetIDs.Len() == 0 {
+		debugf("line %d: SKIPPING record that has no photos or sounds (--verifiable=true)", rec.Line)
+		return skip(skipUnverifiable, "no photos or sounds (--verifiable=true)")
+	}
+
+	// Create the iNaturalist observation from the eBird record.
+	coordinate := func(s string) (float64, error) {
+		if s == "" {
+			return 0, nil // eBird omits coordinates for some locations
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+	latitude, err := coordinate(rec.Latitude)
+	if err != nil {
+		log.Printf("line %d: SKIPPING record with bad latitude %q: %v", rec.Line, rec.Latitude, err)
+		return skip(skipInvalid, "bad latitude %q: %v", rec.Latitude, err)
+	}
+	longitude, err := coordinate(rec.Longitude)
+	if err != nil {
+		log.Printf("line %d: SKIPPING record with bad longitude %q: %v", rec.Line, rec.Longitude, err)
+		return skip(skipInvalid, "bad longitude %q: %v", rec.Longitude, err)
+	}
+	taxonID, speciesGuess, err := ix.taxa.resolve(rec)
+	if err != nil {
+		// Created without its taxon, the observation would keep only
+		// the species guess, and a rerun would find it already synced.
+		log.Printf("line %d: SKIPPING record whose taxon couldn't be looked up: %v", rec.Line, err)
+		return skip(skipLookup, "taxon lookup failed: %v", err)
+	}
+	obs := inat.Observation{
+		UUID: uuid.New(),
+		// eBird checklists record wild birds, but for a domestic type
+		// (P-082).
+		CaptiveFlag:                      rec.Taxon().Category == ebird.Domestic,
+		Latitude:                         latitude,
+		Longitude:                        longitude,
+		LocationIsExact:                  false,
+		PositionalAccuracy:               float64(positionalAccuracy),
+		SpeciesGuess:                     speciesGuess,
+		ObservedOnString:                 observedOnString(rec, observed),
+		ObservationFieldValuesAttributes: recordFieldValues(rec),
+	}
+	obs.Description = "Observation created using github.com/Sajmani/birdsync \n"
+	if len(rec.ObservationDetails) > 0 {
+		obs.Description += "eBird observation details:\n" +
+			rec.ObservationDetails + "\n"
+	}
+	obs.Description += "Checklist: " + rec.URL() + "\n"
+	obs.Description += "Protocol: " + rec.Protocol + "\n"
+	if len(rec.ChecklistComments) > 0 {
+		obs.Description += "eBird checklist comments:\n" +
+			rec.ChecklistComments + "\n"
+	}
+	return action{
+		Kind:           createAction,
+		Source:         rec.Source,
+		Line:           rec.Line,
+		Key:            key,
+		Reason:         fmt.Sprintf("not yet in iNaturalist; %d media assets", assetIDs.Len()),
+		Observation:    &obs,
+		Media:          assetIDs.ids,
+		ObservedOn:     observedOn,
+		Annotations:    recordAnnotations(rec),
+		Identification: ownerIdentification(rec, taxonID, obs.UUID),
+	}
+}
+
+// ownerIdentification returns the identification of the observation obsUUID
+// of rec as taxonID, which rec's name resolved to, noting the checklist it
+// came from (P-090). A name that resolved to no taxon has none. A create
+// sends its taxon here alone: a taxon_id on the observation would make a
+// second identification, saying nothing of the checklist.
+func ownerIdentification(rec ebird.Record, taxonID int, obsUUID uuid.UUID) *inat.Identification {
+	if taxonID == 0 {
+		return nil
+	}
+	return &inat.Identification{
+		ObservationID: obsUUID,
+		TaxonID:       taxonID,
+		Body:          identificationBody(rec),
+	}
+}
+
+// identificationBody returns the body of the owner's identification of rec,
+// which names the checklist it came from.
+func identificationBody(rec ebird.Record) string {
+	return fmt.Sprintf("Identified as %s on eBird checklist %s; added by github.com/Sajmani/birdsync", rec.ScientificName, rec.URL())
+}
+
+// observedOnString returns the date and time iNaturalist is given for rec,
+// observed at observed: rewritten from eBird's locale's form into one that
+// iNaturalist can't read the wrong way round (P-038).
+func observedOnString(rec ebird.Record, observed time.Time) string {
+	if rec.Time == "" {
+		return observed.Format(time.DateOnly)
+	}
+	return observed.Format("2006-01-02 03:04 PM")
+}
+
+// planVersion is the plan file format. apply refuses any other version rather
+// than guess at what a plan written by a different birdsync meant. Version 2
+// added the id of the value a re-key replaces, without which apply would add
+// a second, version 3 took the taxon_id out of a create, which its
+// identification sets, and version 4 left the taxon of a synced observation's
+// identification to be looked up by apply.
+const planVersion = 4
+
+// A syncPlan is the reviewable output of `birdsync plan`: every decision about
+// every record, and the settings they were made under (P-069).
+type syncPlan struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	UserID  string    `json:"user_id"`
+	// CSV names the exports the plan was made from.
+	CSV string `json:"csv"`
+	// CSVSHA256 identifies the exports the plan was made from, if they could
+	// be read, for the journal (P-071).
+	CSVSHA256 string `json:"csv_sha256,omitempty"`
+
+	// The flags that shaped the decisions. apply restores them, so that its
+	// summary describes the rules the plan was made under, and so that the
+	// drift check looks at the same date window the plan did.
+	Verifiable bool           `json:"verifiable"`
+	Fuzzy      bool           `json:"fuzzy"`
+	After      time.Time      `json:"after"`
+	Before     time.Time      `json:"before"`
+	Exclude    categoriesFlag `json:"exclude_categories,omitempty"`
+	Include    categoriesFlag `json:"include_categories,omitempty"`
+
+	Actions []action `json:"actions"`
+}
+
+// makePlan decides what a sync of the exports would do, and writes nothing.
+func makePlan(eBirdCSVFilenames []string, ebirdClient ebirdClient, inatUserID string, inatClient inatClient) syncPlan {
+	// A plan writes nothing, so an interrupt can simply end it.
+	ix, err := downloadIndex(context.Background(), inatClient, inatUserID)
+	if err != nil {
+		log.Fatalf("Downloading iNaturalist observations: %v", err)
+	}
+	ix.taxa = newTaxonResolver(inatClient)
+	p := syncPlan{
+		Version:    planVersion,
+		Created:    time.Now().UTC(),
+		UserID:     inatUserID,
+		CSV:        strings.Join(eBirdCSVFilenames, ", "),
+		Verifiable: verifiable,
+		Fuzzy:      fuzzy,
+		After:      after.Time(),
+		Before:     before.Time(),
+		Exclude:    excludeCategories,
+		Include:    includeCategories,
+	}
+	if sum, err := exportsSHA256(eBirdCSVFilenames); err == nil {
+		p.CSVSHA256 = sum
+	}
+	for a := range ix.plan(readRecords(ebirdClient, eBirdCSVFilenames)) {
+		p.Actions = append(p.Actions, a)
+	}
+	ix.taxa.reportUnresolved()
+	return p
+}
+
+// restoreFlags sets the flags the plan was made under.
+func (p syncPlan) restoreFlags() {
+	verifiable = p.Verifiable
+	fuzzy = p.Fuzzy
+	after = dateTimeFlag{p.After}
+	before = dateTimeFlag{p.Before}
+	excludeCategories = p.Exclude
+	includeCategories = p.Include
+}
+
+// tally counts the plan's actions the way a dry run of it would.
+func (p syncPlan) tally() stats {
+	var s stats
+	for _, a := range p.Actions {
+		s.totalRecords++
+		switch a.Kind {
+		case skipAction:
+			s.countSkip(a.Skip)
+		case createAction:
+			s.createdObservations++
+			fallthrough
+		case updateAction:
+			if len(a.Media) > 0 {
+				s.updatedObservations++
+			}
+			s.pendingMedia += len(a.Media)
+			s.annotations += len(a.Annotations)
+			s.fieldValues += len(a.Fields)
+			if a.FormerKey != nil {
+				s.rekeyed++
+			}
+			if a.Identification != nil {
+				s.identifications++
+			}
+		}
+	}
+	return s
+}
+
+func writePlan(filename string, p syncPlan) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("writePlan(%s): %w", filename, err)
+	}
+	if err := os.WriteFile(filename, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writePlan(%s): %w", filename, err)
+	}
+	return nil
+}
+
+func readPlan(filename string) (syncPlan, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return syncPlan{}, fmt.Errorf("readPlan(%s): %w", filename, err)
+	}
+	var p syncPlan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return syncPlan{}, fmt.Errorf("readPlan(%s): %w", filename, err)
+	}
+	if p.Version != planVersion {
+		return syncPlan{}, fmt.Errorf("readPlan(%s): plan version %d, want %d; run birdsync plan again",
+			filename, p.Version, planVersion)
+	}
+	return p, nil
+}
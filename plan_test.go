@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// planFixture returns an export with one record for each kind of action, and
+// an account holding the observation the update applies to.
+func planFixture() (*mockEBirdClient, *mockINatClient) {
+	mockEbird := &mockEBirdClient{records: []ebird.Record{
+		{ // create
+			Line:             2,
+			SubmissionID:     "S400",
+			ScientificName:   "Corvus brachyrhynchos",
+			CommonName:       "American Crow",
+			Date:             "2023-01-03",
+			Time:             "03:00 PM",
+			MLCatalogNumbers: "11111",
+		},
+		{ // update: 22223 was added in eBird since the last sync
+			Line:             3,
+			SubmissionID:     "S401",
+			ScientificName:   "Turdus migratorius",
+			CommonName:       "American Robin",
+			Date:             "2023-01-03",
+			Time:             "09:00 AM",
+			MLCatalogNumbers: "22222 22223",
+		},
+		{ // skip: no media
+			Line:           4,
+			SubmissionID:   "S402",
+			ScientificName: "Buteo jamaicensis",
+			CommonName:     "Red-tailed Hawk",
+			Date:           "2023-01-03",
+			Time:           "01:00 PM",
+		},
+	}}
+	mockInat := &mockINatClient{observations: []inat.Result{{
+		UUID:        uuid.New(),
+		ObservedOn:  "2023-01-03",
+		Description: mlAssetURL("22222"),
+		Ofvs: []inat.Ofv{
+			{FieldID: inat.EBirdField, Value: "S401"},
+			{FieldID: inat.EBirdScientificNameField, Value: "Turdus migratorius"},
+		},
+	}}}
+	return mockEbird, mockInat
+}
+
+// TestPlanIssuesNoWrites checks that a plan accounts for every record, with a
+// reason for each decision, and that making one writes nothing.
+//
+// Verifies: P-069.
+func TestPlanIssuesNoWrites(t *testing.T) {
+	resetFlags()
+	mockEbird, mockInat := planFixture()
+
+	p := makePlan([]string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+
+	if n := len(mockInat.created) + len(mockInat.updated) + len(mockInat.uploaded) + len(mockInat.identified); n != 0 {
+		t.Errorf("making a plan issued %d writes, want 0", n)
+	}
+	want := []struct {
+		kind  actionKind
+		skip  skipReason
+		media int
+	}{
+		{createAction, "", 1},
+		{updateAction, "", 1},
+		{skipAction, skipUnverifiable, 0},
+	}
+	if len(p.Actions) != len(want) {
+		t.Fatalf("plan has %d actions, want %d: %+v", len(p.Actions), len(want), p.Actions)
+	}
+	for i, w := range want {
+		a := p.Actions[i]
+		if a.Kind != w.kind || a.Skip != w.skip || len(a.Media) != w.media {
+			t.Errorf("action %d = %s %q with %d media, want %s %q with %d",
+				i, a.Kind, a.Skip, len(a.Media), w.kind, w.skip, w.media)
+		}
+		if a.Reason == "" {
+			t.Errorf("action %d (%s) has no reason", i, a.Kind)
+		}
+	}
+	if got := p.Actions[1].Media; len(got) != 1 || got[0] != "22223" {
+		t.Errorf("update uploads %v, want [22223]", got)
+	}
+}
+
+// TestPlanFileRoundTrip checks that a plan survives being written and read
+// back, and that a plan file from another version is refused.
+//
+// Verifies: P-069.
+func TestPlanFileRoundTrip(t *testing.T) {
+	resetFlags()
+	fuzzy = true
+	mockEbird, mockInat := planFixture()
+	p := makePlan([]string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+
+	filename := filepath.Join(t.TempDir(), "plan.json")
+	if err := writePlan(filename, p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readPlan(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Fuzzy || got.UserID != "myUserID" || len(got.Actions) != len(p.Actions) {
+		t.Errorf("read back %+v, want %+v", got, p)
+	}
+	if got.Actions[0].Observation.UUID != p.Actions[0].Observation.UUID {
+		t.Errorf("create UUID = %s after round trip, want %s",
+			got.Actions[0].Observation.UUID, p.Actions[0].Observation.UUID)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := strings.Replace(string(b), fmt.Sprintf(`"version": %d`, planVersion), fmt.Sprintf(`"version": %d`, planVersion-1), 1)
+	if err := os.WriteFile(filename, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPlan(filename); err == nil {
+		t.Error("readPlan accepted a plan with a different version")
+	}
+}
+
+// TestApplyCarriesOutPlan checks that apply does what the plan says, with the
+// UUID the plan chose, and nothing else.
+//
+// Verifies: P-069, P-070.
+func TestApplyCarriesOutPlan(t *testing.T) {
+	resetFlags()
+	mockEbird, mockInat := planFixture()
+	p := makePlan([]string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+
+	stats, err := applyPlan(context.Background(), p, mockEbird, mockInat)
+	if err != nil {
+		t.Fatalf("applyPlan: %v", err)
+	}
+	if len(mockInat.created) != 1 || mockInat.created[0].UUID != p.Actions[0].Observation.UUID {
+		t.Errorf("created %+v, want the planned observation %s", mockInat.created, p.Actions[0].Observation.UUID)
+	}
+	var uploaded []string
+	for _, u := range mockInat.uploaded {
+		uploaded = append(uploaded, u.assetID)
+	}
+	if strings.Join(uploaded, " ") != "11111 22223" {
+		t.Errorf("uploaded %v, want [11111 22223]", uploaded)
+	}
+	if stats.createdObservations != 1 || stats.updatedObservations != 2 || stats.verifiableSkips != 1 {
+		t.Errorf("stats = %+v, want 1 created, 2 updated, 1 verifiable skip", stats)
+	}
+}
+
+// TestApplyRefusesDrift checks that apply writes nothing when iNaturalist has
+// changed since the plan was made in a way that would make an action wrong.
+//
+// Verifies: P-070.
+func TestApplyRefusesDrift(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		drift func(m *mockINatClient)
+	}{
+		{"created since", func(m *mockINatClient) {
+			m.observations = append(m.observations, inat.Result{
+				UUID: uuid.New(),
+				Ofvs: []inat.Ofv{
+					{FieldID: inat.EBirdField, Value: "S400"},
+					{FieldID: inat.EBirdScientificNameField, Value: "Corvus brachyrhynchos"},
+				},
+			})
+		}},
+		{"description edited", func(m *mockINatClient) {
+			m.observations[0].Description += "\nEdited by hand"
+		}},
+		{"deleted", func(m *mockINatClient) {
+			m.observations = nil
+		}},
+		{"fuzzy match", func(m *mockINatClient) {
+			m.observations = append(m.observations, inat.Result{
+				UUID:       uuid.New(),
+				ObservedOn: "2023-01-03",
+				Taxon:      inat.Taxon{PreferredCommonName: "American Crow"},
+			})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetFlags()
+			fuzzy = true
+			mockEbird, mockInat := planFixture()
+			p := makePlan([]string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+
+			tc.drift(mockInat)
+			if _, err := applyPlan(context.Background(), p, mockEbird, mockInat); err == nil {
+				t.Error("applyPlan succeeded despite drift")
+			}
+			if n := len(mockInat.created) + len(mockInat.updated) + len(mockInat.uploaded); n != 0 {
+				t.Errorf("applyPlan issued %d writes despite drift, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// taxaFilename is the file in --config_dir that caches resolved names.
+const taxaFilename = "taxa.json"
+
+// taxonCacheTTL is how long a resolved name is trusted. iNaturalist's
+// taxonomy changes more often than eBird's yearly update: a month keeps a
+// sync of a large export to a lookup per species, and a name that resolves
+// differently next month is looked up again.
+const taxonCacheTTL = 30 * 24 * time.Hour
+
+// A cachedTaxon is what a lookup of one eBird scientific name found. ID is 0
+// for a name with no confident match, which is cached too, so that a name
+// iNaturalist doesn't know isn't looked up for every record of it.
+type cachedTaxon struct {
+	ID   int    `json:"id,omitempty"`
+	Name string `json:"name,omitempty"` // iNaturalist's, for the log
+	// Ancestors are the IDs of the taxa the match belongs to, which
+	// taxa-report reads to tell a coarser identification from a different
+	// one (P-088).
+	Ancestors []int     `json:"ancestors,omitempty"`
+	Resolved  time.Time `json:"resolved"`
+}
+
+// A taxonResolver maps eBird scientific names to iNaturalist taxon IDs
+// (P-086), looking each up once per run at most, and once per taxonCacheTTL
+// with a --config_dir.
+type taxonResolver struct {
+	inatClient inatClient
+	filename   string // "" keeps the cache for this run alone
+	cache      map[string]cachedTaxon
+	// stopped is why lookups stopped: --daily_requests running out, or the
+	// run being interrupted, after which every later lookup would fail the
+	// same way and none are made. Any other failure costs its record alone.
+	stopped error
+	// unresolved counts the records of each name resolve found no taxon
+	// for, which a dry run reports (P-087).
+	unresolved map[string]int
+}
+
+// taxonOverrides is the --taxa_overrides file, read by checkArgs: an
+// iNaturalist taxon ID or name for each eBird scientific name it lists
+// (P-087).
+var taxonOverrides map[string]string
+
+// readTaxonOverrides reads a --taxa_overrides file: a CSV file, as a
+// spreadsheet saves one, whose first column is an eBird scientific name and
+// whose second is the iNaturalist taxon ID or scientific name to use for it.
+// The first row is a header, and a row whose first column starts with # is a
+// comment.
+func readTaxonOverrides(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("readTaxonOverrides: %w", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("readTaxonOverrides(%s): reading the header: %w", filename, err)
+	}
+	overrides := map[string]string{}
+	var errs []error
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("readTaxonOverrides(%s): %w", filename, err)
+		}
+		line, _ := r.FieldPos(0)
+		for len(row) < 2 {
+			row = append(row, "")
+		}
+		ebirdName, inatTaxon := strings.TrimSpace(row[0]), strings.TrimSpace(row[1])
+		switch {
+		case ebirdName == "" && inatTaxon == "":
+			// A blank row, which spreadsheets leave at the end.
+		case ebirdName == "" || inatTaxon == "":
+			errs = append(errs, fmt.Errorf("%s:%d: want an eBird name and an iNaturalist taxon", filename, line))
+		case overrides[ebirdName] != "":
+			errs = append(errs, fmt.Errorf("%s:%d: %s is listed twice", filename, line, ebirdName))
+		default:
+			overrides[ebirdName] = inatTaxon
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// newTaxonResolver returns a resolver that looks names up with inatClient,
+// starting from the cache in --config_dir. A cache that can't be read is
+// started again.
+func newTaxonResolver(inatClient inatClient) *taxonResolver {
+	tr := &taxonResolver{inatClient: inatClient, cache: map[string]cachedTaxon{}, unresolved: map[string]int{}}
+	if configDir == "" {
+		return tr
+	}
+	tr.filename = filepath.Join(configDir, taxaFilename)
+	b, err := os.ReadFile(tr.filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Looking up every name again: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &tr.cache); err != nil {
+			log.Printf("Looking up every name again: %s: %v", tr.filename, err)
+			tr.cache = map[string]cachedTaxon{}
+		}
+	}
+	return tr
+}
+
+// resolve returns the iNaturalist taxon ID for rec, or 0 if it has none, and
+// the name to give iNaturalist as the species guess. An override for rec's
+// scientific name comes first (P-087): a taxon ID is used as
+// it is, and a name is looked up in place of rec's. Without one, only a
+// species or subspecies is looked up: a spuh, slash, hybrid, domestic type,
+// or form names no taxon iNaturalist is sure to have (P-082). A lookup that
+// fails is an error, not a name without a taxon: a rerun looks it up again.
+// A nil resolver resolves nothing.
+func (tr *taxonResolver) resolve(rec ebird.Record) (int, string, error) {
+	if tr == nil {
+		return 0, rec.ScientificName, nil
+	}
+	t, name, err := tr.taxon(rec)
+	if err != nil {
+		return 0, name, err
+	}
+	if t.ID == 0 {
+		tr.unresolved[name]++
+	}
+	return t.ID, name, nil
+}
+
+// taxon is resolve without the count of what it didn't resolve.
+func (tr *taxonResolver) taxon(rec ebird.Record) (cachedTaxon, string, error) {
+	name, t, ok := searchName(rec)
+	if !ok {
+		return t, name, nil
+	}
+	t, err := tr.lookup(name)
+	return t, name, err
+}
+
+// known returns the taxon ID of rec, or 0 if it has none, when that is known
+// without a lookup: from an override by ID, from rec naming no species or
+// subspecies, or from the cache. It reports false for a name only a lookup
+// would resolve. A nil resolver resolves nothing.
+func (tr *taxonResolver) known(rec ebird.Record) (int, bool) {
+	if tr == nil {
+		return 0, true
+	}
+	name, t, ok := searchName(rec)
+	if ok {
+		t, ok = tr.cached(name)
+		return t.ID, ok
+	}
+	return t.ID, true
+}
+
+// searchName returns the name rec's taxon is looked up by, and true, after
+// the overrides of P-087. A record that isn't looked up has its taxon
+// instead: an override's taxon ID, which has no ancestors, or none for a
+// spuh, slash, hybrid, domestic type, or form (P-082).
+func searchName(rec ebird.Record) (string, cachedTaxon, bool) {
+	override, ok := taxonOverrides[rec.ScientificName]
+	name := rec.ScientificName
+	switch c := rec.Taxon().Category; {
+	case ok:
+		if id, err := strconv.Atoi(override); err == nil {
+			return name, cachedTaxon{ID: id}, false
+		}
+		name = override
+	case c != ebird.Species && c != ebird.Subspecies:
+		return name, cachedTaxon{}, false
+	}
+	return name, cachedTaxon{}, true
+}
+
+// cached returns the cache's taxon for name, and whether it is still to be
+// trusted. A match cached before the cache kept ancestors isn't.
+func (tr *taxonResolver) cached(name string) (cachedTaxon, bool) {
+	c, ok := tr.cache[name]
+	return c, ok && time.Since(c.Resolved) < taxonCacheTTL && (c.ID == 0 || c.Ancestors != nil)
+}
+
+// lookup returns the taxon name resolves to, with an ID of 0 if there is no
+// confident match, from the cache if it can.
+func (tr *taxonResolver) lookup(name string) (cachedTaxon, error) {
+	if c, ok := tr.cached(name); ok {
+		return c, nil
+	}
+	if tr.stopped != nil {
+		return cachedTaxon{}, fmt.Errorf("looking up %s: %w", name, tr.stopped)
+	}
+	taxa, err := tr.inatClient.SearchTaxa(name)
+	if err != nil {
+		var budgetErr *inat.BudgetError
+		if errors.As(err, &budgetErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			tr.stopped = err
+		}
+		return cachedTaxon{}, fmt.Errorf("looking up %s: %w", name, err)
+	}
+	t, ok := confidentMatch(name, taxa)
+	if ok {
+		debugf("%s is iNaturalist taxon %d (%s)", name, t.ID, t.Name)
+	} else {
+		debugf("%s has no confident match on iNaturalist", name)
+	}
+	c := cachedTaxon{ID: t.ID, Name: t.Name, Ancestors: t.AncestorIDs, Resolved: time.Now().UTC()}
+	tr.cache[name] = c
+	// Saved under --dryrun too: like the mirror, the cache is a copy of
+	// what was read, not a record of anything done.
+	if tr.filename != "" {
+		if err := writeFileAtomic(tr.filename, tr.cache); err != nil {
+			log.Printf("Saving %s: %v", tr.filename, err)
+		}
+	}
+	return c, nil
+}
+
+// outOfBudget returns the error that stopped lookups if it was
+// --daily_requests running out, and otherwise nil.
+func (tr *taxonResolver) outOfBudget() *inat.BudgetError {
+	var budgetErr *inat.BudgetError
+	if tr == nil || !errors.As(tr.stopped, &budgetErr) {
+		return nil
+	}
+	return budgetErr
+}
+
+// confidentMatch returns the taxon in taxa that name stands for: the active
+// taxon with that scientific name, or failing that the one active taxon
+// iNaturalist files the name under as a synonym. A name that matches no
+// active taxon, or is a synonym of several, as a name can be after a split,
+// has none.
+func confidentMatch(name string, taxa []inat.Taxon) (inat.Taxon, bool) {
+	var synonyms []inat.Taxon
+	for _, t := range taxa {
+		if !t.IsActive {
+			continue
+		}
+		if strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+		if strings.EqualFold(t.MatchedTerm, name) {
+			synonyms = append(synonyms, t)
+		}
+	}
+	if len(synonyms) == 1 {
+		return synonyms[0], true
+	}
+	return inat.Taxon{}, false
+}
+
+// reportUnresolved logs each name resolve found no taxon for, with the number
+// of records of it, so that they can be added to --taxa_overrides before a
+// real run creates observations without a taxon.
+func (tr *taxonResolver) reportUnresolved() {
+	if tr == nil {
+		return
+	}
+	for _, name := range slices.Sorted(maps.Keys(tr.unresolved)) {
+		log.Printf("No iNaturalist taxon for %s (%d observations); add it to --taxa_overrides to set one",
+			name, tr.unresolved[name])
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// TestConfidentMatch checks which search results a name resolves to.
+//
+// Verifies: P-086.
+func TestConfidentMatch(t *testing.T) {
+	crow := inat.Taxon{ID: 8021, Name: "Corvus brachyrhynchos", Rank: "species", IsActive: true, AncestorIDs: []int{3, 7823, 8021}}
+	for _, tt := range []struct {
+		name   string
+		taxa   []inat.Taxon
+		wantID int
+	}{
+		{"exact name", []inat.Taxon{{ID: 7, Name: "Corvus", Rank: "genus", IsActive: true}, crow}, 8021},
+		{"inactive taxon", []inat.Taxon{{ID: 9, Name: "Corvus brachyrhynchos", Rank: "species"}}, 0},
+		{"one synonym", []inat.Taxon{{ID: 10, Name: "Corvus americanus", IsActive: true, MatchedTerm: "Corvus brachyrhynchos"}}, 10},
+		{"split", []inat.Taxon{
+			{ID: 11, Name: "Corvus caurinus", IsActive: true, MatchedTerm: "Corvus brachyrhynchos"},
+			{ID: 12, Name: "Corvus americanus", IsActive: true, MatchedTerm: "Corvus brachyrhynchos"},
+		}, 0},
+		{"common name only", []inat.Taxon{{ID: 13, Name: "Corvus corax", IsActive: true, MatchedTerm: "crow"}}, 0},
+		{"nothing", nil, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := confidentMatch("Corvus brachyrhynchos", tt.taxa)
+			if got.ID != tt.wantID || ok != (tt.wantID != 0) {
+				t.Errorf("confidentMatch() = %d, %v, want %d", got.ID, ok, tt.wantID)
+			}
+		})
+	}
+}
+
+// TestTaxonResolver checks that a created observation is given the taxon ID
+// its name resolves to, that each name is looked up once and then read from
+// the cache in --config_dir until it expires, and that a spuh isn't looked up.
+//
+// Verifies: P-086.
+func TestTaxonResolver(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	crow := inat.Taxon{ID: 8021, Name: "Corvus brachyrhynchos", Rank: "species", IsActive: true, AncestorIDs: []int{3, 7823, 8021}}
+	spuh := crowRecord("S981")
+	spuh.ScientificName = "Corvus sp."
+	mockInat := &mockINatClient{taxa: []inat.Taxon{crow}}
+
+	syncRun(t, mockInat, crowRecord("S980"), crowRecord("S982"), spuh)
+
+	got := map[string]int{}
+	identified := identifiedTaxa(mockInat)
+	for _, obs := range mockInat.created {
+		got[obs.SpeciesGuess] = identified[obs.UUID]
+	}
+	if want := map[string]int{"Corvus brachyrhynchos": 8021, "Corvus sp.": 0}; !maps.Equal(got, want) {
+		t.Errorf("created with taxon IDs %v, want %v", got, want)
+	}
+	if mockInat.searches != 1 {
+		t.Errorf("made %d searches, want 1", mockInat.searches)
+	}
+
+	later := &mockINatClient{taxa: []inat.Taxon{crow}}
+	if id, _, _ := newTaxonResolver(later).resolve(crowRecord("S983")); id != 8021 || later.searches != 0 {
+		t.Errorf("resolved from the cache to %d with %d searches, want 8021 with none", id, later.searches)
+	}
+
+	tr := newTaxonResolver(later)
+	c := tr.cache[crow.Name]
+	c.Resolved = time.Now().Add(-taxonCacheTTL)
+	tr.cache[crow.Name] = c
+	if tr.resolve(crowRecord("S983")); later.searches != 1 {
+		t.Errorf("expired entry looked up %d times, want once", later.searches)
+	}
+	if tr.filename != filepath.Join(configDir, taxaFilename) {
+		t.Errorf("cache file = %s, want %s in --config_dir", tr.filename, taxaFilename)
+	}
+}
+
+// TestTaxonLookupErrors checks that a record whose name can't be looked up is
+// skipped rather than created without a taxon ID, that the records after it
+// are still looked up, and that a rerun syncs it; and that a lookup that
+// finds --daily_requests spent stops the run, with the record left to do.
+//
+// Verifies: P-086, P-075.
+func TestTaxonLookupErrors(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	crow := inat.Taxon{ID: 8021, Name: "Corvus brachyrhynchos", Rank: "species", IsActive: true, AncestorIDs: []int{3, 7823, 8021}}
+	jay := crowRecord("S991")
+	jay.ScientificName = "Cyanocitta stelleri"
+	records := []ebird.Record{crowRecord("S990"), jay}
+	mockInat := &mockINatClient{taxa: []inat.Taxon{crow}, searchErr: errors.New("502 Bad Gateway")}
+
+	s := birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	mockInat.persist()
+	if len(mockInat.created) != 0 || s.lookupSkips != 2 || mockInat.searches != 2 {
+		t.Errorf("created %d and skipped %d after %d searches, want none created and 2 skipped after 2",
+			len(mockInat.created), s.lookupSkips, mockInat.searches)
+	}
+	if !slices.ContainsFunc(s.summary(), func(line string) bool { return strings.Contains(line, "Skipped 2 eBird observations whose taxon") }) {
+		t.Errorf("summary %q doesn't count the records skipped", s.summary())
+	}
+
+	mockInat.searchErr = nil
+	syncRun(t, mockInat, records...)
+	got := map[string]int{}
+	identified := identifiedTaxa(mockInat)
+	for _, obs := range mockInat.created {
+		got[obs.SpeciesGuess] = identified[obs.UUID]
+	}
+	if want := map[string]int{"Corvus brachyrhynchos": 8021, "Cyanocitta stelleri": 0}; !maps.Equal(got, want) {
+		t.Errorf("rerun created with taxon IDs %v, want %v", got, want)
+	}
+
+	configDir = t.TempDir()
+	spent := fmt.Errorf("SearchTaxa: %w", &inat.BudgetError{Limit: 10, Until: time.Now().Add(time.Hour)})
+	mockInat = &mockINatClient{searchErr: spent}
+	s = birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	if s.outOfBudget == nil || s.remaining != 2 || mockInat.searches != 1 || len(mockInat.created) != 0 {
+		t.Errorf("stats = %+v after %d searches and %d creates, want stopped with 2 left after 1 search",
+			s, mockInat.searches, len(mockInat.created))
+	}
+}
+
+// TestReadTaxonOverrides checks that an overrides file is read past its
+// header, comments, and blank rows, and that each mistake in one is reported.
+//
+// Verifies: P-087.
+func TestReadTaxonOverrides(t *testing.T) {
+	write := func(data string) string {
+		filename := filepath.Join(t.TempDir(), "overrides.csv")
+		if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	overrides, err := readTaxonOverrides(write("eBird,iNaturalist\n" +
+		"# scaup we never told apart\n" +
+		"Aythya marila/affinis,7004\n" +
+		"Cairina moschata (Domestic type), Cairina moschata domestica \n" +
+		",\n"))
+	if err != nil {
+		t.Fatalf("readTaxonOverrides() error = %v", err)
+	}
+	want := map[string]string{
+		"Aythya marila/affinis":            "7004",
+		"Cairina moschata (Domestic type)": "Cairina moschata domestica",
+	}
+	if !maps.Equal(overrides, want) {
+		t.Errorf("readTaxonOverrides() = %v, want %v", overrides, want)
+	}
+
+	_, err = readTaxonOverrides(write("eBird,iNaturalist\nAythya marila/affinis,7004\nAythya marila/affinis,7005\nAnas platyrhynchos\n"))
+	if err == nil {
+		t.Fatal("readTaxonOverrides() succeeded, want an error")
+	}
+	for _, want := range []string{":3: Aythya marila/affinis is listed twice", ":4: want an eBird name and an iNaturalist taxon"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("readTaxonOverrides() error missing %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestTaxonOverrides checks that an override of a scientific name sets the
+// taxon of the observation created, by ID or by a name looked up in its
+// place, and that a dry run names the records left without one.
+//
+// Verifies: P-087.
+func TestTaxonOverrides(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	taxonOverrides = map[string]string{
+		"Aythya marila/affinis":            "7004",
+		"Cairina moschata (Domestic type)": "Cairina moschata domestica",
+	}
+	scaup := crowRecord("S1001")
+	scaup.ScientificName = "Aythya marila/affinis"
+	muscovy := crowRecord("S1002")
+	muscovy.ScientificName = "Cairina moschata (Domestic type)"
+	scoter := crowRecord("S1004")
+	scoter.ScientificName = "Melanitta sp."
+	mockInat := &mockINatClient{taxa: []inat.Taxon{
+		{ID: 1, Name: "Cairina moschata domestica", Rank: "subspecies", IsActive: true},
+	}}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	dryRun = true
+	records := []ebird.Record{scaup, muscovy, scoter}
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	dryRun = false
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+
+	type taxon struct {
+		id    int
+		guess string
+	}
+	got := map[string]taxon{}
+	identified := identifiedTaxa(mockInat)
+	for _, obs := range mockInat.created {
+		for _, f := range obs.ObservationFieldValuesAttributes {
+			if f.ObservationFieldID == inat.EBirdField {
+				got[f.Value.(string)] = taxon{identified[obs.UUID], obs.SpeciesGuess}
+			}
+		}
+	}
+	want := map[string]taxon{
+		"S1001": {7004, "Aythya marila/affinis"},
+		"S1002": {1, "Cairina moschata domestica"},
+		"S1004": {0, "Melanitta sp."},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("created %v, want %v", got, want)
+	}
+	if n := strings.Count(logs.String(), "No iNaturalist taxon for"); n != 1 ||
+		!strings.Contains(logs.String(), "No iNaturalist taxon for Melanitta sp. (1 observations)") {
+		t.Errorf("the dry run reported %d names without a taxon, want Melanitta sp. alone:\n%s", n, logs.String())
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// runTaxaReport lists the observations birdsync created whose iNaturalist
+// taxon no longer agrees with their eBird name: birdsync taxa-report.
+func runTaxaReport() {
+	if len(flag.Args()) != 0 {
+		usage("birdsync taxa-report")
+	}
+	checkArgs(nil)
+	userID := inat.GetUserID()
+	report, err := taxaReport(interruptible(), newINatClient(userID), userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, line := range report.lines() {
+		log.Print(line)
+	}
+}
+
+// A taxonDisagreement is how an observation's iNaturalist taxon differs from
+// the taxon of the eBird name it was synced with.
+type taxonDisagreement int
+
+const (
+	// taxonDiffers is a taxon that is neither the eBird name's nor one of
+	// its ancestors or descendants.
+	taxonDiffers taxonDisagreement = iota
+	// taxonCoarser is one of the eBird name's ancestors, such as its genus.
+	taxonCoarser
+	// taxonUnresolved is no taxon at all.
+	taxonUnresolved
+)
+
+func (d taxonDisagreement) String() string {
+	switch d {
+	case taxonDiffers:
+		return "DIFFERS"
+	case taxonCoarser:
+		return "COARSER"
+	case taxonUnresolved:
+		return "UNRESOLVED"
+	}
+	return fmt.Sprintf("taxonDisagreement(%d)", int(d))
+}
+
+// A taxonMismatch is one observation taxa-report lists.
+type taxonMismatch struct {
+	disagreement taxonDisagreement
+	key          ebird.ObservationID
+	taxon        inat.Taxon // iNaturalist's
+	url          string     // the observation's
+}
+
+// A taxonReport is what taxaReport found.
+type taxonReport struct {
+	mismatches []taxonMismatch
+	// compared counts the synced observations checked, and uncompared
+	// those whose eBird name has no iNaturalist taxon to check against.
+	compared, uncompared int
+}
+
+// taxaReport compares the taxon of each observation birdsync created inside
+// the --after/--before window with the taxon its eBird scientific name, as
+// recorded in its sync key, resolves to (P-088). The names are resolved as a
+// sync resolves them, overrides and cache included.
+//
+// A taxon that is a descendant of the eBird name's, such as a subspecies the
+// community identified, agrees with it. A taxon whose eBird name has no
+// iNaturalist taxon, a spuh or slash without an override for instance, agrees
+// if the names are the same and is otherwise counted as uncompared: there is
+// nothing to tell a coarser identification from a different one by.
+//
+// The observations are read as a sync reads them (downloadIndex): from the
+// mirror with a --config_dir, and otherwise downloaded.
+func taxaReport(ctx context.Context, inatClient inatClient, inatUserID string) (taxonReport, error) {
+	var report taxonReport
+	ix, err := downloadIndex(ctx, inatClient, inatUserID)
+	if err != nil {
+		return taxonReport{}, fmt.Errorf("taxaReport: %w", err)
+	}
+	tr := newTaxonResolver(inatClient)
+	for key, o := range ix.previouslySynced {
+		var taxon inat.Taxon
+		if o.Taxon != nil {
+			taxon = *o.Taxon
+		}
+		report.compared++
+		mismatch := taxonMismatch{key: key, taxon: taxon, url: inat.ObservationURL(o.UUID)}
+		expected, _, err := tr.taxon(ebird.Record{ScientificName: key.ScientificName})
+		if err != nil {
+			return taxonReport{}, fmt.Errorf("taxaReport: %w", err)
+		}
+		switch {
+		case taxon.ID == 0:
+			mismatch.disagreement = taxonUnresolved
+		case taxon.ID == expected.ID,
+			strings.EqualFold(taxon.Name, key.ScientificName),
+			expected.ID != 0 && slices.Contains(taxon.AncestorIDs, expected.ID):
+			continue
+		case expected.ID == 0:
+			report.uncompared++
+			continue
+		case slices.Contains(expected.Ancestors, taxon.ID):
+			mismatch.disagreement = taxonCoarser
+		default:
+			mismatch.disagreement = taxonDiffers
+		}
+		report.mismatches = append(report.mismatches, mismatch)
+	}
+	slices.SortFunc(report.mismatches, func(a, b taxonMismatch) int {
+		return cmp.Or(
+			cmp.Compare(a.disagreement, b.disagreement),
+			cmp.Compare(a.key.SubmissionID, b.key.SubmissionID),
+			cmp.Compare(a.key.ScientificName, b.key.ScientificName))
+	})
+	return report, nil
+}
+
+// lines returns the report, one line per mismatch and then a summary. Each
+// mismatch names the eBird checklist first, since that is what gets
+// corrected.
+func (r taxonReport) lines() []string {
+	var lines []string
+	counts := map[taxonDisagreement]int{}
+	for _, m := range r.mismatches {
+		counts[m.disagreement]++
+		inatTaxon := "no taxon"
+		if m.taxon.ID != 0 {
+			inatTaxon = fmt.Sprintf("%s (%s)", m.taxon.Name, m.taxon.Rank)
+		}
+		checklist := ebird.Record{SubmissionID: m.key.SubmissionID}.URL()
+		lines = append(lines, fmt.Sprintf("%-10s  %s  eBird %s, iNaturalist %s  %s",
+			m.disagreement, checklist, m.key.ScientificName, inatTaxon, m.url))
+	}
+	lines = append(lines, fmt.Sprintf("Compared %d synced observations: %d differ, %d coarser, %d unresolved on iNaturalist",
+		r.compared, counts[taxonDiffers], counts[taxonCoarser], counts[taxonUnresolved]))
+	if r.uncompared > 0 {
+		lines = append(lines, fmt.Sprintf("Couldn't compare %d observations whose eBird name has no iNaturalist taxon; --taxa_overrides can give them one",
+			r.uncompared))
+	}
+	return lines
+}
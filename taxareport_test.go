@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sajmani/birdsync/inat"
+)
+
+// TestTaxaReport checks that a synced observation whose taxon differs from
+// its eBird name's, is coarser, or is missing is listed, and that one that
+// agrees, or is finer, isn't; and that with a --config_dir the report comes
+// from the mirror.
+//
+// Verifies: P-088, P-076.
+func TestTaxaReport(t *testing.T) {
+	resetFlags()
+	synced := func(submissionID, scientificName string, taxon inat.Taxon) inat.Result {
+		return inat.Result{Taxon: taxon, Ofvs: []inat.Ofv{
+			{FieldID: inat.EBirdField, Value: submissionID},
+			{FieldID: inat.EBirdScientificNameField, Value: scientificName},
+		}}
+	}
+	crow := inat.Taxon{ID: 8021, Name: "Corvus brachyrhynchos", Rank: "species", IsActive: true, AncestorIDs: []int{3, 7823, 8021}}
+	corvus := inat.Taxon{ID: 7823, Name: "Corvus", Rank: "genus", AncestorIDs: []int{3, 7823}}
+	raven := inat.Taxon{ID: 8010, Name: "Corvus corax", Rank: "species", AncestorIDs: []int{3, 7823, 8010}}
+	westernCrow := inat.Taxon{ID: 1289388, Name: "Corvus brachyrhynchos hesperis", Rank: "subspecies", AncestorIDs: []int{3, 7823, 8021, 1289388}}
+	melanitta := inat.Taxon{ID: 7000, Name: "Melanitta", Rank: "genus", AncestorIDs: []int{3, 7000}}
+	mockInat := &mockINatClient{
+		taxa: []inat.Taxon{crow},
+		observations: []inat.Result{
+			synced("S1", "Corvus brachyrhynchos", crow),
+			synced("S2", "Corvus brachyrhynchos", corvus),
+			synced("S3", "Corvus brachyrhynchos", raven),
+			synced("S4", "Corvus brachyrhynchos", inat.Taxon{}),
+			synced("S5", "Corvus brachyrhynchos", westernCrow),
+			synced("S6", "Melanitta sp.", melanitta),
+			{Taxon: raven}, // not birdsync's
+		},
+	}
+
+	report, err := taxaReport(context.Background(), mockInat, "myUserID")
+	if err != nil {
+		t.Fatalf("taxaReport() error = %v", err)
+	}
+	got := map[string]taxonDisagreement{}
+	for _, m := range report.mismatches {
+		got[m.key.SubmissionID] = m.disagreement
+	}
+	want := map[string]taxonDisagreement{"S2": taxonCoarser, "S3": taxonDiffers, "S4": taxonUnresolved}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+	if report.compared != 6 || report.uncompared != 1 {
+		t.Errorf("compared %d and couldn't compare %d, want 6 and 1", report.compared, report.uncompared)
+	}
+	if mockInat.searches != 1 {
+		t.Errorf("made %d searches, want 1", mockInat.searches)
+	}
+
+	lines := report.lines()
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "DIFFERS") ||
+		!strings.Contains(lines[0], "https://ebird.org/checklist/S3  eBird Corvus brachyrhynchos, iNaturalist Corvus corax (species)") {
+		t.Errorf("report lines:\n%s\nwant the raven first, by its checklist, and a summary", strings.Join(lines, "\n"))
+	}
+	if want := "Compared 6 synced observations: 1 differ, 1 coarser, 1 unresolved on iNaturalist"; lines[3] != want {
+		t.Errorf("summary = %q, want %q", lines[3], want)
+	}
+
+	// With a --config_dir, the report reads the mirror a sync keeps.
+	configDir = t.TempDir()
+	mirrored, err := taxaReport(context.Background(), mockInat, "myUserID")
+	if err != nil {
+		t.Fatalf("taxaReport() with --config_dir error = %v", err)
+	}
+	if !reflect.DeepEqual(mirrored.lines(), lines) {
+		t.Errorf("report from the mirror:\n%s\nwant:\n%s", strings.Join(mirrored.lines(), "\n"), strings.Join(lines, "\n"))
+	}
+	if _, err := os.Stat(filepath.Join(configDir, mirrorFilename)); err != nil {
+		t.Errorf("report left no mirror: %v", err)
+	}
+}
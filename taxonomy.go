@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Sajmani/birdsync/ebird"
+)
+
+// A taxonomyChange is one of eBird's renames: in the taxonomy of Version,
+// Old became New. A split is several changes from one Old, and a lump
+// several to one New.
+type taxonomyChange struct {
+	Version, Old, New string
+}
+
+// taxonomyChanges is the --taxonomy_changes file, read by checkArgs, in
+// version order (P-089).
+var taxonomyChanges []taxonomyChange
+
+// readTaxonomyChanges reads a --taxonomy_changes file: a CSV file whose
+// columns are the taxonomy version, such as 2024, the old scientific name,
+// and the new one. The first row is a header, and a row whose first column
+// starts with # is a comment. Versions are ordered as text, which orders
+// years.
+func readTaxonomyChanges(filename string) ([]taxonomyChange, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("readTaxonomyChanges: %w", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("readTaxonomyChanges(%s): reading the header: %w", filename, err)
+	}
+	var changes []taxonomyChange
+	var errs []error
+	seen := map[taxonomyChange]bool{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("readTaxonomyChanges(%s): %w", filename, err)
+		}
+		line, _ := r.FieldPos(0)
+		for len(row) < 3 {
+			row = append(row, "")
+		}
+		c := taxonomyChange{strings.TrimSpace(row[0]), strings.TrimSpace(row[1]), strings.TrimSpace(row[2])}
+		switch {
+		case c == taxonomyChange{}:
+			// A blank row, which spreadsheets leave at the end.
+		case c.Version == "" || c.Old == "" || c.New == "":
+			errs = append(errs, fmt.Errorf("%s:%d: want a version, an old name, and a new name", filename, line))
+		case c.Old == c.New:
+			errs = append(errs, fmt.Errorf("%s:%d: %s is renamed to itself", filename, line, c.Old))
+		case seen[c]:
+			errs = append(errs, fmt.Errorf("%s:%d: %s to %s in %s is listed twice", filename, line, c.Old, c.New, c.Version))
+		default:
+			seen[c] = true
+			changes = append(changes, c)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(changes, func(a, b taxonomyChange) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return changes, nil
+}
+
+// formerNames returns the names eBird used for name before taxonomyChanges
+// renamed them to it, the most recent first. A chain of renames is followed
+// back through earlier versions only, so a name eBird later restored isn't
+// taken for its own predecessor.
+func formerNames(name string) []string {
+	type step struct{ name, version string }
+	var names []string
+	seen := map[string]bool{name: true}
+	queue := []step{{name: name}}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, c := range slices.Backward(taxonomyChanges) {
+			if c.New != s.name || (s.version != "" && c.Version >= s.version) || seen[c.Old] {
+				continue
+			}
+			seen[c.Old] = true
+			names = append(names, c.Old)
+			queue = append(queue, step{c.Old, c.Version})
+		}
+	}
+	return names
+}
+
+// renamed returns the observation birdsync synced key as before eBird renamed
+// its species, and the sync key it carries, if there is one (P-089).
+func (ix syncIndex) renamed(key ebird.ObservationID) (indexedObservation, ebird.ObservationID, bool) {
+	for _, name := range formerNames(key.ScientificName) {
+		former := ebird.ObservationID{SubmissionID: key.SubmissionID, ScientificName: name}
+		if r, ok := ix.previouslySynced[former]; ok {
+			return r, former, true
+		}
+	}
+	return indexedObservation{}, ebird.ObservationID{}, false
+}
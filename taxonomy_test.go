@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// TestReadTaxonomyChanges checks that a taxonomy changes file is read in
+// version order past its header, comments, and blank rows, and that each
+// mistake in one is reported.
+//
+// Verifies: P-089.
+func TestReadTaxonomyChanges(t *testing.T) {
+	write := func(data string) string {
+		filename := filepath.Join(t.TempDir(), "taxonomy.csv")
+		if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	changes, err := readTaxonomyChanges(write("version,old,new\n" +
+		"2024,Corvus caurinus,Corvus brachyrhynchos\n" +
+		"# split\n" +
+		"2023,Aphelocoma californica,Aphelocoma woodhouseii\n" +
+		"2023, Aphelocoma californica , Aphelocoma californica woodhouseii\n" +
+		",,\n"))
+	if err != nil {
+		t.Fatalf("readTaxonomyChanges() error = %v", err)
+	}
+	want := []taxonomyChange{
+		{"2023", "Aphelocoma californica", "Aphelocoma woodhouseii"},
+		{"2023", "Aphelocoma californica", "Aphelocoma californica woodhouseii"},
+		{"2024", "Corvus caurinus", "Corvus brachyrhynchos"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("readTaxonomyChanges() = %v, want %v", changes, want)
+	}
+
+	_, err = readTaxonomyChanges(write("version,old,new\n" +
+		"2024,Corvus caurinus\n" +
+		"2024,Corvus corax,Corvus corax\n" +
+		"2023,Pica hudsonia,Pica pica\n" +
+		"2023,Pica hudsonia,Pica pica\n"))
+	if err == nil {
+		t.Fatal("readTaxonomyChanges() succeeded, want an error")
+	}
+	for _, want := range []string{
+		":2: want a version, an old name, and a new name",
+		":3: Corvus corax is renamed to itself",
+		":5: Pica hudsonia to Pica pica in 2023 is listed twice",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("readTaxonomyChanges() error missing %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestFormerNames checks that a chain of renames is followed back, the most
+// recent first, and that a rename eBird later undid isn't.
+//
+// Verifies: P-089.
+func TestFormerNames(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	taxonomyChanges = []taxonomyChange{
+		{"2022", "Anser albifrons", "Anser albifrons frontalis"},
+		{"2023", "Oldest name", "Old name"},
+		{"2024", "Old name", "New name"},
+		{"2024", "Anser albifrons frontalis", "Anser albifrons"},
+	}
+	for name, want := range map[string][]string{
+		"New name":                  {"Old name", "Oldest name"},
+		"Old name":                  {"Oldest name"},
+		"Oldest name":               nil,
+		"Anser albifrons":           {"Anser albifrons frontalis"},
+		"Anser albifrons frontalis": {"Anser albifrons"},
+	} {
+		if got := formerNames(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("formerNames(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestTaxonomyChangeRekeys checks that a record eBird has renamed since it was
+// synced re-keys the observation synced under the old name rather than
+// creating another, once, even when the old name was split, replacing its
+// scientific name value rather than adding a second.
+//
+// Verifies: P-089, P-020.
+func TestTaxonomyChangeRekeys(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	configDir = t.TempDir()
+	taxonomyChanges = []taxonomyChange{
+		{"2024", "Corvus caurinus", "Corvus brachyrhynchos"},
+		{"2024", "Aphelocoma californica", "Aphelocoma woodhouseii"},
+	}
+	synced := func(submissionID, scientificName string) inat.Result {
+		return inat.Result{
+			UUID:        uuid.New(),
+			ObservedOn:  "2023-01-03",
+			Description: mlAssetURL("11111"),
+			Sounds:      []inat.Sound{{OriginalFilename: "ML11111.mp3"}},
+			Ofvs: []inat.Ofv{
+				{FieldID: inat.EBirdField, Value: submissionID},
+				{FieldID: inat.EBirdScientificNameField, Value: scientificName},
+			},
+		}
+	}
+	mockInat := &mockINatClient{observations: []inat.Result{
+		synced("S1001", "Corvus caurinus"),
+		synced("S1002", "Aphelocoma californica"),
+	}}
+	crow := crowRecord("S1001")
+	woodhouses := crowRecord("S1002")
+	woodhouses.ScientificName = "Aphelocoma woodhouseii"
+	californias := crowRecord("S1002")
+	californias.ScientificName = "Aphelocoma californica"
+
+	syncRun(t, mockInat, crow, woodhouses, californias)
+
+	// The split's first record re-keys the observation, and the record of
+	// the other half of the split, in the same checklist, is created.
+	if len(mockInat.created) != 1 || mockInat.created[0].SpeciesGuess != "Aphelocoma californica" {
+		t.Errorf("created %d observations, want Aphelocoma californica alone: %+v", len(mockInat.created), mockInat.created)
+	}
+	rekeys := func() map[uuid.UUID]string {
+		got := map[uuid.UUID]string{}
+		for _, obs := range mockInat.updated {
+			for _, f := range obs.ObservationFieldValuesAttributes {
+				if f.ObservationFieldID == inat.EBirdScientificNameField {
+					if _, ok := got[obs.UUID]; ok {
+						t.Errorf("re-keyed %s twice", obs.UUID)
+					}
+					got[obs.UUID] = f.Value.(string)
+				}
+			}
+		}
+		return got
+	}
+	want := map[uuid.UUID]string{
+		mockInat.observations[0].UUID: "Corvus brachyrhynchos",
+		mockInat.observations[1].UUID: "Aphelocoma woodhouseii",
+	}
+	if got := rekeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("re-keyed %v, want %v", got, want)
+	}
+	// The mock refuses a second value of the field, so a re-key that
+	// didn't name the value it replaces would have ended the run.
+	for _, r := range mockInat.observations[:2] {
+		n := 0
+		for _, ofv := range r.Ofvs {
+			if ofv.FieldID == inat.EBirdScientificNameField {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("%s has %d eBird Scientific Name values, want 1", r.UUID, n)
+		}
+	}
+
+	updated := len(mockInat.updated)
+	syncRun(t, mockInat, crow, woodhouses, californias)
+	if len(mockInat.created) != 1 || len(mockInat.updated) != updated {
+		t.Errorf("second run created %d and updated %d more, want none",
+			len(mockInat.created)-1, len(mockInat.updated)-updated)
+	}
+}
+
+// TestApplyRekeys checks that a plan records a re-key with the key it
+// replaces, that apply carries it out, and that apply refuses it once the
+// observation carries the new key.
+//
+// Verifies: P-089, P-070.
+func TestApplyRekeys(t *testing.T) {
+	for _, rekeyedSince := range []bool{false, true} {
+		resetFlags()
+		taxonomyChanges = []taxonomyChange{{"2024", "Turdus migratorius old", "Turdus migratorius"}}
+		mockEbird, mockInat := planFixture()
+		mockInat.observations[0].Ofvs[1].Value = "Turdus migratorius old"
+		p := makePlan([]string{"MyEBirdData.csv"}, mockEbird, "myUserID", mockInat)
+		if a := p.Actions[1]; a.FormerKey == nil || a.FormerKey.ScientificName != "Turdus migratorius old" {
+			t.Fatalf("planned %+v, want a re-key from Turdus migratorius old", a)
+		}
+
+		if rekeyedSince {
+			mockInat.observations[0].Ofvs[1].Value = "Turdus migratorius"
+		}
+		_, err := applyPlan(context.Background(), p, mockEbird, mockInat)
+		if rekeyedSince {
+			if err == nil || len(mockInat.updated) != 0 {
+				t.Errorf("applyPlan() = %v with %d updates, want a conflict and none", err, len(mockInat.updated))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("applyPlan: %v", err)
+		}
+		rekeyed := false
+		for _, obs := range mockInat.updated {
+			for _, f := range obs.ObservationFieldValuesAttributes {
+				rekeyed = rekeyed || obs.UUID == mockInat.observations[0].UUID &&
+					f.ObservationFieldID == inat.EBirdScientificNameField && f.Value == "Turdus migratorius"
+			}
+		}
+		if !rekeyed {
+			t.Errorf("updated %+v, want the observation re-keyed", mockInat.updated)
+		}
+	}
+	resetFlags()
+}
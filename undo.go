@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// runUndo deletes the observations one run created: birdsync undo RUN.
+// Without a run, it lists the runs in the journal.
+func runUndo() {
+	if len(flag.Args()) > 1 {
+		usage("birdsync undo [run]")
+	}
+	if configDir == "" {
+		log.Fatal("birdsync undo works from the journal, and --config_dir is empty")
+	}
+	entries, err := readJournal(filepath.Join(configDir, journalFilename))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(flag.Args()) == 0 {
+		listRuns(entries)
+		return
+	}
+	start, err := findRun(entries, flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Deleting needs the token of the user whose observations they are, and
+	// the prompt would otherwise be asking for the wrong one.
+	if userID := inat.GetUserID(); userID != start.UserID {
+		log.Fatalf("Run %s synced to iNaturalist user %s, not %s", start.Run, start.UserID, userID)
+	}
+	if err := undoRun(entries, start, newINatClient(start.UserID), confirmDelete); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// listRuns logs each run in the journal, oldest first, with how many
+// observations it created.
+func listRuns(entries []journalEntry) {
+	creates := map[string]int{}
+	for _, e := range entries {
+		if e.Op == opCreate && e.Error == "" {
+			creates[e.Run]++
+		}
+	}
+	for _, e := range entries {
+		if e.Op != opStart {
+			continue
+		}
+		what := e.CSV
+		if e.Undo != "" {
+			what = "undo of " + e.Undo
+		}
+		log.Printf("%s  %s  %-5s  %s  created %d observations",
+			e.Run, e.Time.Local().Format("2006-01-02 15:04"), e.Command, what, creates[e.Run])
+	}
+}
+
+// findRun returns the start entry of the run whose ID is, or uniquely begins
+// with, id. A prefix is enough because run IDs are long.
+func findRun(entries []journalEntry, id string) (journalEntry, error) {
+	var found []journalEntry
+	for _, e := range entries {
+		if e.Op == opStart && strings.HasPrefix(e.Run, id) {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return journalEntry{}, fmt.Errorf("findRun(%s): no such run in the journal; run birdsync undo to list them", id)
+	case 1:
+		return found[0], nil
+	}
+	return journalEntry{}, fmt.Errorf("findRun(%s): matches %d runs; give more of the run ID", id, len(found))
+}
+
+// A createdObservation is one the journal says a run created.
+type createdObservation struct {
+	uuid uuid.UUID
+	key  ebird.ObservationID
+}
+
+// createdBy returns the observations that run created, in the order it
+// created them. A create that failed created nothing. Each has the sync key
+// birdsync last gave it: the one it was created with, or the one a later run
+// re-keyed it to (P-089).
+func createdBy(entries []journalEntry, run string) []createdObservation {
+	var created []createdObservation
+	byUUID := map[string]int{}
+	for _, e := range entries {
+		if e.Error != "" || e.Key == nil {
+			continue
+		}
+		if i, ok := byUUID[e.UUID]; ok && e.Op == opUpdate && e.FormerKey != nil && *e.FormerKey == created[i].key {
+			created[i].key = *e.Key
+			continue
+		}
+		if e.Run != run || e.Op != opCreate {
+			continue
+		}
+		u, err := uuid.Parse(e.UUID)
+		if err != nil {
+			log.Printf("Journal entry for %s has a bad UUID %q; skipping it", e.Key, e.UUID)
+			continue
+		}
+		byUUID[e.UUID] = len(created)
+		created = append(created, createdObservation{uuid: u, key: *e.Key})
+	}
+	return created
+}
+
+// undoRun deletes the observations created by the run that start begins,
+// once confirm has approved the list.
+//
+// It deletes only observations that still carry the sync key the journal
+// recorded for them (P-072), or that a later re-key recorded. The journal says
+// what birdsync created; the sync key says the observation is still
+// birdsync's. One whose key has been removed
+// or changed since has been taken over by its owner, and P-005 leaves it alone.
+func undoRun(entries []journalEntry, start journalEntry, inatClient inatClient, confirm func(n int) bool) error {
+	created := createdBy(entries, start.Run)
+	if len(created) == 0 {
+		log.Printf("Run %s created no observations; nothing to undo", start.Run)
+		return nil
+	}
+	uuids := make([]uuid.UUID, len(created))
+	for i, c := range created {
+		uuids[i] = c.uuid
+	}
+	results, err := inatClient.GetObservations(uuids, "observed_on", "taxon.all", "ofvs.all")
+	if err != nil {
+		return fmt.Errorf("undoRun: %w", err)
+	}
+	existing := map[uuid.UUID]inat.Result{}
+	for _, r := range results {
+		existing[r.UUID] = r
+	}
+
+	var toDelete []createdObservation
+	for _, c := range created {
+		r, ok := existing[c.uuid]
+		if !ok {
+			log.Printf("%s (%s) no longer exists", inat.ObservationURL(c.uuid), c.key)
+			continue
+		}
+		key := ebird.ObservationID{
+			SubmissionID:   r.ObservationFieldValue(inat.EBirdField),
+			ScientificName: r.ObservationFieldValue(inat.EBirdScientificNameField),
+		}
+		if key != c.key {
+			log.Printf("LEAVING %s: its sync key is now %q, not %q as birdsync created it",
+				r.URLWithSpecies(), key, c.key)
+			continue
+		}
+		log.Printf("Will delete %s observed %s (%s)", r.URLWithSpecies(), r.ObservedOn, c.key)
+		toDelete = append(toDelete, c)
+	}
+	if len(toDelete) == 0 {
+		log.Printf("Nothing left to undo for run %s", start.Run)
+		return nil
+	}
+
+	if dryRun {
+		for _, c := range toDelete {
+			log.Printf("DRYRUN: Delete %s", inat.ObservationURL(c.uuid))
+		}
+		log.Printf("Would delete %d iNaturalist observations", len(toDelete))
+		return nil
+	}
+	if !confirm(len(toDelete)) {
+		log.Print("Nothing deleted")
+		return nil
+	}
+	j := startRun(journalEntry{
+		Command: "undo",
+		UserID:  start.UserID,
+		Undo:    start.Run,
+	})
+	var deleted, failed int
+	for _, c := range toDelete {
+		err := inatClient.DeleteObservation(c.uuid)
+		j.record(journalEntry{
+			Op:    opDelete,
+			Key:   &c.key,
+			UUID:  c.uuid.String(),
+			Error: errorString(err),
+		})
+		if err != nil {
+			// One refusal is no reason to leave the rest of a bad run in
+			// place; the failures are reported, and undo can be run again.
+			log.Printf("Couldn't delete %s: %v", inat.ObservationURL(c.uuid), err)
+			failed++
+			continue
+		}
+		deleted++
+	}
+	j.end(nil)
+	log.Printf("Deleted %d iNaturalist observations", deleted)
+	if failed > 0 {
+		log.Printf("Failed to delete %d iNaturalist observations; run birdsync undo %s again to retry",
+			failed, start.Run)
+	}
+	return nil
+}
+
+// confirmDelete asks on the terminal before anything is deleted. Deletion is
+// the one thing birdsync does that a later run cannot put right.
+func confirmDelete(n int) bool {
+	fmt.Fprintf(os.Stderr, "Delete these %d observations from iNaturalist? This can't be undone. Type yes to confirm: ", n)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes"
+}
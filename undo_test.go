@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Sajmani/birdsync/ebird"
+	"github.com/Sajmani/birdsync/inat"
+	"github.com/google/uuid"
+)
+
+// syncRun syncs records into mockInat, as a run with its own journal entries,
+// and returns the run's start entry.
+func syncRun(t *testing.T, mockInat *mockINatClient, records ...ebird.Record) journalEntry {
+	t.Helper()
+	birdsync(context.Background(), []string{"MyEBirdData.csv"}, &mockEBirdClient{records: records}, "myUserID", mockInat)
+	mockInat.persist()
+	entries := readTestJournal(t)
+	for _, e := range slices.Backward(entries) {
+		if e.Op == opStart {
+			return e
+		}
+	}
+	t.Fatal("journal has no runs")
+	return journalEntry{}
+}
+
+func readTestJournal(t *testing.T) []journalEntry {
+	t.Helper()
+	entries, err := readJournal(filepath.Join(configDir, journalFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func crowRecord(submissionID string) ebird.Record {
+	return ebird.Record{
+		Line:           2,
+		SubmissionID:   submissionID,
+		ScientificName: "Corvus brachyrhynchos",
+		CommonName:     "American Crow",
+		Date:           "2023-01-03",
+		Time:           "03:00 PM",
+		// Unverifiable records aren't synced by default.
+		MLCatalogNumbers: "11111",
+	}
+}
+
+// TestUndoDeletesOnlyThatRun checks that undo deletes what the given run
+// created, and nothing created by another run, and journals the deletes.
+//
+// Verifies: P-072.
+func TestUndoDeletesOnlyThatRun(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	mockInat := &mockINatClient{}
+	first := syncRun(t, mockInat, crowRecord("S600"), crowRecord("S601"))
+	syncRun(t, mockInat, crowRecord("S602"))
+
+	if err := undoRun(readTestJournal(t), first, mockInat, func(int) bool { return true }); err != nil {
+		t.Fatalf("undoRun: %v", err)
+	}
+	want := []uuid.UUID{mockInat.created[0].UUID, mockInat.created[1].UUID}
+	if !slices.Equal(mockInat.deleted, want) {
+		t.Errorf("deleted %v, want %v", mockInat.deleted, want)
+	}
+
+	entries := readTestJournal(t)
+	var start journalEntry
+	var deletes int
+	for _, e := range entries {
+		if e.Op == opStart && e.Command == "undo" {
+			start = e
+		}
+		if e.Op == opDelete && e.Run == start.Run && e.Error == "" {
+			deletes++
+		}
+	}
+	if start.Undo != first.Run || deletes != 2 {
+		t.Errorf("journal records an undo of %q with %d deletes, want an undo of %q with 2", start.Undo, deletes, first.Run)
+	}
+}
+
+// TestUndoLeavesWhatIsNoLongerBirdsyncs checks that undo doesn't delete an
+// observation whose sync key has been changed since birdsync created it, or
+// try to delete one that is already gone.
+//
+// Verifies: P-072, P-005.
+func TestUndoLeavesWhatIsNoLongerBirdsyncs(t *testing.T) {
+	resetFlags()
+	configDir = t.TempDir()
+	mockInat := &mockINatClient{}
+	run := syncRun(t, mockInat, crowRecord("S600"), crowRecord("S601"), crowRecord("S602"))
+	mockInat.observations = mockInat.observations[1:] // its owner deleted S600
+	for i, f := range mockInat.observations[0].Ofvs { // and repointed S601
+		if f.FieldID == inat.EBirdField {
+			mockInat.observations[0].Ofvs[i].Value = "S999"
+		}
+	}
+
+	if err := undoRun(readTestJournal(t), run, mockInat, func(int) bool { return true }); err != nil {
+		t.Fatalf("undoRun: %v", err)
+	}
+	if want := []uuid.UUID{mockInat.created[2].UUID}; !slices.Equal(mockInat.deleted, want) {
+		t.Errorf("deleted %v, want %v", mockInat.deleted, want)
+	}
+}
+
+// TestUndoDeletesNothingUnconfirmed checks that neither a dry run nor a
+// declined confirmation deletes anything.
+//
+// Verifies: P-072.
+func TestUndoDeletesNothingUnconfirmed(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dryRun  bool
+		confirm bool
+	}{
+		{"dry run", true, true},
+		{"declined", false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetFlags()
+			configDir = t.TempDir()
+			mockInat := &mockINatClient{}
+			run := syncRun(t, mockInat, crowRecord("S600"))
+			entries := readTestJournal(t)
+
+			dryRun = tc.dryRun
+			defer func() { dryRun = false }()
+			asked := false
+			confirm := func(int) bool { asked = true; return tc.confirm }
+			if err := undoRun(entries, run, mockInat, confirm); err != nil {
+				t.Fatalf("undoRun: %v", err)
+			}
+			if len(mockInat.deleted) != 0 {
+				t.Errorf("deleted %v, want nothing", mockInat.deleted)
+			}
+			if tc.dryRun && asked {
+				t.Error("a dry run asked for confirmation")
+			}
+			if n := len(readTestJournal(t)); n != len(entries) {
+				t.Errorf("journal grew from %d entries to %d, want no new run", len(entries), n)
+			}
+		})
+	}
+}
+
+// TestFindRun checks that a run can be named by a unique prefix of its ID.
+func TestFindRun(t *testing.T) {
+	entries := []journalEntry{
+		{Run: "abc1", Op: opStart},
+		{Run: "abc1", Op: opEnd},
+		{Run: "abd2", Op: opStart},
+	}
+	if e, err := findRun(entries, "abd"); err != nil || e.Run != "abd2" {
+		t.Errorf("findRun(abd) = %q, %v; want abd2", e.Run, err)
+	}
+	if _, err := findRun(entries, "ab"); err == nil {
+		t.Error("findRun(ab) matched one of two runs")
+	}
+	if _, err := findRun(entries, "x"); err == nil {
+		t.Error("findRun(x) matched a run")
+	}
+}
+
+// TestUndoFollowsRekey checks that undo deletes an observation a later run
+// re-keyed to eBird's new name, which the journal records.
+//
+// Verifies: P-072, P-089.
+func TestUndoFollowsRekey(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	configDir = t.TempDir()
+	mockInat := &mockINatClient{}
+	northwestern := crowRecord("S600")
+	northwestern.ScientificName = "Corvus caurinus"
+	run := syncRun(t, mockInat, northwestern)
+
+	taxonomyChanges = []taxonomyChange{{"2024", "Corvus caurinus", "Corvus brachyrhynchos"}}
+	syncRun(t, mockInat, crowRecord("S600"))
+	if len(mockInat.created) != 1 || len(mockInat.updated) == 0 {
+		t.Fatalf("created %d and updated %d observations, want the first re-keyed", len(mockInat.created), len(mockInat.updated))
+	}
+
+	if err := undoRun(readTestJournal(t), run, mockInat, func(int) bool { return true }); err != nil {
+		t.Fatalf("undoRun: %v", err)
+	}
+	if want := []uuid.UUID{mockInat.created[0].UUID}; !slices.Equal(mockInat.deleted, want) {
+		t.Errorf("deleted %v, want %v", mockInat.deleted, want)
+	}
+}